@@ -0,0 +1,178 @@
+package easybot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BroadcastRequest struct {
+	Message  MessageRequest    `json:"message"`
+	Metadata map[string]string `json:"metadata,omitempty"` // only rooms with matching metadata are targeted.
+}
+
+type BroadcastResponse struct {
+	ID         primitive.ObjectID `json:"id"`
+	BotID      primitive.ObjectID `json:"botID"`
+	Text       string             `json:"text"`
+	Metadata   map[string]string  `json:"metadata,omitempty"`
+	Status     BroadcastStatus    `json:"status"`
+	Total      int                `json:"total"`
+	Sent       int                `json:"sent"`
+	Error      string             `json:"error,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	FinishedAt *time.Time         `json:"finishedAt,omitempty"`
+}
+
+// NewBroadcastResponse returns a BroadcastResponse for b.
+func NewBroadcastResponse(b Broadcast) BroadcastResponse {
+	return BroadcastResponse{
+		ID:         b.ID,
+		BotID:      b.BotID,
+		Text:       b.Text,
+		Metadata:   b.Metadata,
+		Status:     b.Status,
+		Total:      b.Total,
+		Sent:       b.Sent,
+		Error:      b.Error,
+		CreatedAt:  b.CreatedAt,
+		FinishedAt: b.FinishedAt,
+	}
+}
+
+// CreateBroadcast is a handler for broadcasting a bot message to rooms.
+// The broadcast runs in background; its progress can be queried with
+// GetBroadcast.
+func (server *Server) CreateBroadcast(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	var body BroadcastRequest
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if body.Message.Text == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "text is required")
+	}
+	if err := validateMetadata(body.Metadata); err != nil {
+		return err
+	}
+	b, err := server.db.CreateBroadcast(context.TODO(), Broadcast{
+		BotID:     bot.ID,
+		Text:      body.Message.Text,
+		Metadata:  body.Metadata,
+		Status:    BroadcastPending,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("create broadcast: %w", err)
+	}
+	go server.runBroadcast(b)
+	return c.Status(fiber.StatusAccepted).JSON(NewBroadcastResponse(b))
+}
+
+// ListBroadcasts is a handler for listing broadcasts of a bot.
+// TODO: use pagination
+func (server *Server) ListBroadcasts(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	bs, err := server.db.GetBroadcasts(context.TODO(), bot.ID)
+	if err != nil {
+		return fmt.Errorf("get broadcasts: %w", err)
+	}
+	resp := make([]BroadcastResponse, len(bs))
+	for i, b := range bs {
+		resp[i] = NewBroadcastResponse(b)
+	}
+	return c.JSON(fiber.Map{
+		"broadcasts": resp,
+	})
+}
+
+// GetBroadcast is a handler for getting a broadcast.
+func (server *Server) GetBroadcast(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	id, err := primitive.ObjectIDFromHex(c.Params("broadcast"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("broadcast %s not found", c.Params("broadcast")))
+	}
+	b, err := server.db.GetBroadcast(context.TODO(), id)
+	if err != nil || b.BotID != bot.ID {
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("get broadcast: %w", err)
+		}
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("broadcast %s not found", id))
+	}
+	return c.JSON(NewBroadcastResponse(b))
+}
+
+// ResumeBroadcasts resumes broadcasts which were pending or running when the
+// server stopped. It should be called once before the server starts.
+func (server *Server) ResumeBroadcasts(ctx context.Context) error {
+	bs, err := server.db.GetUnfinishedBroadcasts(ctx)
+	if err != nil {
+		return fmt.Errorf("get unfinished broadcasts: %w", err)
+	}
+	for _, b := range bs {
+		go server.runBroadcast(b)
+	}
+	return nil
+}
+
+// runBroadcast sends the broadcast message to every targeted room, recording
+// its progress. It stops when the server shuts down, leaving the broadcast
+// to be resumed.
+func (server *Server) runBroadcast(b Broadcast) {
+	ctx := server.ctx
+	if err := server.broadcast(ctx, &b); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		now := time.Now()
+		b.Status = BroadcastFailed
+		b.Error = err.Error()
+		b.FinishedAt = &now
+		if err := server.db.UpdateBroadcast(ctx, b); err != nil {
+			log.Printf("update broadcast %s: %v", b.ID.Hex(), err)
+		}
+	}
+}
+
+func (server *Server) broadcast(ctx context.Context, b *Broadcast) error {
+	rooms, err := server.db.FindRooms(ctx, b.BotID, b.Metadata)
+	if err != nil {
+		return fmt.Errorf("find rooms: %w", err)
+	}
+	b.Status = BroadcastRunning
+	b.Total = len(rooms)
+	b.Sent = 0
+	if err := server.db.UpdateBroadcast(ctx, *b); err != nil {
+		return fmt.Errorf("update broadcast: %w", err)
+	}
+	for _, room := range rooms {
+		// The client ID makes the message unique in the room, so that a
+		// resumed broadcast skips rooms it has already sent to.
+		if _, err := server.createMessages(ctx, b.BotID, []Message{{
+			RoomID:    room.ID,
+			Type:      BotMessage,
+			Text:      b.Text,
+			ClientID:  ServerClientIDPrefix + "broadcast-" + b.ID.Hex(),
+			CreatedAt: time.Now(),
+		}}); err != nil && !errors.Is(err, ErrDuplicate) {
+			return fmt.Errorf("create messages: %w", err)
+		}
+		b.Sent++
+		if err := server.db.UpdateBroadcast(ctx, *b); err != nil {
+			return fmt.Errorf("update broadcast: %w", err)
+		}
+	}
+	now := time.Now()
+	b.Status = BroadcastDone
+	b.FinishedAt = &now
+	if err := server.db.UpdateBroadcast(ctx, *b); err != nil {
+		return fmt.Errorf("update broadcast: %w", err)
+	}
+	return nil
+}
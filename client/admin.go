@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/hallazzang/easybot"
+)
+
+// ExportOptions are the options of Client.Export.
+type ExportOptions struct {
+	Bots       []string             // IDs of bots to export; all bots if empty.
+	AccessKeys bool                 // include access keys.
+	Format     easybot.ExportFormat // easybot.ExportNDJSON if empty.
+}
+
+// ImportOptions are the options of Client.Import.
+type ImportOptions struct {
+	Format   easybot.ExportFormat   // easybot.ExportNDJSON if empty.
+	Conflict easybot.ConflictPolicy // easybot.ConflictSkip if empty.
+}
+
+// Export writes an export of bots, with their rooms and messages, to w. The
+// client's access key must be the server's admin key.
+func (c *Client) Export(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	u, _ := c.serverURL.Parse("/v1/admin/export")
+	q := url.Values{}
+	for _, id := range opts.Bots {
+		q.Add("bot", id)
+	}
+	if opts.AccessKeys {
+		q.Set("accessKeys", "true")
+	}
+	if opts.Format != "" {
+		q.Set("format", string(opts.Format))
+	}
+	u.RawQuery = q.Encode()
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(context.WithValue(ctx, noTimeoutKey{}, true))
+	req.Header.Set(easybot.HeaderAccessKey, c.accessKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	return nil
+}
+
+// Import imports an export read from r. The client's access key must be the
+// server's admin key.
+func (c *Client) Import(ctx context.Context, r io.Reader, opts ImportOptions) (easybot.ImportResponse, error) {
+	u, _ := c.serverURL.Parse("/v1/admin/import")
+	format := opts.Format
+	if format == "" {
+		format = easybot.ExportNDJSON
+	}
+	q := url.Values{"format": {string(format)}}
+	if opts.Conflict != "" {
+		q.Set("conflict", string(opts.Conflict))
+	}
+	u.RawQuery = q.Encode()
+	req, _ := http.NewRequest("POST", u.String(), r)
+	req = req.WithContext(context.WithValue(ctx, noTimeoutKey{}, true))
+	req.Header.Set(easybot.HeaderAccessKey, c.accessKey)
+	if format == easybot.ExportTar {
+		req.Header.Set("Content-Type", easybot.MIMEApplicationTar)
+	} else {
+		req.Header.Set("Content-Type", easybot.MIMEApplicationNDJSON)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return easybot.ImportResponse{}, fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return easybot.ImportResponse{}, err
+	}
+	var body easybot.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return easybot.ImportResponse{}, fmt.Errorf("decode body: %w", err)
+	}
+	return body, nil
+}
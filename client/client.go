@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/google/uuid"
+
 	"github.com/hallazzang/easybot"
 )
 
@@ -16,28 +18,81 @@ type Client struct {
 	accessKey  string
 	serverURL  *url.URL
 	httpClient *http.Client
+	logger     Logger
 }
 
-func New(configs ...Config) (*Client, error) {
-	c := &Client{
-		httpClient: &http.Client{},
-	}
+// New returns a new Client. Options are applied in order over DefaultConfig.
+// It returns an error if the server URL or the access key is malformed.
+func New(opts ...Option) (*Client, error) {
 	cfg := DefaultConfig
-	for _, c := range configs {
-		if c.ServerURL != "" {
-			cfg.ServerURL = c.ServerURL
-		}
-		if c.AccessKey != "" {
-			cfg.AccessKey = c.AccessKey
-		}
+	for _, opt := range opts {
+		opt.apply(&cfg)
 	}
-	c.accessKey = cfg.AccessKey
 	u, err := url.Parse(cfg.ServerURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse server url: %w", err)
 	}
-	c.serverURL = u
-	return c, nil
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid server url %q: must be an absolute http(s) url", cfg.ServerURL)
+	}
+	if cfg.AccessKey != "" {
+		if _, err := uuid.Parse(cfg.AccessKey); err != nil {
+			return nil, fmt.Errorf("invalid access key %q: must be a uuid", cfg.AccessKey)
+		}
+	}
+	return &Client{
+		accessKey:  cfg.AccessKey,
+		serverURL:  u,
+		httpClient: newHTTPClient(cfg),
+		logger:     cfg.Logger,
+	}, nil
+}
+
+// newHTTPClient returns a http.Client which applies cfg's timeout, retry
+// policy and circuit breaker.
+func newHTTPClient(cfg Config) *http.Client {
+	hc := &http.Client{}
+	if cfg.HTTPClient != nil {
+		*hc = *cfg.HTTPClient
+	}
+	base := hc.Transport
+	if cfg.Transport != nil {
+		base = cfg.Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.UserAgent != "" || len(cfg.Headers) > 0 {
+		base = &headerTransport{base: base, userAgent: cfg.UserAgent, headers: cfg.Headers}
+	}
+	hc.Transport = &retryTransport{
+		base:    base,
+		timeout: cfg.Timeout,
+		policy:  cfg.Retry,
+		breaker: newCircuitBreaker(cfg.CircuitBreaker),
+		logger:  cfg.Logger,
+	}
+	return hc
+}
+
+// headerTransport adds headers to requests.
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	headers   http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, vs := range t.headers {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = vs
+		}
+	}
+	if t.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
 }
 
 func (c *Client) ListBots(ctx context.Context) ([]easybot.BotResponse, error) {
@@ -62,6 +117,26 @@ func (c *Client) ListBots(ctx context.Context) ([]easybot.BotResponse, error) {
 	return body.Bots, nil
 }
 
+func (c *Client) GetBot(ctx context.Context, id string) (easybot.BotResponse, error) {
+	u, _ := c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s", id))
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(ctx)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return easybot.BotResponse{}, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return easybot.BotResponse{}, err
+	}
+	var body easybot.BotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return easybot.BotResponse{}, fmt.Errorf("decode body: %w", err)
+	}
+	return body, nil
+}
+
 func (c *Client) ListRooms(ctx context.Context, botID string) ([]easybot.RoomResponse, error) {
 	return c.Bot(botID).ListRooms(ctx)
 }
@@ -88,15 +163,26 @@ func (c *Client) readMessages(ctx context.Context, url, accessKey string) ([]eas
 	return body.Messages, nil
 }
 
-func (c *Client) checkErr(resp *http.Response) error {
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("read body: %w", err)
-		}
-		return fmt.Errorf("bad status code: %d: %s", resp.StatusCode, body)
+func (c *Client) readScheduledMessages(ctx context.Context, url, accessKey string) ([]easybot.ScheduledMessageResponse, error) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, accessKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get: %w", err)
 	}
-	return nil
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return nil, err
+	}
+	var body struct {
+		Scheduled []easybot.ScheduledMessageResponse
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	return body.Scheduled, nil
 }
 
 type Bot struct {
@@ -168,6 +254,78 @@ func (bot *Bot) ReadMessages(ctx context.Context, peek bool) ([]easybot.MessageR
 	return bot.c.readMessages(ctx, u.String(), bot.AccessKey)
 }
 
+// MarkRead marks messages sent to the bot as read. Use it after peeking
+// messages with ReadMessages.
+func (bot *Bot) MarkRead(ctx context.Context, ids ...string) error {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/messages/read", bot.ID))
+	payload, _ := json.Marshal(map[string]interface{}{"ids": ids})
+	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	// Marking as read is idempotent, so it's safe to retry.
+	req.Header.Set(easybot.HeaderIdempotencyKey, uuid.New().String())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return bot.c.checkErr(resp)
+}
+
+// Broadcast starts broadcasting a message to the bot's rooms. The broadcast
+// runs in background on the server; use GetBroadcast to track its progress.
+func (bot *Bot) Broadcast(ctx context.Context, br easybot.BroadcastRequest) (easybot.BroadcastResponse, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/broadcasts", bot.ID))
+	payload, _ := json.Marshal(br)
+	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return easybot.BroadcastResponse{}, fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := bot.c.checkErr(resp); err != nil {
+		return easybot.BroadcastResponse{}, err
+	}
+	var body easybot.BroadcastResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return easybot.BroadcastResponse{}, fmt.Errorf("decode body: %w", err)
+	}
+	return body, nil
+}
+
+func (bot *Bot) GetBroadcast(ctx context.Context, id string) (easybot.BroadcastResponse, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/broadcasts/%s", bot.ID, id))
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return easybot.BroadcastResponse{}, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := bot.c.checkErr(resp); err != nil {
+		return easybot.BroadcastResponse{}, err
+	}
+	var body easybot.BroadcastResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return easybot.BroadcastResponse{}, fmt.Errorf("decode body: %w", err)
+	}
+	return body, nil
+}
+
+// ScheduledMessages returns pending scheduled messages of the bot.
+func (bot *Bot) ScheduledMessages(ctx context.Context) ([]easybot.ScheduledMessageResponse, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/scheduled", bot.ID))
+	return bot.c.readScheduledMessages(ctx, u.String(), bot.AccessKey)
+}
+
 func (bot *Bot) Room(roomID string) *Room {
 	return &Room{c: bot.c, AccessKey: bot.AccessKey, BotID: bot.ID, ID: roomID}
 }
@@ -180,9 +338,17 @@ type Room struct {
 }
 
 func (c *Client) CreateRoom(ctx context.Context, botID string) (*Room, error) {
+	return c.CreateRoomWithMetadata(ctx, botID, nil)
+}
+
+// CreateRoomWithMetadata creates a room with metadata, which can be used to
+// target rooms when broadcasting.
+func (c *Client) CreateRoomWithMetadata(ctx context.Context, botID string, metadata map[string]string) (*Room, error) {
 	u, _ := c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms", botID))
-	req, _ := http.NewRequest("POST", u.String(), nil)
+	payload, _ := json.Marshal(map[string]interface{}{"metadata": metadata})
+	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
 	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http post: %w", err)
@@ -211,12 +377,22 @@ func (room *Room) ReadMessages(ctx context.Context, peek bool) ([]easybot.Messag
 	return room.c.readMessages(ctx, u.String(), room.AccessKey)
 }
 
+// SentMessages returns messages sent by the room's client, along with their
+// delivery status.
+func (room *Room) SentMessages(ctx context.Context) ([]easybot.MessageResponse, error) {
+	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/messages/sent", room.BotID, room.ID))
+	return room.c.readMessages(ctx, u.String(), room.AccessKey)
+}
+
+// WriteMessages writes messages in the room. The request carries an
+// idempotency key, so messages are never written twice when it's retried.
 func (room *Room) WriteMessages(ctx context.Context, msgs []easybot.MessageRequest) error {
 	payload, _ := json.Marshal(map[string]interface{}{"messages": msgs})
 	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/messages", room.BotID, room.ID))
 	req, _ := http.NewRequest("POST", u.String(), bytes.NewReader(payload))
 	req = req.WithContext(ctx)
 	req.Header.Set(easybot.HeaderAccessKey, room.AccessKey)
+	req.Header.Set(easybot.HeaderIdempotencyKey, uuid.New().String())
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := room.c.httpClient.Do(req)
 	if err != nil {
@@ -224,9 +400,26 @@ func (room *Room) WriteMessages(ctx context.Context, msgs []easybot.MessageReque
 	}
 	defer resp.Body.Close()
 	defer io.Copy(io.Discard, resp.Body)
-	if err := room.c.checkErr(resp); err != nil {
-		return err
+	return room.c.checkErr(resp)
+}
+
+// ScheduledMessages returns pending scheduled messages in the room.
+func (room *Room) ScheduledMessages(ctx context.Context) ([]easybot.ScheduledMessageResponse, error) {
+	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/scheduled", room.BotID, room.ID))
+	return room.c.readScheduledMessages(ctx, u.String(), room.AccessKey)
+}
+
+// CancelScheduledMessage cancels a pending scheduled message in the room.
+func (room *Room) CancelScheduledMessage(ctx context.Context, id string) error {
+	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/scheduled/%s", room.BotID, room.ID, id))
+	req, _ := http.NewRequest("DELETE", u.String(), nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, room.AccessKey)
+	resp, err := room.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http delete: %w", err)
 	}
-	_, _ = io.Copy(io.Discard, resp.Body)
-	return nil
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return room.c.checkErr(resp)
 }
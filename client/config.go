@@ -1,12 +1,76 @@
 package client
 
+import (
+	"net/http"
+	"time"
+)
+
 const DefaultServerURL = "http://localhost:8000"
 
+const DefaultUserAgent = "easybot-client"
+
 var DefaultConfig = Config{
 	ServerURL: DefaultServerURL,
+	UserAgent: DefaultUserAgent,
+	Timeout:   30 * time.Second,
+	Retry: RetryPolicy{
+		MaxAttempts: 3,
+		MinDelay:    200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	},
+	CircuitBreaker: CircuitBreakerConfig{
+		Threshold: 5,
+		Cooldown:  10 * time.Second,
+	},
 }
 
 type Config struct {
 	ServerURL string
 	AccessKey string
+	// Timeout is the timeout of each attempt of a request. It doesn't apply
+	// to event streams, exports, imports and transcripts, whose size is
+	// unbounded.
+	Timeout        time.Duration
+	Retry          RetryPolicy
+	CircuitBreaker CircuitBreakerConfig
+	// HTTPClient, if not nil, is used to make requests. Its transport is
+	// wrapped to retry requests, so the client itself is not modified.
+	HTTPClient *http.Client
+	// Transport, if not nil, is used instead of the HTTP client's transport.
+	Transport http.RoundTripper
+	// UserAgent, if not empty, is sent as the User-Agent header.
+	UserAgent string
+	// Headers are added to every request.
+	Headers http.Header
+	// Logger, if not nil, logs retries and the circuit breaker's state.
+	Logger Logger
+}
+
+// RetryPolicy configures retries of requests which failed with a connection
+// error, a 5xx status code or a 429 status code, and of requests with an
+// idempotency key which failed with a 409 status code while an earlier
+// attempt was being written. Only requests safe to retry are retried: GET,
+// unconditional PUT and DELETE, and requests with an idempotency key. A 429 or
+// a 409 doesn't count as a failure for the circuit breaker.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// 1 disables retries.
+	MaxAttempts int
+	// MinDelay is the delay before the first retry. Delays double on each
+	// retry with random jitter, up to MaxDelay. A Retry-After header from the
+	// server takes precedence.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// CircuitBreakerConfig configures the circuit breaker, which fails requests
+// immediately with ErrCircuitOpen after consecutive failures, until the server
+// is back.
+type CircuitBreakerConfig struct {
+	// Threshold is the number of consecutive failures to open the circuit.
+	// A negative threshold disables the circuit breaker.
+	Threshold int
+	// Cooldown is how long the circuit stays open before a trial request is
+	// let through.
+	Cooldown time.Duration
 }
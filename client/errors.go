@@ -0,0 +1,78 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hallazzang/easybot"
+)
+
+// Sentinel errors matching APIErrors with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict") // also matches precondition failures.
+	ErrServer       = errors.New("server error")
+	// ErrTooManyRequests matches responses to requests which are rate
+	// limited.
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// APIError is an error response from the server.
+type APIError struct {
+	StatusCode int
+	Code       easybot.ErrorCode
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports whether target is the sentinel error for e.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Code == easybot.ErrCodeBadRequest
+	case ErrUnauthorized:
+		return e.Code == easybot.ErrCodeUnauthorized
+	case ErrNotFound:
+		return e.Code == easybot.ErrCodeNotFound || e.Code == easybot.ErrCodeStateNotFound
+	case ErrStateNotFound:
+		return e.Code == easybot.ErrCodeStateNotFound
+	case ErrConflict:
+		return e.Code == easybot.ErrCodeConflict || e.Code == easybot.ErrCodePreconditionFailed
+	case ErrTooManyRequests:
+		return e.Code == easybot.ErrCodeTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// checkErr returns an *APIError if resp is not successful.
+func (c *Client) checkErr(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var errResp easybot.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Message != "" {
+		apiErr.Code = errResp.Code
+		apiErr.Message = errResp.Message
+	} else {
+		apiErr.Message = string(body)
+	}
+	if apiErr.Code == "" {
+		apiErr.Code = easybot.ErrorCodeFromStatus(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+// Environment variables read by FromEnv.
+const (
+	EnvServerURL = "EASYBOT_SERVER_URL"
+	EnvAccessKey = "EASYBOT_ACCESS_KEY"
+)
+
+// Logger logs messages of a client, such as retries. *log.Logger satisfies
+// Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Option configures a Client. A Config is an Option, too, which sets its
+// non-zero fields.
+type Option interface {
+	apply(cfg *Config)
+}
+
+type optionFunc func(cfg *Config)
+
+func (f optionFunc) apply(cfg *Config) {
+	f(cfg)
+}
+
+func (c Config) apply(cfg *Config) {
+	if c.ServerURL != "" {
+		cfg.ServerURL = c.ServerURL
+	}
+	if c.AccessKey != "" {
+		cfg.AccessKey = c.AccessKey
+	}
+	if c.Timeout != 0 {
+		cfg.Timeout = c.Timeout
+	}
+	if c.Retry != (RetryPolicy{}) {
+		cfg.Retry = c.Retry
+	}
+	if c.CircuitBreaker != (CircuitBreakerConfig{}) {
+		cfg.CircuitBreaker = c.CircuitBreaker
+	}
+	if c.HTTPClient != nil {
+		cfg.HTTPClient = c.HTTPClient
+	}
+	if c.Transport != nil {
+		cfg.Transport = c.Transport
+	}
+	if c.UserAgent != "" {
+		cfg.UserAgent = c.UserAgent
+	}
+	if len(c.Headers) > 0 {
+		cfg.Headers = c.Headers
+	}
+	if c.Logger != nil {
+		cfg.Logger = c.Logger
+	}
+}
+
+// FromEnv returns a Config from the environment variables EASYBOT_SERVER_URL
+// and EASYBOT_ACCESS_KEY. Unset variables are left empty, so that other
+// options take effect.
+func FromEnv() Config {
+	return Config{
+		ServerURL: os.Getenv(EnvServerURL),
+		AccessKey: os.Getenv(EnvAccessKey),
+	}
+}
+
+// WithServerURL sets the server URL.
+func WithServerURL(serverURL string) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.ServerURL = serverURL
+	})
+}
+
+// WithAccessKey sets the access key.
+func WithAccessKey(accessKey string) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.AccessKey = accessKey
+	})
+}
+
+// WithHTTPClient sets the HTTP client to make requests with.
+func WithHTTPClient(hc *http.Client) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.HTTPClient = hc
+	})
+}
+
+// WithTransport sets the transport to make requests with.
+func WithTransport(rt http.RoundTripper) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Transport = rt
+	})
+}
+
+// WithTimeout sets the timeout of each attempt of a request.
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Timeout = d
+	})
+}
+
+// WithRetry sets the retry policy.
+func WithRetry(policy RetryPolicy) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Retry = policy
+	})
+}
+
+// WithCircuitBreaker sets the circuit breaker configuration.
+func WithCircuitBreaker(cb CircuitBreakerConfig) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.CircuitBreaker = cb
+	})
+}
+
+// WithUserAgent sets the User-Agent header of requests.
+func WithUserAgent(ua string) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.UserAgent = ua
+	})
+}
+
+// WithBaseHeaders adds headers to every request. Headers set by the client
+// itself, such as the access key, take precedence.
+func WithBaseHeaders(hdr http.Header) Option {
+	return optionFunc(func(cfg *Config) {
+		if cfg.Headers == nil {
+			cfg.Headers = http.Header{}
+		}
+		for k, vs := range hdr {
+			cfg.Headers[k] = append(cfg.Headers[k], vs...)
+		}
+	})
+}
+
+// WithLogger sets the logger.
+func WithLogger(l Logger) Option {
+	return optionFunc(func(cfg *Config) {
+		cfg.Logger = l
+	})
+}
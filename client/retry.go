@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hallazzang/easybot"
+)
+
+// ErrCircuitOpen is returned when requests are not made because the server
+// has been failing.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// retryTransport is a http.RoundTripper which applies timeouts, retries and
+// circuit breaking to requests.
+type retryTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	policy  RetryPolicy
+	breaker *circuitBreaker
+	logger  Logger
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.policy.MaxAttempts
+	if attempts < 1 || !retryable(req) {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if !t.breaker.allow() {
+			if t.logger != nil {
+				t.logger.Printf("easybot: %s %s: %v", req.Method, req.URL, ErrCircuitOpen)
+			}
+			return nil, ErrCircuitOpen
+		}
+		resp, err := t.roundTrip(req)
+		// A rate limited request is retried, but the server is up. So is a
+		// conflicting idempotent request, which the server rejects while an
+		// earlier attempt is still being written; retrying it replays the
+		// result of that attempt.
+		throttled := err == nil && (resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusConflict && req.Header.Get(easybot.HeaderIdempotencyKey) != "")
+		failed := err != nil || resp.StatusCode >= 500
+		if throttled {
+			t.breaker.release()
+		} else {
+			t.breaker.record(!failed)
+		}
+		if !(failed || throttled) || attempt >= attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		delay := t.delay(attempt)
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				delay = d
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if t.logger != nil {
+			var reason string
+			if err != nil {
+				reason = err.Error()
+			} else {
+				reason = "status " + strconv.Itoa(resp.StatusCode)
+			}
+			t.logger.Printf("easybot: retrying %s %s in %s (attempt %d/%d): %s", req.Method, req.URL, delay, attempt+1, attempts, reason)
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// noTimeoutKey is a context key which disables the timeout of a request, for
+// long-lived responses like event streams and for large bodies like exports,
+// imports and transcripts.
+type noTimeoutKey struct{}
+
+// roundTrip makes a single attempt with the timeout. The timeout covers
+// reading the response body, too.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 || req.Context().Value(noTimeoutKey{}) != nil {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// delay returns the backoff delay before the next attempt, with jitter.
+func (t *retryTransport) delay(attempt int) time.Duration {
+	d := t.policy.MinDelay
+	for i := 1; i < attempt && d < t.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if t.policy.MaxDelay > 0 && d > t.policy.MaxDelay {
+		d = t.policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable reports whether req can be sent more than once safely.
+// Conditional requests are not: if a response is lost after they succeed, a
+// retry fails the precondition and reports a failure for a change which was
+// made.
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if req.Header.Get("If-Match") != "" || req.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.Get(easybot.HeaderIdempotencyKey) != ""
+}
+
+// retryAfter returns the delay requested by the Retry-After header.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// cancelBody cancels the context of a request when its response body is
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// circuitBreaker opens after consecutive failures and lets a trial request
+// through after a cooldown. A nil circuitBreaker allows everything.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // whether a trial request is in flight.
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig) *circuitBreaker {
+	if cfg.Threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: cfg.Threshold, cooldown: cfg.Cooldown}
+}
+
+func (cb *circuitBreaker) allow() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if cb.trial || time.Since(cb.openedAt) < cb.cooldown {
+		return false
+	}
+	cb.trial = true
+	return true
+}
+
+// release ends a trial request without recording its outcome.
+func (cb *circuitBreaker) release() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trial = false
+}
+
+func (cb *circuitBreaker) record(success bool) {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trial = false
+	if success {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Router is a Handler which dispatches messages to other handlers by slash
+// command, regular expression or keyword.
+//
+// Commands are matched first; the command with the most words wins, so
+// "/quiz start" takes precedence over "/quiz". Then regular expressions and
+// keywords are tried in the order they were added. A "/help" command listing
+// all commands is provided unless one is added explicitly.
+type Router struct {
+	commands []*command
+	matchers []matcher
+	fallback Handler
+}
+
+type command struct {
+	words  []string // e.g. ["quiz", "start"] for "/quiz start <n>"
+	params []param
+	desc   string
+	h      Handler
+}
+
+type param struct {
+	name     string
+	optional bool
+	variadic bool
+}
+
+type matcher struct {
+	match func(ctx *Context) bool
+	h     Handler
+}
+
+// NewRouter returns a new Router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Command adds a handler for a slash command. The pattern consists of the
+// command words followed by parameters, where <name> is a required parameter,
+// [name] is an optional one and name... takes the rest of arguments, e.g.
+// "/quiz start <level> [count]". desc is shown in the help text.
+// Command panics if the pattern is malformed.
+func (r *Router) Command(pattern, desc string, h Handler) {
+	cmd, err := parseCommand(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("client: command %q: %v", pattern, err))
+	}
+	cmd.desc = desc
+	cmd.h = h
+	r.commands = append(r.commands, cmd)
+}
+
+// Regexp adds a handler for messages matching the regular expression.
+// Submatches are available from Context.Matches.
+// Regexp panics if expr cannot be compiled.
+func (r *Router) Regexp(expr string, h Handler) {
+	re := regexp.MustCompile(expr)
+	r.matchers = append(r.matchers, matcher{
+		match: func(ctx *Context) bool {
+			ctx.Matches = re.FindStringSubmatch(ctx.Text())
+			return ctx.Matches != nil
+		},
+		h: h,
+	})
+}
+
+// Keyword adds a handler for messages containing any of keywords as a whole
+// word, ignoring case.
+func (r *Router) Keyword(keywords []string, h Handler) {
+	var kws []string
+	for _, kw := range keywords {
+		kws = append(kws, " "+strings.Join(words(kw), " ")+" ")
+	}
+	r.matchers = append(r.matchers, matcher{
+		match: func(ctx *Context) bool {
+			text := " " + strings.Join(words(ctx.Text()), " ") + " "
+			for _, kw := range kws {
+				if strings.Contains(text, kw) {
+					return true
+				}
+			}
+			return false
+		},
+		h: h,
+	})
+}
+
+// Fallback sets the handler for messages which match nothing.
+func (r *Router) Fallback(h Handler) {
+	r.fallback = h
+}
+
+// HandleMessage implements Handler.
+func (r *Router) HandleMessage(ctx *Context) error {
+	text := strings.TrimSpace(ctx.Text())
+	if strings.HasPrefix(text, "/") {
+		args, err := splitArgs(strings.TrimPrefix(text, "/"))
+		if err != nil {
+			return ctx.Reply(fmt.Sprintf("Invalid command: %v", err))
+		}
+		if cmd := r.command(args); cmd != nil {
+			return r.handleCommand(ctx, cmd, args[len(cmd.words):])
+		}
+		if len(args) > 0 && strings.EqualFold(args[0], "help") {
+			return ctx.Reply(r.Help())
+		}
+	}
+	for _, m := range r.matchers {
+		if m.match(ctx) {
+			return m.h.HandleMessage(ctx)
+		}
+	}
+	if r.fallback != nil {
+		return r.fallback.HandleMessage(ctx)
+	}
+	if strings.HasPrefix(text, "/") {
+		return ctx.Reply("Unknown command. Type /help to see available commands.")
+	}
+	return nil
+}
+
+// Help returns the help text listing all commands.
+func (r *Router) Help() string {
+	cmds := append([]*command(nil), r.commands...)
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return strings.Join(cmds[i].words, " ") < strings.Join(cmds[j].words, " ")
+	})
+	var b strings.Builder
+	b.WriteString("Commands:")
+	for _, cmd := range cmds {
+		b.WriteString("\n" + cmd.usage())
+		if cmd.desc != "" {
+			b.WriteString(" - " + cmd.desc)
+		}
+	}
+	if r.command([]string{"help"}) == nil {
+		b.WriteString("\n/help - Show this help")
+	}
+	return b.String()
+}
+
+// command returns the command with the most words matching args.
+func (r *Router) command(args []string) *command {
+	var found *command
+	for _, cmd := range r.commands {
+		if len(cmd.words) > len(args) || (found != nil && len(cmd.words) <= len(found.words)) {
+			continue
+		}
+		ok := true
+		for i, w := range cmd.words {
+			if !strings.EqualFold(w, args[i]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			found = cmd
+		}
+	}
+	return found
+}
+
+func (r *Router) handleCommand(ctx *Context, cmd *command, args []string) error {
+	params := make(map[string]string)
+	for i, p := range cmd.params {
+		if p.variadic {
+			params[p.name] = strings.Join(args[i:], " ")
+			break
+		}
+		if i >= len(args) {
+			if !p.optional {
+				return ctx.Reply("Usage: " + cmd.usage())
+			}
+			break
+		}
+		params[p.name] = args[i]
+	}
+	if n := len(cmd.params); (n == 0 || !cmd.params[n-1].variadic) && len(args) > n {
+		return ctx.Reply("Usage: " + cmd.usage())
+	}
+	ctx.Args = args
+	ctx.Params = params
+	return cmd.h.HandleMessage(ctx)
+}
+
+func (cmd *command) usage() string {
+	parts := []string{"/" + strings.Join(cmd.words, " ")}
+	for _, p := range cmd.params {
+		switch {
+		case p.variadic:
+			parts = append(parts, p.name+"...")
+		case p.optional:
+			parts = append(parts, "["+p.name+"]")
+		default:
+			parts = append(parts, "<"+p.name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func parseCommand(pattern string) (*command, error) {
+	fields := strings.Fields(pattern)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") || fields[0] == "/" {
+		return nil, errors.New("pattern must start with /name")
+	}
+	fields[0] = strings.TrimPrefix(fields[0], "/")
+	cmd := &command{}
+	for i, f := range fields {
+		var p param
+		switch {
+		case strings.HasPrefix(f, "<") && strings.HasSuffix(f, ">"):
+			p = param{name: f[1 : len(f)-1]}
+		case strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]"):
+			p = param{name: f[1 : len(f)-1], optional: true}
+		case strings.HasSuffix(f, "..."):
+			p = param{name: strings.TrimSuffix(f, "..."), optional: true, variadic: true}
+		default:
+			if len(cmd.params) > 0 {
+				return nil, fmt.Errorf("word %q after parameters", f)
+			}
+			cmd.words = append(cmd.words, f)
+			continue
+		}
+		if p.name == "" {
+			return nil, errors.New("empty parameter name")
+		}
+		if n := len(cmd.params); n > 0 {
+			if last := cmd.params[n-1]; last.variadic {
+				return nil, fmt.Errorf("parameter %q after variadic parameter", p.name)
+			} else if last.optional && !p.optional {
+				return nil, fmt.Errorf("required parameter %q after optional parameter", p.name)
+			}
+		}
+		if p.variadic && i != len(fields)-1 {
+			return nil, fmt.Errorf("variadic parameter %q must be the last", p.name)
+		}
+		cmd.params = append(cmd.params, p)
+	}
+	return cmd, nil
+}
+
+// splitArgs splits s into arguments separated by spaces. An argument may be
+// quoted with double or single quotes to contain spaces. Quotes elsewhere are
+// taken literally, so that text like "don't" needs no quoting.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	var quote rune
+	inArg := false
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case (c == '"' || c == '\'') && !inArg:
+			quote = c
+			inArg = true
+		case unicode.IsSpace(c):
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// words returns lower-cased words in s.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hallazzang/easybot"
+)
+
+func TestSplitArgs(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []string
+		err  bool
+	}{
+		{"quiz start 3", []string{"quiz", "start", "3"}, false},
+		{"  quiz   start  ", []string{"quiz", "start"}, false},
+		{`note "buy milk" now`, []string{"note", "buy milk", "now"}, false},
+		{`note 'buy milk'`, []string{"note", "buy milk"}, false},
+		{`note ""`, []string{"note", ""}, false},
+		{"note don't forget", []string{"note", "don't", "forget"}, false},
+		{`size 5"`, []string{"size", `5"`}, false},
+		{`say "it's fine"`, []string{"say", "it's fine"}, false},
+		{`note "buy milk`, nil, true},
+		{"note 'tis", nil, true},
+		{"", nil, false},
+	} {
+		got, err := splitArgs(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("splitArgs(%q) error = %v, want error %v", tc.s, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		usage   string // empty if the pattern is malformed.
+	}{
+		{"/quiz", "/quiz"},
+		{"/quiz start <level> [count]", "/quiz start <level> [count]"},
+		{"/note text...", "/note text..."},
+		{"/quiz   start", "/quiz start"},
+		{"quiz", ""},
+		{"/", ""},
+		{"", ""},
+		{"/quiz <level> start", ""},
+		{"/quiz <>", ""},
+		{"/quiz [count] <level>", ""},
+		{"/note text... more...", ""},
+	} {
+		cmd, err := parseCommand(tc.pattern)
+		if tc.usage == "" {
+			if err == nil {
+				t.Errorf("parseCommand(%q) succeeded, want an error", tc.pattern)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCommand(%q): %v", tc.pattern, err)
+			continue
+		}
+		if got := cmd.usage(); got != tc.usage {
+			t.Errorf("parseCommand(%q).usage() = %q, want %q", tc.pattern, got, tc.usage)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var got string
+	var gotCtx *Context
+	handler := func(name string) Handler {
+		return HandlerFunc(func(ctx *Context) error {
+			got = name
+			gotCtx = ctx
+			return nil
+		})
+	}
+	r := NewRouter()
+	r.Command("/quiz [level]", "", handler("quiz"))
+	r.Command("/quiz start <level> [count]", "", handler("quiz start"))
+	r.Command("/note text...", "", handler("note"))
+	r.Regexp(`^(\d+) \+ (\d+)$`, handler("sum"))
+	r.Keyword([]string{"opening hours"}, handler("hours"))
+	r.Fallback(handler("fallback"))
+
+	for _, tc := range []struct {
+		text    string
+		handler string
+		params  map[string]string
+		matches []string
+	}{
+		{"/quiz", "quiz", map[string]string{}, nil},
+		{"/quiz hard", "quiz", map[string]string{"level": "hard"}, nil},
+		{"/quiz start easy", "quiz start", map[string]string{"level": "easy"}, nil},
+		{"/QUIZ Start easy 5", "quiz start", map[string]string{"level": "easy", "count": "5"}, nil},
+		{"/note don't forget the milk", "note", map[string]string{"text": "don't forget the milk"}, nil},
+		{"/note", "note", map[string]string{"text": ""}, nil},
+		{"1 + 2", "sum", nil, []string{"1 + 2", "1", "2"}},
+		{"What are your Opening Hours?", "hours", nil, nil},
+		{"hours", "fallback", nil, nil},
+		{"/unknown", "fallback", nil, nil},
+	} {
+		got, gotCtx = "", nil
+		ctx := &Context{Message: easybot.MessageResponse{Text: tc.text}}
+		if err := r.HandleMessage(ctx); err != nil {
+			t.Errorf("HandleMessage(%q): %v", tc.text, err)
+			continue
+		}
+		if got != tc.handler {
+			t.Errorf("HandleMessage(%q) handler = %q, want %q", tc.text, got, tc.handler)
+			continue
+		}
+		if tc.params != nil && !reflect.DeepEqual(gotCtx.Params, tc.params) {
+			t.Errorf("HandleMessage(%q) params = %v, want %v", tc.text, gotCtx.Params, tc.params)
+		}
+		if tc.matches != nil && !reflect.DeepEqual(gotCtx.Matches, tc.matches) {
+			t.Errorf("HandleMessage(%q) matches = %q, want %q", tc.text, gotCtx.Matches, tc.matches)
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	r := NewRouter()
+	r.Command("/quiz start <level>", "Start a quiz", HandlerFunc(func(*Context) error { return nil }))
+	r.Command("/note text...", "", HandlerFunc(func(*Context) error { return nil }))
+	want := strings.Join([]string{
+		"Commands:",
+		"/note text...",
+		"/quiz start <level> - Start a quiz",
+		"/help - Show this help",
+	}, "\n")
+	if got := r.Help(); got != want {
+		t.Errorf("Help() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/hallazzang/easybot"
+)
+
+var DefaultRunConfig = RunConfig{
+	PollInterval:    200 * time.Millisecond,
+	Concurrency:     8,
+	ShutdownTimeout: 10 * time.Second,
+}
+
+// maxPollBackoff is the maximum interval between polls while polling fails.
+const maxPollBackoff = 30 * time.Second
+
+// streamPollInterval is the interval between polls of Bot.Run while the event
+// stream tells when new messages are written.
+const streamPollInterval = 30 * time.Second
+
+// RunConfig configures Bot.Run.
+type RunConfig struct {
+	// PollInterval is the interval between polls for new messages when the
+	// server doesn't support event streams.
+	PollInterval time.Duration
+	// Concurrency is the maximum number of rooms handled at the same time.
+	// Messages in the same room are always handled one by one, in order.
+	Concurrency int
+	// ShutdownTimeout is how long to wait for received messages to be handled
+	// after the context is cancelled.
+	ShutdownTimeout time.Duration
+	// OnError is called with errors from polling and handlers, including
+	// recovered panics. By default errors are logged with the client's logger.
+	OnError func(err error)
+}
+
+// Handler handles messages received by a bot.
+type Handler interface {
+	HandleMessage(ctx *Context) error
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as
+// handlers.
+type HandlerFunc func(ctx *Context) error
+
+// HandleMessage calls f(ctx).
+func (f HandlerFunc) HandleMessage(ctx *Context) error {
+	return f(ctx)
+}
+
+// Context is passed to a handler for each received message.
+type Context struct {
+	context.Context
+	Bot     *Bot
+	Room    *Room
+	Message easybot.MessageResponse
+
+	// Set by Router.
+	Args    []string          // arguments following a command.
+	Params  map[string]string // command arguments by their names in the pattern.
+	Matches []string          // submatches of a regular expression.
+}
+
+// Param returns the command argument with name, or an empty string if there's
+// no such argument.
+func (ctx *Context) Param(name string) string {
+	return ctx.Params[name]
+}
+
+// Text returns the received message's text.
+func (ctx *Context) Text() string {
+	return ctx.Message.Text
+}
+
+// State returns the state store of the room the message was received in.
+func (ctx *Context) State() *StateStore {
+	return ctx.Room.State()
+}
+
+// Reply writes messages with texts in the room the message was received in.
+func (ctx *Context) Reply(texts ...string) error {
+	msgs := make([]easybot.MessageRequest, len(texts))
+	for i, text := range texts {
+		msgs[i] = easybot.MessageRequest{Text: text}
+	}
+	return ctx.Room.WriteMessages(ctx, msgs)
+}
+
+// ReplyWithQuickReplies writes a message with text and quick replies, which
+// user interfaces show as buttons, in the room the message was received in.
+func (ctx *Context) ReplyWithQuickReplies(text string, quickReplies ...string) error {
+	return ctx.Room.WriteMessages(ctx, []easybot.MessageRequest{
+		{Text: text, QuickReplies: quickReplies},
+	})
+}
+
+// Run receives messages sent to the bot and dispatches them to h, until ctx
+// is done. It follows the bot's event stream to know when messages are
+// written, and falls back to polling if the server doesn't support it. When
+// ctx is done, Run stops receiving and waits for already received messages
+// to be handled, up to the shutdown timeout.
+//
+// A message is marked as read only after h returns, so delivery is
+// at-least-once: messages not handled by shutdown, or by a crash, are
+// received again on the next run.
+func (bot *Bot) Run(ctx context.Context, h Handler, configs ...RunConfig) error {
+	cfg := DefaultRunConfig
+	for _, c := range configs {
+		if c.PollInterval != 0 {
+			cfg.PollInterval = c.PollInterval
+		}
+		if c.Concurrency != 0 {
+			cfg.Concurrency = c.Concurrency
+		}
+		if c.ShutdownTimeout != 0 {
+			cfg.ShutdownTimeout = c.ShutdownTimeout
+		}
+		if c.OnError != nil {
+			cfg.OnError = c.OnError
+		}
+	}
+	if cfg.OnError == nil {
+		var logger Logger = bot.c.logger
+		if logger == nil {
+			logger = log.Default()
+		}
+		cfg.OnError = func(err error) {
+			logger.Printf("easybot: %v", err)
+		}
+	}
+
+	// Handlers outlive ctx during shutdown, so that they can still reply.
+	handlerCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &dispatcher{
+		ctx:     handlerCtx,
+		bot:     bot,
+		h:       h,
+		onError: cfg.OnError,
+		queues:  make(map[primitive.ObjectID][]easybot.MessageResponse),
+		seen:    make(map[primitive.ObjectID]int),
+		sem:     make(chan struct{}, cfg.Concurrency),
+	}
+
+	wake := make(chan struct{}, 1)
+	var streaming int32
+	go bot.follow(ctx, wake, &streaming, cfg.OnError)
+
+	interval := cfg.PollInterval
+	for {
+		// Peek, since messages are marked as read after they're handled.
+		peek := d.beginPeek()
+		msgs, err := bot.ReadMessages(ctx, true)
+		if err != nil && ctx.Err() == nil {
+			cfg.OnError(fmt.Errorf("read messages: %w", err))
+			// Back off while the server is failing.
+			if interval *= 2; interval > maxPollBackoff {
+				interval = maxPollBackoff
+			}
+		} else {
+			interval = cfg.PollInterval
+			d.dispatch(peek, msgs)
+			d.retryMarkRead()
+		}
+		wait := interval
+		if err == nil && atomic.LoadInt32(&streaming) == 1 {
+			wait = streamPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return d.shutdown(cfg.ShutdownTimeout, cancel)
+		case <-wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// follow signals wake when a message is sent to the bot, following the bot's
+// event stream, until ctx is done or it turns out that the server doesn't
+// support event streams. streaming is set to 1 while the stream is connected.
+func (bot *Bot) follow(ctx context.Context, wake chan<- struct{}, streaming *int32, onError func(err error)) {
+	signal := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	cfg := watchConfig(bot.c, []WatchConfig{{OnError: onError}})
+	w := bot.watcher(cfg)
+	w.streamOnly = true
+	w.onStream = func(connected bool) {
+		if connected {
+			atomic.StoreInt32(streaming, 1)
+			// Catch up with messages sent while disconnected.
+			signal()
+		} else {
+			atomic.StoreInt32(streaming, 0)
+		}
+	}
+	_ = w.watch(ctx, cfg, func(msg easybot.MessageResponse) error {
+		if msg.Type == easybot.UserMessage {
+			signal()
+		}
+		return nil
+	})
+}
+
+// dispatcher dispatches messages to a handler, one room at a time.
+type dispatcher struct {
+	ctx     context.Context
+	bot     *Bot
+	h       Handler
+	onError func(err error)
+
+	mu     sync.Mutex
+	queues map[primitive.ObjectID][]easybot.MessageResponse // a room has a queue while its worker is running.
+	// seen holds the messages dispatched, by the number of the first peek
+	// begun after they were marked as read, or 0 until then. A message is
+	// forgotten once such a peek is done, since it can't return the message.
+	seen     map[primitive.ObjectID]int
+	peeks    int
+	unmarked []string // IDs of handled messages which failed to be marked as read.
+	sem      chan struct{}
+	wg       sync.WaitGroup
+}
+
+// beginPeek returns the number of a new peek.
+func (d *dispatcher) beginPeek() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.peeks++
+	return d.peeks
+}
+
+// dispatch dispatches messages returned by a peek, except those already
+// dispatched.
+func (d *dispatcher) dispatch(peek int, msgs []easybot.MessageResponse) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, marked := range d.seen {
+		if marked != 0 && marked <= peek {
+			delete(d.seen, id)
+		}
+	}
+	for _, msg := range msgs {
+		if _, ok := d.seen[msg.ID]; ok {
+			continue
+		}
+		d.seen[msg.ID] = 0
+		q, ok := d.queues[msg.RoomID]
+		d.queues[msg.RoomID] = append(q, msg)
+		if !ok {
+			d.wg.Add(1)
+			go d.work(msg.RoomID)
+		}
+	}
+}
+
+// markRead marks handled messages as read, or keeps them to retry later.
+func (d *dispatcher) markRead(ids []string) {
+	if err := d.bot.MarkRead(d.ctx, ids...); err != nil {
+		d.onError(fmt.Errorf("mark messages as read: %w", err))
+		d.mu.Lock()
+		d.unmarked = append(d.unmarked, ids...)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		oid, _ := primitive.ObjectIDFromHex(id)
+		d.seen[oid] = d.peeks + 1
+	}
+}
+
+// retryMarkRead retries marking messages as read which failed before.
+func (d *dispatcher) retryMarkRead() {
+	d.mu.Lock()
+	ids := d.unmarked
+	d.unmarked = nil
+	d.mu.Unlock()
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > easybot.MaxHistoryLimit {
+			n = easybot.MaxHistoryLimit
+		}
+		d.markRead(ids[:n])
+		ids = ids[n:]
+	}
+}
+
+func (d *dispatcher) work(roomID primitive.ObjectID) {
+	defer d.wg.Done()
+	d.sem <- struct{}{}
+	defer func() { <-d.sem }()
+	for {
+		d.mu.Lock()
+		q := d.queues[roomID]
+		if len(q) == 0 {
+			delete(d.queues, roomID)
+			d.mu.Unlock()
+			return
+		}
+		msg := q[0]
+		d.queues[roomID] = q[1:]
+		d.mu.Unlock()
+		d.handle(msg)
+		d.markRead([]string{msg.ID.Hex()})
+	}
+}
+
+func (d *dispatcher) handle(msg easybot.MessageResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.onError(fmt.Errorf("handle message %s: panic: %v\n%s", msg.ID.Hex(), r, debug.Stack()))
+		}
+	}()
+	ctx := &Context{
+		Context: d.ctx,
+		Bot:     d.bot,
+		Room:    d.bot.Room(msg.RoomID.Hex()),
+		Message: msg,
+	}
+	if err := d.h.HandleMessage(ctx); err != nil {
+		d.onError(fmt.Errorf("handle message %s: %w", msg.ID.Hex(), err))
+	}
+}
+
+// shutdown waits for workers to finish up to timeout, then cancels handlers
+// still running.
+func (d *dispatcher) shutdown(timeout time.Duration, cancel context.CancelFunc) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		cancel()
+		return errors.New("shutdown timed out")
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hallazzang/easybot"
+)
+
+// ScriptLogOptions are the options of Bot.ScriptLogs.
+type ScriptLogOptions struct {
+	Room  string // limits logs to a room of the bot.
+	Limit int    // the maximum number of logs; the server's default if 0.
+}
+
+// Script returns the bot's script. It returns an error matching ErrNotFound
+// if the bot has no script.
+func (bot *Bot) Script(ctx context.Context) (string, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/script", bot.ID))
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := bot.c.checkErr(resp); err != nil {
+		return "", err
+	}
+	var body easybot.ScriptResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode body: %w", err)
+	}
+	return body.Script, nil
+}
+
+// SetScript sets the bot's script, which the server runs on every user
+// message from then on. The server rejects scripts with syntax errors.
+func (bot *Bot) SetScript(ctx context.Context, script string) error {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/script", bot.ID))
+	payload, _ := json.Marshal(easybot.ScriptRequest{Script: script})
+	req, _ := http.NewRequest("PUT", u.String(), bytes.NewReader(payload))
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http put: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return bot.c.checkErr(resp)
+}
+
+// DeleteScript removes the bot's script.
+func (bot *Bot) DeleteScript(ctx context.Context) error {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/script", bot.ID))
+	req, _ := http.NewRequest("DELETE", u.String(), nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http delete: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return bot.c.checkErr(resp)
+}
+
+// ScriptLogs returns the latest logs of the bot's script, oldest first.
+func (bot *Bot) ScriptLogs(ctx context.Context, opts ScriptLogOptions) ([]easybot.ScriptLogResponse, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/script/logs", bot.ID))
+	q := url.Values{}
+	if opts.Room != "" {
+		q.Set("room", opts.Room)
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	u.RawQuery = q.Encode()
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, bot.AccessKey)
+	resp, err := bot.c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := bot.c.checkErr(resp); err != nil {
+		return nil, err
+	}
+	var body struct {
+		Logs []easybot.ScriptLogResponse
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	return body.Logs, nil
+}
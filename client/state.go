@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+
+	"github.com/hallazzang/easybot"
+)
+
+var (
+	// ErrStateNotFound matches errors returned when a state doesn't exist,
+	// but not when its bot or room doesn't; those match only ErrNotFound.
+	ErrStateNotFound = errors.New("state not found")
+	// ErrVersionConflict matches errors returned when a state has been
+	// modified since it was read.
+	ErrVersionConflict = ErrConflict
+)
+
+// updateAttempts is the maximum number of attempts of StateStore.Update.
+const updateAttempts = 10
+
+// StateStore is a key/value store on the server, scoped to a bot or a room.
+// Values are stored as JSON.
+type StateStore struct {
+	c         *Client
+	accessKey string
+	path      string
+}
+
+// State returns the bot's state store.
+func (bot *Bot) State() *StateStore {
+	return &StateStore{
+		c:         bot.c,
+		accessKey: bot.AccessKey,
+		path:      fmt.Sprintf("/v1/bots/%s/state", bot.ID),
+	}
+}
+
+// State returns the room's state store. Only the bot can access it.
+func (room *Room) State() *StateStore {
+	return &StateStore{
+		c:         room.c,
+		accessKey: room.AccessKey,
+		path:      fmt.Sprintf("/v1/bots/%s/rooms/%s/state", room.BotID, room.ID),
+	}
+}
+
+func (s *StateStore) url(key string) string {
+	p := s.path
+	if key != "" {
+		p += "/" + url.PathEscape(key)
+	}
+	u, _ := s.c.serverURL.Parse(p)
+	return u.String()
+}
+
+func (s *StateStore) do(ctx context.Context, method, key string, payload []byte, hdr http.Header) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, _ := http.NewRequest(method, s.url(key), body)
+	req = req.WithContext(ctx)
+	for k, vs := range hdr {
+		req.Header[k] = vs
+	}
+	req.Header.Set(easybot.HeaderAccessKey, s.accessKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http %s: %w", method, err)
+	}
+	if err := s.c.checkErr(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// List returns all states in the store.
+func (s *StateStore) List(ctx context.Context) ([]easybot.StateResponse, error) {
+	resp, err := s.do(ctx, "GET", "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	var body struct {
+		States []easybot.StateResponse
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode body: %w", err)
+	}
+	return body.States, nil
+}
+
+// Get decodes the value with key into v, which must be a pointer, and returns
+// its version. It returns ErrStateNotFound if there's no such value.
+func (s *StateStore) Get(ctx context.Context, key string, v interface{}) (int64, error) {
+	resp, err := s.do(ctx, "GET", key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	var body easybot.StateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decode body: %w", err)
+	}
+	if err := json.Unmarshal(body.Value, v); err != nil {
+		return 0, fmt.Errorf("decode value: %w", err)
+	}
+	return body.Version, nil
+}
+
+// Set sets the value with key to v and returns the new version.
+func (s *StateStore) Set(ctx context.Context, key string, v interface{}) (int64, error) {
+	return s.set(ctx, key, v, nil)
+}
+
+// SetIfVersion sets the value with key to v only if its current version is
+// version, and returns the new version. Version 0 means the value must not
+// exist yet. It returns ErrVersionConflict if the version doesn't match.
+func (s *StateStore) SetIfVersion(ctx context.Context, key string, v interface{}, version int64) (int64, error) {
+	hdr := http.Header{}
+	if version == 0 {
+		hdr.Set("If-None-Match", "*")
+	} else {
+		hdr.Set("If-Match", easybot.ETag(version))
+	}
+	return s.set(ctx, key, v, hdr)
+}
+
+func (s *StateStore) set(ctx context.Context, key string, v interface{}, hdr http.Header) (int64, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("encode value: %w", err)
+	}
+	resp, err := s.do(ctx, "PUT", key, payload, hdr)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	var body easybot.StateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("decode body: %w", err)
+	}
+	return body.Version, nil
+}
+
+// Update reads the value with key into v, calls f to modify v and writes v
+// back. If the value has been modified by others in the meantime, Update
+// starts over. If the value doesn't exist, f is called with v set to zero.
+func (s *StateStore) Update(ctx context.Context, key string, v interface{}, f func() error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("v must be a non-nil pointer")
+	}
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		version, err := s.Get(ctx, key, v)
+		if err != nil && !errors.Is(err, ErrStateNotFound) {
+			return err
+		}
+		if err := f(); err != nil {
+			return err
+		}
+		if _, err := s.SetIfVersion(ctx, key, v, version); err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("update %s: %w", key, ErrVersionConflict)
+}
+
+// Delete deletes the value with key. It returns ErrStateNotFound if there's no
+// such value.
+func (s *StateStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// DeleteIfVersion deletes the value with key only if its current version is
+// version. It returns ErrVersionConflict if the version doesn't match.
+func (s *StateStore) DeleteIfVersion(ctx context.Context, key string, version int64) error {
+	hdr := http.Header{}
+	hdr.Set("If-Match", easybot.ETag(version))
+	resp, err := s.do(ctx, "DELETE", key, nil, hdr)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
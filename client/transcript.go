@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/hallazzang/easybot"
+)
+
+// TranscriptOptions are the options of Room.Transcript and Bot.Transcripts.
+type TranscriptOptions struct {
+	Format easybot.TranscriptFormat // easybot.TranscriptMarkdown if empty.
+	TZ     string                   // IANA time zone of timestamps; UTC if empty.
+}
+
+// Transcript writes the room's history, rendered for humans, to w.
+func (room *Room) Transcript(ctx context.Context, w io.Writer, opts TranscriptOptions) error {
+	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/transcript", room.BotID, room.ID))
+	u.RawQuery = opts.query().Encode()
+	return room.c.download(ctx, u.String(), room.AccessKey, w)
+}
+
+// Transcripts writes a zip archive of transcripts of every room of the bot
+// to w. Files are named by room IDs, with the format's extension.
+func (bot *Bot) Transcripts(ctx context.Context, w io.Writer, opts TranscriptOptions) error {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/transcripts", bot.ID))
+	u.RawQuery = opts.query().Encode()
+	return bot.c.download(ctx, u.String(), bot.AccessKey, w)
+}
+
+func (opts TranscriptOptions) query() url.Values {
+	q := url.Values{}
+	if opts.Format != "" {
+		q.Set("format", string(opts.Format))
+	}
+	if opts.TZ != "" {
+		q.Set("tz", opts.TZ)
+	}
+	return q
+}
+
+// download copies the body of a GET request to w.
+func (c *Client) download(ctx context.Context, url, accessKey string, w io.Writer) error {
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(context.WithValue(ctx, noTimeoutKey{}, true))
+	req.Header.Set(easybot.HeaderAccessKey, accessKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	return nil
+}
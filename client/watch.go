@@ -0,0 +1,419 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallazzang/easybot"
+)
+
+var DefaultWatchConfig = WatchConfig{
+	PollInterval: time.Second,
+}
+
+// WatchConfig configures Bot.Watch and Room.Watch.
+type WatchConfig struct {
+	// Room limits Bot.Watch to a room of the bot.
+	Room string
+	// After is the ID of the message to watch after. Messages after it are
+	// passed first. By default only new messages are passed.
+	After string
+	// Since, if not zero, passes messages created since the time first,
+	// unless After is given.
+	Since time.Time
+	// PollInterval is the interval between polls when the server doesn't
+	// support event streams.
+	PollInterval time.Duration
+	// Poll disables event streams and always polls the history.
+	Poll bool
+	// OnError is called with errors which are retried. By default errors are
+	// logged with the client's logger.
+	OnError func(err error)
+}
+
+// HistoryOptions are the options of Bot.History and Room.History.
+type HistoryOptions struct {
+	Room  string    // limits Bot.History to a room of the bot.
+	After string    // the ID of the message to read after.
+	Seq   int64     // the sequence number to read after; takes precedence over After.
+	Since time.Time // reads messages created since the time, without After and Seq.
+	Limit int       // the maximum number of messages; the server's default if 0.
+	Last  int       // if not zero, reads the last Last messages instead.
+}
+
+// History returns messages of both types in the bot's rooms, oldest first,
+// without marking them as read. Use HistoryPage to page.
+func (bot *Bot) History(ctx context.Context, opts HistoryOptions) ([]easybot.MessageResponse, error) {
+	page, err := bot.HistoryPage(ctx, opts)
+	return page.Messages, err
+}
+
+// HistoryPage is like History, but also returns the sequence number to read
+// the next page after, as Seq of the next HistoryOptions.
+func (bot *Bot) HistoryPage(ctx context.Context, opts HistoryOptions) (easybot.HistoryResponse, error) {
+	u, _ := bot.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/history", bot.ID))
+	u.RawQuery = opts.query().Encode()
+	return bot.c.readHistory(ctx, u.String(), bot.AccessKey)
+}
+
+// History returns messages of both types in the room, oldest first, without
+// marking them as read. opts.Room is ignored. Use HistoryPage to page.
+func (room *Room) History(ctx context.Context, opts HistoryOptions) ([]easybot.MessageResponse, error) {
+	page, err := room.HistoryPage(ctx, opts)
+	return page.Messages, err
+}
+
+// HistoryPage is like Bot.HistoryPage for the room.
+func (room *Room) HistoryPage(ctx context.Context, opts HistoryOptions) (easybot.HistoryResponse, error) {
+	u, _ := room.c.serverURL.Parse(fmt.Sprintf("/v1/bots/%s/rooms/%s/history", room.BotID, room.ID))
+	opts.Room = ""
+	u.RawQuery = opts.query().Encode()
+	return room.c.readHistory(ctx, u.String(), room.AccessKey)
+}
+
+func (opts HistoryOptions) query() url.Values {
+	q := url.Values{}
+	if opts.Room != "" {
+		q.Set("room", opts.Room)
+	}
+	if opts.Seq > 0 {
+		q.Set("seq", strconv.FormatInt(opts.Seq, 10))
+	} else if opts.After != "" {
+		q.Set("after", opts.After)
+	} else if !opts.Since.IsZero() {
+		q.Set("since", opts.Since.Format(time.RFC3339Nano))
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Last > 0 {
+		q.Set("last", strconv.Itoa(opts.Last))
+	}
+	return q
+}
+
+func (c *Client) readHistory(ctx context.Context, url, accessKey string) (easybot.HistoryResponse, error) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(easybot.HeaderAccessKey, accessKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return easybot.HistoryResponse{}, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err := c.checkErr(resp); err != nil {
+		return easybot.HistoryResponse{}, err
+	}
+	var page easybot.HistoryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return easybot.HistoryResponse{}, fmt.Errorf("decode body: %w", err)
+	}
+	return page, nil
+}
+
+// Watch calls fn with messages of both types in the bot's rooms as they're
+// written, without marking them as read, until ctx is done or fn returns an
+// error. It follows the server's event stream, and falls back to polling the
+// history if the server doesn't support it. After a disconnection, it
+// resumes where it left off, without passing a message twice. Other errors
+// are retried, except when the bot or the room isn't found or access is
+// denied.
+func (bot *Bot) Watch(ctx context.Context, fn func(msg easybot.MessageResponse) error, configs ...WatchConfig) error {
+	cfg := watchConfig(bot.c, configs)
+	return bot.watcher(cfg).watch(ctx, cfg, fn)
+}
+
+func (bot *Bot) watcher(cfg WatchConfig) *watcher {
+	return &watcher{
+		c:         bot.c,
+		accessKey: bot.AccessKey,
+		path:      fmt.Sprintf("/v1/bots/%s", bot.ID),
+		room:      cfg.Room,
+		history: func(ctx context.Context, opts HistoryOptions) (easybot.HistoryResponse, error) {
+			opts.Room = cfg.Room
+			return bot.HistoryPage(ctx, opts)
+		},
+	}
+}
+
+// Watch is like Bot.Watch for the room.
+func (room *Room) Watch(ctx context.Context, fn func(msg easybot.MessageResponse) error, configs ...WatchConfig) error {
+	cfg := watchConfig(room.c, configs)
+	w := &watcher{
+		c:         room.c,
+		accessKey: room.AccessKey,
+		path:      fmt.Sprintf("/v1/bots/%s/rooms/%s", room.BotID, room.ID),
+		history:   room.HistoryPage,
+	}
+	return w.watch(ctx, cfg, fn)
+}
+
+func watchConfig(c *Client, configs []WatchConfig) WatchConfig {
+	cfg := DefaultWatchConfig
+	for _, wc := range configs {
+		if wc.Room != "" {
+			cfg.Room = wc.Room
+		}
+		if wc.After != "" {
+			cfg.After = wc.After
+		}
+		if !wc.Since.IsZero() {
+			cfg.Since = wc.Since
+		}
+		if wc.PollInterval != 0 {
+			cfg.PollInterval = wc.PollInterval
+		}
+		if wc.Poll {
+			cfg.Poll = true
+		}
+		if wc.OnError != nil {
+			cfg.OnError = wc.OnError
+		}
+	}
+	if cfg.OnError == nil {
+		var logger Logger = c.logger
+		if logger == nil {
+			logger = log.Default()
+		}
+		cfg.OnError = func(err error) {
+			logger.Printf("easybot: %v", err)
+		}
+	}
+	return cfg
+}
+
+// errStreamUnsupported is returned when the server doesn't serve event
+// streams.
+var errStreamUnsupported = errors.New("event stream not supported")
+
+// handlerError wraps an error returned by the watch function, which stops
+// watching.
+type handlerError struct{ err error }
+
+func (e handlerError) Error() string { return e.err.Error() }
+
+type watcher struct {
+	c         *Client
+	accessKey string
+	path      string // of the bot or the room.
+	room      string
+	history   func(ctx context.Context, opts HistoryOptions) (easybot.HistoryResponse, error)
+
+	// streamOnly makes watch return errStreamUnsupported instead of
+	// polling.
+	streamOnly bool
+	// onStream, if not nil, is called when the event stream connects and
+	// disconnects.
+	onStream func(connected bool)
+
+	// The position to resume from: the ID of a message or a time until a
+	// sequence number is known. Messages may become visible out of the order
+	// of their sequence numbers, so the server tells which sequence number
+	// is safe to resume from, and the messages passed after it are kept in
+	// passed.
+	after  string
+	since  time.Time
+	seq    int64
+	hasSeq bool
+	passed map[int64]bool
+}
+
+// setSeq moves the position to resume from forward to seq.
+func (w *watcher) setSeq(seq int64) {
+	if w.hasSeq && seq <= w.seq {
+		return
+	}
+	w.seq, w.hasSeq, w.after, w.since = seq, true, "", time.Time{}
+	for s := range w.passed {
+		if s <= seq {
+			delete(w.passed, s)
+		}
+	}
+}
+
+func (w *watcher) watch(ctx context.Context, cfg WatchConfig, fn func(msg easybot.MessageResponse) error) error {
+	w.after, w.since = cfg.After, cfg.Since
+	w.passed = make(map[int64]bool)
+	pass := func(msg easybot.MessageResponse) error {
+		if (w.hasSeq && msg.Seq <= w.seq) || w.passed[msg.Seq] {
+			return nil
+		}
+		if err := fn(msg); err != nil {
+			return handlerError{err}
+		}
+		w.passed[msg.Seq] = true
+		return nil
+	}
+
+	poll := cfg.Poll
+	interval := cfg.PollInterval
+	for ctx.Err() == nil {
+		var err error
+		if !poll {
+			var connected bool
+			connected, err = w.stream(ctx, pass)
+			if errors.Is(err, errStreamUnsupported) {
+				if w.streamOnly {
+					return err
+				}
+				poll = true
+				continue
+			}
+			if connected {
+				interval = cfg.PollInterval
+			}
+		} else {
+			var more bool
+			more, err = w.poll(ctx, pass)
+			if err == nil {
+				interval = cfg.PollInterval
+				if more {
+					continue
+				}
+			}
+		}
+		var herr handlerError
+		if errors.As(err, &herr) {
+			return herr.err
+		}
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		if err != nil && ctx.Err() == nil {
+			cfg.OnError(fmt.Errorf("watch messages: %w", err))
+			// Back off while the server is failing.
+			if interval *= 2; interval > maxPollBackoff {
+				interval = maxPollBackoff
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+	return nil
+}
+
+// poll passes messages after the position from the history. It reports
+// whether there may be more messages to read right away.
+func (w *watcher) poll(ctx context.Context, pass func(msg easybot.MessageResponse) error) (bool, error) {
+	if !w.hasSeq && w.after == "" && w.since.IsZero() {
+		// Start from now, as the server sees it.
+		page, err := w.history(ctx, HistoryOptions{Last: 1})
+		if err != nil {
+			return false, err
+		}
+		w.setSeq(page.Seq)
+		return false, nil
+	}
+	page, err := w.history(ctx, HistoryOptions{After: w.after, Since: w.since, Seq: w.seq})
+	if err != nil {
+		return false, err
+	}
+	for _, msg := range page.Messages {
+		if err := pass(msg); err != nil {
+			return false, err
+		}
+	}
+	w.setSeq(page.Seq)
+	return len(page.Messages) == easybot.DefaultHistoryLimit, nil
+}
+
+// stream follows the event stream until it ends, passing messages. It
+// reports whether the stream was connected.
+func (w *watcher) stream(ctx context.Context, pass func(msg easybot.MessageResponse) error) (bool, error) {
+	u, _ := w.c.serverURL.Parse(w.path + "/events")
+	q := url.Values{}
+	if w.room != "" {
+		q.Set("room", w.room)
+	}
+	if w.hasSeq {
+		q.Set("seq", strconv.FormatInt(w.seq, 10))
+	} else if w.after != "" {
+		q.Set("after", w.after)
+	} else if !w.since.IsZero() {
+		q.Set("since", w.since.Format(time.RFC3339Nano))
+	}
+	u.RawQuery = q.Encode()
+	req, _ := http.NewRequest("GET", u.String(), nil)
+	req = req.WithContext(context.WithValue(ctx, noTimeoutKey{}, true))
+	req.Header.Set(easybot.HeaderAccessKey, w.accessKey)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := w.c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		io.Copy(io.Discard, resp.Body)
+		return false, errStreamUnsupported
+	}
+	if err := w.c.checkErr(resp); err != nil {
+		return false, err
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return false, errStreamUnsupported
+	}
+	if w.onStream != nil {
+		w.onStream(true)
+		defer w.onStream(false)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 1<<20)
+	var event, id string
+	var data strings.Builder
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if event == "message" && data.Len() > 0 {
+				var msg easybot.MessageResponse
+				if err := json.Unmarshal([]byte(data.String()), &msg); err != nil {
+					return true, fmt.Errorf("decode event: %w", err)
+				}
+				if err := pass(msg); err != nil {
+					return true, err
+				}
+			}
+			if seq, err := strconv.ParseInt(id, 10, 64); err == nil {
+				w.setSeq(seq)
+			}
+			event, id = "", ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"): // comment
+		default:
+			field, value := line, ""
+			if i := strings.IndexByte(line, ':'); i >= 0 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "event":
+				event = value
+			case "id":
+				id = value
+			case "data":
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return true, fmt.Errorf("read stream: %w", err)
+	}
+	return true, errors.New("stream closed")
+}
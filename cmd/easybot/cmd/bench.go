@@ -0,0 +1,435 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+// maxBenchErrors is the number of errors printed during a benchmark. The
+// rest are only counted.
+const maxBenchErrors = 10
+
+type benchConfig struct {
+	rooms        int
+	rate         float64 // messages per second, of all rooms.
+	duration     time.Duration
+	wait         time.Duration // for replies after sending.
+	echo         bool
+	echoInterval time.Duration
+}
+
+func NewBenchCmd() *cobra.Command {
+	var cfg benchConfig
+	cmd := &cobra.Command{
+		Use:   "bench [bot]",
+		Short: "Benchmark the server and a bot with simulated users",
+		Long: `Benchmark the server and a bot with simulated users.
+
+A room is created for each user, and users write messages at the target rate
+in total, spread evenly over the rooms, for the duration. The latency is the
+time from writing a message to receiving the bot's reply in the room, which
+is matched to the room's oldest unanswered message, so the bot should reply
+once to each message. Results are reported after waiting for outstanding
+replies.
+
+With --echo, a built-in echo bot answers the messages, so that the server
+alone is benchmarked. The access key must be the bot's; if no bot is given, a
+new bot is created. Rooms created by the benchmark are left on the server
+with the metadata bench=<start time>.`,
+		Example: `  easybot bench --echo --rooms 300 --rate 100 --duration 1m
+  easybot bench <bot-id> --rooms 50 --rate 10 -o json`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			if cfg.rooms <= 0 {
+				return fmt.Errorf("--rooms must be positive")
+			}
+			if cfg.rate <= 0 {
+				return fmt.Errorf("--rate must be positive")
+			}
+			if cfg.duration <= 0 {
+				return fmt.Errorf("--duration must be positive")
+			}
+
+			// Every room keeps a connection for its event stream, and more
+			// for writing messages.
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.MaxIdleConnsPerHost = 2 * cfg.rooms
+			c, err := newClient(cmd, client.Config{Transport: transport})
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			var bot *client.Bot
+			args, err = idArgs(cmd, args, 1, 1)
+			switch {
+			case err == nil:
+				bot = c.Bot(args[0])
+			case cfg.echo:
+				// Without a bot, the echo bot gets a new one.
+				if bot, err = c.CreateBot(ctx, "bench-echo", "Echo bot created by easybot bench"); err != nil {
+					return fmt.Errorf("create bot: %w", err)
+				}
+				fmt.Fprintf(os.Stderr, "created bot %s\n", bot.ID)
+			default:
+				return err
+			}
+
+			b := &bench{cfg: cfg, c: c, bot: bot}
+			res, err := b.run(ctx)
+			if err != nil {
+				return err
+			}
+
+			t := table{header: []string{"Metric", "Value"}}
+			t.add("Bot", res.Bot)
+			t.add("Rooms", strconv.Itoa(res.Rooms))
+			t.add("Duration", fmt.Sprintf("%.1fs", res.Duration))
+			t.add("Sent", fmt.Sprintf("%d (%.1f/s, target %g/s)", res.Sent, res.SendRate, res.TargetRate))
+			t.add("Failed", strconv.Itoa(res.Failed))
+			t.add("Replied", fmt.Sprintf("%d (%.1f/s)", res.Replied, res.Throughput))
+			t.add("Unanswered", strconv.Itoa(res.Unanswered))
+			t.add("Extra replies", strconv.Itoa(res.Extra))
+			t.add("Reply latency", res.Latency.String())
+			t.add("Write latency", res.WriteLatency.String())
+			return p.Print(res, t)
+		},
+	}
+	cmd.Flags().IntVarP(&cfg.rooms, "rooms", "n", 10, "Number of rooms, i.e. simulated users")
+	cmd.Flags().Float64Var(&cfg.rate, "rate", 10, "Messages per second written by all users")
+	cmd.Flags().DurationVarP(&cfg.duration, "duration", "d", 30*time.Second, "How long to write messages")
+	cmd.Flags().DurationVar(&cfg.wait, "wait", 10*time.Second, "How long to wait for outstanding replies after writing")
+	cmd.Flags().BoolVar(&cfg.echo, "echo", false, "Run a built-in echo bot")
+	cmd.Flags().DurationVar(&cfg.echoInterval, "echo-interval", 50*time.Millisecond, "Poll interval of the built-in echo bot")
+	return cmd
+}
+
+// benchLatency summarizes latencies, in milliseconds.
+type benchLatency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func newBenchLatency(ds []time.Duration) benchLatency {
+	if len(ds) == 0 {
+		return benchLatency{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	// percentile returns the nearest-rank percentile.
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(ds)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(ds[i])
+	}
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return benchLatency{
+		Mean: ms(sum / time.Duration(len(ds))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  ms(ds[len(ds)-1]),
+	}
+}
+
+func (l benchLatency) String() string {
+	if l == (benchLatency{}) {
+		return "-"
+	}
+	return fmt.Sprintf("mean %.1fms  p50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms  max %.1fms",
+		l.Mean, l.P50, l.P90, l.P95, l.P99, l.Max)
+}
+
+type benchResult struct {
+	Bot          string       `json:"bot"`
+	Rooms        int          `json:"rooms"`
+	TargetRate   float64      `json:"targetRate"` // messages per second.
+	Duration     float64      `json:"duration"`   // of writing, in seconds.
+	Sent         int          `json:"sent"`
+	Failed       int          `json:"failed"` // messages which couldn't be written.
+	Replied      int          `json:"replied"`
+	Unanswered   int          `json:"unanswered"`
+	Extra        int          `json:"extra"`      // replies without a message to answer.
+	SendRate     float64      `json:"sendRate"`   // messages per second.
+	Throughput   float64      `json:"throughput"` // replies per second.
+	Latency      benchLatency `json:"latency"`    // from when a message was due to be written to receiving its reply.
+	WriteLatency benchLatency `json:"writeLatency"`
+}
+
+type bench struct {
+	cfg benchConfig
+	c   *client.Client
+	bot *client.Bot
+
+	mu        sync.Mutex
+	pending   map[string][]time.Time // due times of unanswered messages by rooms, oldest first.
+	sent      int
+	failed    int
+	extra     int
+	errors    int
+	latencies []time.Duration
+	writes    []time.Duration
+	lastReply time.Time
+}
+
+func (b *bench) run(ctx context.Context) (benchResult, error) {
+	botCtx, stopBot := context.WithCancel(ctx)
+	defer stopBot()
+	var botDone chan struct{}
+	if b.cfg.echo {
+		botDone = make(chan struct{})
+		go func() {
+			defer close(botDone)
+			b.bot.Run(botCtx, client.HandlerFunc(func(ctx *client.Context) error {
+				return ctx.Reply(ctx.Text())
+			}), client.RunConfig{
+				PollInterval: b.cfg.echoInterval,
+				Concurrency:  b.cfg.rooms,
+				OnError:      b.logError,
+			})
+		}()
+	}
+
+	rooms, err := b.createRooms(ctx)
+	if err != nil {
+		return benchResult{}, err
+	}
+	fmt.Fprintf(os.Stderr, "created %d rooms; writing %g messages per second for %s\n", len(rooms), b.cfg.rate, b.cfg.duration)
+
+	b.pending = make(map[string][]time.Time)
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	for _, room := range rooms {
+		room := room
+		go room.Watch(watchCtx, func(msg easybot.MessageResponse) error {
+			b.receive(room.ID, msg)
+			return nil
+		}, client.WatchConfig{
+			// The room is new, so watch from its first message, not to miss
+			// replies before the event stream is connected.
+			After:   primitive.NilObjectID.Hex(),
+			OnError: b.logError,
+		})
+	}
+
+	start := time.Now()
+	end := start.Add(b.cfg.duration)
+	interval := time.Duration(float64(time.Second) * float64(len(rooms)) / b.cfg.rate)
+	var wg sync.WaitGroup
+	for i, room := range rooms {
+		wg.Add(1)
+		go func(i int, room *client.Room) {
+			defer wg.Done()
+			// Stagger rooms to spread messages evenly.
+			next := start.Add(interval * time.Duration(i) / time.Duration(len(rooms)))
+			for seq := 1; next.Before(end); seq++ {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+				// A room writes one message at a time, so a slow write delays
+				// the next ones; measuring from when they were due keeps the
+				// delay in their latencies.
+				b.send(ctx, room, seq, next)
+				next = next.Add(interval)
+			}
+		}(i, room)
+	}
+	sendDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sendDone)
+	}()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for sending := true; sending; {
+		select {
+		case <-sendDone:
+			sending = false
+		case <-ticker.C:
+			b.mu.Lock()
+			fmt.Fprintf(os.Stderr, "sent %d, replied %d\n", b.sent, len(b.latencies))
+			b.mu.Unlock()
+		}
+	}
+	duration := time.Since(start)
+	if ctx.Err() == nil && duration < b.cfg.duration {
+		// The last messages were written before the end.
+		duration = b.cfg.duration
+	}
+
+	deadline := time.Now().Add(b.cfg.wait)
+	for b.unanswered() > 0 && time.Now().Before(deadline) && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stopWatch()
+	stopBot()
+	if botDone != nil {
+		<-botDone
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := benchResult{
+		Bot:          b.bot.ID,
+		Rooms:        len(rooms),
+		TargetRate:   b.cfg.rate,
+		Duration:     duration.Seconds(),
+		Sent:         b.sent,
+		Failed:       b.failed,
+		Replied:      len(b.latencies),
+		Extra:        b.extra,
+		SendRate:     float64(b.sent) / duration.Seconds(),
+		Latency:      newBenchLatency(b.latencies),
+		WriteLatency: newBenchLatency(b.writes),
+	}
+	for _, times := range b.pending {
+		res.Unanswered += len(times)
+	}
+	if d := b.lastReply.Sub(start); len(b.latencies) > 0 && d > 0 {
+		res.Throughput = float64(len(b.latencies)) / d.Seconds()
+	}
+	return res, nil
+}
+
+// createRooms creates rooms for the benchmark, a few at a time.
+func (b *bench) createRooms(ctx context.Context) ([]*client.Room, error) {
+	metadata := map[string]string{"bench": time.Now().UTC().Format(time.RFC3339)}
+	rooms := make([]*client.Room, b.cfg.rooms)
+	errs := make(chan error, len(rooms))
+	sem := make(chan struct{}, 16)
+	var wg sync.WaitGroup
+	for i := range rooms {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			room, err := b.c.CreateRoomWithMetadata(ctx, b.bot.ID, metadata)
+			if err != nil {
+				errs <- err
+				return
+			}
+			rooms[i] = room
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, fmt.Errorf("create room: %w", err)
+	}
+	return rooms, nil
+}
+
+// send writes a message in the room, which was due at due, and records it
+// as unanswered since then.
+func (b *bench) send(ctx context.Context, room *client.Room, seq int, due time.Time) {
+	b.mu.Lock()
+	b.pending[room.ID] = append(b.pending[room.ID], due)
+	b.mu.Unlock()
+
+	t := time.Now()
+	err := room.WriteMessages(ctx, []easybot.MessageRequest{{Text: fmt.Sprintf("bench message %d", seq)}})
+	d := time.Since(t)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		if ctx.Err() != nil {
+			// Interrupted; the message may or may not have been written.
+			return
+		}
+		b.failed++
+		// Messages in a room are written one by one, so the failed one is
+		// the latest unless it has been answered already.
+		times := b.pending[room.ID]
+		if n := len(times); n > 0 && times[n-1].Equal(due) {
+			b.pending[room.ID] = times[:n-1]
+		}
+		b.logErrorLocked(fmt.Errorf("write message: %w", err))
+		return
+	}
+	b.sent++
+	b.writes = append(b.writes, d)
+}
+
+// receive matches the bot's message to the room's oldest unanswered message.
+func (b *bench) receive(roomID string, msg easybot.MessageResponse) {
+	if msg.Type != easybot.BotMessage {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	times := b.pending[roomID]
+	if len(times) == 0 {
+		b.extra++
+		return
+	}
+	b.pending[roomID] = times[1:]
+	b.latencies = append(b.latencies, now.Sub(times[0]))
+	b.lastReply = now
+}
+
+func (b *bench) unanswered() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, times := range b.pending {
+		n += len(times)
+	}
+	return n
+}
+
+func (b *bench) logError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logErrorLocked(err)
+}
+
+// logErrorLocked prints up to maxBenchErrors errors. b.mu must be held.
+func (b *bench) logErrorLocked(err error) {
+	b.errors++
+	switch {
+	case b.errors < maxBenchErrors:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	case b.errors == maxBenchErrors:
+		fmt.Fprintf(os.Stderr, "error: %v (further errors are not shown)\n", err)
+	}
+}
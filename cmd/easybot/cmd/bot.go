@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot/client"
+	"github.com/hallazzang/easybot/rules"
+)
+
+func NewBotCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bot",
+		Short: "Host bots without writing Go",
+	}
+	cmd.AddCommand(
+		NewBotRunCmd(),
+		NewBotServeRulesCmd(),
+		NewBotScriptCmd(),
+	)
+	return cmd
+}
+
+func NewBotRunCmd() *cobra.Command {
+	var (
+		cfg         execConfig
+		concurrency int
+	)
+	cmd := &cobra.Command{
+		Use:   "run [bot] --exec <command> [-- args...]",
+		Short: "Host a bot by an external program",
+		Long: `Host a bot by an external program, written in any language.
+
+Each user message is written to the program's stdin, and what the program
+prints to stdout is sent as the reply. With --mode message (the default), a
+process is started for each message: it reads the message until EOF, and its
+whole output is one reply. With --mode room, a process is started for each
+room and kept running: it reads one message per line, and each line it prints
+is a reply, so it may reply any number of times, or keep state in memory.
+
+With --input text (the default), a message is its text; in room mode, line
+breaks in the text are replaced by spaces. With --input json, a message is a
+JSON object with the id, roomID, type, text and createdAt of the message, on a
+single line. In json mode, output which is a JSON object like
+{"text": "...", "quickReplies": ["..."]}, or an array of them, is sent as is.
+
+The program gets the IDs in $EASYBOT_BOT_ID and $EASYBOT_ROOM_ID, and its
+stderr is passed through.`,
+		Example: `  easybot bot run <bot-id> --exec ./echo.sh
+  easybot bot run <bot-id> --mode room --input json --exec python3 -- bot.py`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			if cfg.command == "" {
+				return fmt.Errorf("--exec is required")
+			}
+			if n := cmd.ArgsLenAtDash(); n >= 0 {
+				cfg.args = args[n:]
+				args = args[:n]
+			}
+			if len(args) > 1 {
+				return fmt.Errorf("accepts at most 1 arg(s) before --, received %d", len(args))
+			}
+			switch cfg.mode {
+			case execModeMessage, execModeRoom:
+			default:
+				return fmt.Errorf("unknown mode %q: must be one of message, room", cfg.mode)
+			}
+			switch cfg.input {
+			case execInputText, execInputJSON:
+			default:
+				return fmt.Errorf("unknown input %q: must be one of text, json", cfg.input)
+			}
+
+			args, err := idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			bot := c.Bot(args[0])
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			h := newExecHandler(bot.ID, cfg)
+			defer h.close()
+			fmt.Fprintf(os.Stderr, "running bot %s with %s; press Ctrl+C to stop\n", bot.ID, cfg.command)
+			return bot.Run(ctx, h, client.RunConfig{Concurrency: concurrency})
+		},
+	}
+	cmd.Flags().StringVar(&cfg.command, "exec", "", "Program to run for messages")
+	cmd.Flags().StringVar(&cfg.mode, "mode", execModeMessage, "Process per message or per room: message|room")
+	cmd.Flags().StringVar(&cfg.input, "input", execInputText, "Format of messages on stdin: text|json")
+	cmd.Flags().DurationVar(&cfg.timeout, "timeout", 10*time.Second, "Time limit of a process in message mode, or of writing a message to it in room mode")
+	cmd.Flags().DurationVar(&cfg.idleTimeout, "idle-timeout", 10*time.Minute, "Stop room processes which haven't got a message for the duration in room mode")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 0, "Maximum number of rooms handled at the same time")
+	return cmd
+}
+
+func NewBotServeRulesCmd() *cobra.Command {
+	var (
+		noReload    bool
+		concurrency int
+	)
+	cmd := &cobra.Command{
+		Use:   "serve-rules [bot] [rules.yml]",
+		Short: "Host a bot by a file of rules",
+		Long: `Host a bot by a YAML file of rules, e.g. for an FAQ bot.
+
+Rules match user messages by keywords, regular expressions or intents, and
+answer from templates which may use the room state. If no rule matches, a
+fallback answer is sent. The file is reloaded when it changes; if it's
+invalid, the rules in use are kept. See the rules package for the format.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			args, err := idArgs(cmd, args, 2, 1)
+			if err != nil {
+				return err
+			}
+			botID, path := args[0], args[1]
+
+			r, err := rules.Load(path)
+			if err != nil {
+				return fmt.Errorf("load rules: %w", err)
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			bot := c.Bot(botID)
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			engine := rules.NewEngine(r)
+			if !noReload {
+				go func() {
+					err := engine.WatchFile(ctx, path, func(err error) {
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "reload rules: %v\n", err)
+							return
+						}
+						fmt.Fprintf(os.Stderr, "reloaded %d rules from %s\n", len(engine.Rules().Rules), path)
+					})
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "watch %s: %v\n", path, err)
+					}
+				}()
+			}
+			fmt.Fprintf(os.Stderr, "running bot %s with %d rules from %s; press Ctrl+C to stop\n", bot.ID, len(r.Rules), path)
+			return bot.Run(ctx, engine, client.RunConfig{Concurrency: concurrency})
+		},
+	}
+	cmd.Flags().BoolVar(&noReload, "no-reload", false, "Don't reload the file when it changes")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 0, "Maximum number of rooms handled at the same time")
+	return cmd
+}
@@ -6,14 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
-	"github.com/hallazzang/read"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/hallazzang/easybot"
-	"github.com/hallazzang/easybot/client"
 )
 
 func NewEasyBotCmd() *cobra.Command {
@@ -34,8 +34,11 @@ func NewEasyBotCmd() *cobra.Command {
 			return nil
 		},
 	}
+	addOutputFlags(cmd)
+	addProfileFlag(cmd)
 	cmd.AddCommand(
 		NewServeCmd(),
+		NewConfigCmd(),
 		NewCreateBotCmd(),
 		NewListBotsCmd(),
 		NewCreateRoomCmd(),
@@ -43,11 +46,23 @@ func NewEasyBotCmd() *cobra.Command {
 		NewReadCmd(),
 		NewWriteCmd(),
 		NewInteractCmd(),
+		NewTailCmd(),
+		NewBroadcastCmd(),
+		NewListScheduledCmd(),
+		NewCancelScheduledCmd(),
+		NewTestCmd(),
+		NewGradeCmd(),
+		NewBotCmd(),
+		NewExportCmd(),
+		NewImportCmd(),
+		NewTranscriptCmd(),
+		NewBenchCmd(),
 	)
 	return cmd
 }
 
 func NewServeCmd() *cobra.Command {
+	var memory bool
 	cmd := &cobra.Command{
 		Use:     "serve [addr]",
 		Short:   "Run an EasyBot server",
@@ -61,14 +76,35 @@ func NewServeCmd() *cobra.Command {
 			if err := viper.UnmarshalKey("server", &cfg); err != nil {
 				return fmt.Errorf("unmarshal server config: %w", err)
 			}
+			if cfg.AdminKey != "" {
+				if _, err := uuid.Parse(cfg.AdminKey); err != nil {
+					return errors.New("invalid admin key: must be a uuid")
+				}
+			}
 
-			db, err := easybot.NewDB(context.Background(), cfg.DB)
-			if err != nil {
-				return fmt.Errorf("new db: %w", err)
+			var store easybot.Store
+			if memory {
+				store = easybot.NewMemoryStore()
+			} else {
+				db, err := easybot.NewDB(context.Background(), cfg.DB)
+				if err != nil {
+					return fmt.Errorf("new db: %w", err)
+				}
+				defer db.Close()
+				store = db
+			}
+
+			server := easybot.NewServer(cfg, store)
+			if err := server.ResumeBroadcasts(context.Background()); err != nil {
+				return err
+			}
+			if err := server.ResumeScripts(context.Background()); err != nil {
+				return err
 			}
-			defer db.Close()
 
-			server := easybot.NewServer(cfg, db)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go server.RunScheduler(ctx)
 
 			if err := server.Listen(addr); err != nil {
 				return fmt.Errorf("listen: %w", err)
@@ -77,10 +113,12 @@ func NewServeCmd() *cobra.Command {
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&memory, "memory", false, "Keep data in memory instead of MongoDB")
 	return cmd
 }
 
 func NewCreateBotCmd() *cobra.Command {
+	var saveProfile string
 	cmd := &cobra.Command{
 		Use:   "create-bot [name] [description]",
 		Short: "Create a bot",
@@ -88,31 +126,42 @@ func NewCreateBotCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
 			name := args[0]
 			var desc string
 			if len(args) > 1 {
 				desc = args[1]
 			}
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
-			}
-
-			c, err := client.New(cfg)
+			c, err := newClient(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
 
 			bot, err := c.CreateBot(context.TODO(), name, desc)
 			if err != nil {
 				return fmt.Errorf("create bot: %w", err)
 			}
+			if saveProfile != "" {
+				if err := saveToProfile(cmd, saveProfile, func(prof *Profile) {
+					prof.AccessKey = bot.AccessKey
+					prof.Bot = bot.ID
+					prof.Room = ""
+				}); err != nil {
+					return fmt.Errorf("save profile: %w", err)
+				}
+			}
 
-			fmt.Printf("bot id: %s\naccess key: %s\n", bot.ID, bot.AccessKey)
-			return nil
+			t := table{header: []string{"ID", "Access Key"}}
+			t.add(bot.ID, bot.AccessKey)
+			return p.Print(createdResponse{ID: bot.ID, AccessKey: bot.AccessKey}, t)
 		},
 	}
+	cmd.Flags().StringVarP(&saveProfile, "save-profile", "s", "", "Save the bot access key and the bot into the profile")
 	return cmd
 }
 
@@ -124,14 +173,14 @@ func NewListBotsCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
 			}
 
-			c, err := client.New(cfg)
+			c, err := newClient(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
 
 			bots, err := c.ListBots(context.TODO())
@@ -139,46 +188,71 @@ func NewListBotsCmd() *cobra.Command {
 				return fmt.Errorf("list bots: %w", err)
 			}
 
-			fmt.Println("ID                        Created          Name")
-			fmt.Println("------------------------  ---------------  ----")
+			t := table{header: []string{"ID", "Created", "Status", "Name"}}
 			for _, bot := range bots {
-				fmt.Printf("%24s  %15s  %s\n", bot.ID.Hex(), bot.CreatedAt.In(time.Local).Format(time.Stamp), bot.Name)
+				status := "offline"
+				switch {
+				case bot.Scripted:
+					status = "scripted"
+				case bot.Online:
+					status = "online"
+				}
+				t.add(bot.ID.Hex(), p.Time(bot.CreatedAt), status, bot.Name)
 			}
-			return nil
+			return p.Print(bots, t)
 		},
 	}
 	return cmd
 }
 
 func NewCreateRoomCmd() *cobra.Command {
+	var metadata map[string]string
+	var saveProfile string
 	cmd := &cobra.Command{
 		Use:   "create-room [bot]",
 		Short: "Create a room",
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
-			botID := args[0]
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
+			args, err = idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
 			}
 
-			c, err := client.New(cfg)
+			botID := args[0]
+
+			c, err := newClient(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
 
-			room, err := c.CreateRoom(context.TODO(), botID)
+			room, err := c.CreateRoomWithMetadata(context.TODO(), botID, metadata)
 			if err != nil {
 				return fmt.Errorf("create room: %w", err)
 			}
+			if saveProfile != "" {
+				if err := saveToProfile(cmd, saveProfile, func(prof *Profile) {
+					prof.AccessKey = room.AccessKey
+					prof.Bot = room.BotID
+					prof.Room = room.ID
+				}); err != nil {
+					return fmt.Errorf("save profile: %w", err)
+				}
+			}
 
-			fmt.Printf("room id: %s\naccess key: %s\n", room.ID, room.AccessKey)
-			return nil
+			t := table{header: []string{"ID", "Access Key"}}
+			t.add(room.ID, room.AccessKey)
+			return p.Print(createdResponse{ID: room.ID, AccessKey: room.AccessKey}, t)
 		},
 	}
+	cmd.Flags().StringToStringVarP(&metadata, "meta", "m", nil, "Room metadata")
+	cmd.Flags().StringVarP(&saveProfile, "save-profile", "s", "", "Save the user access key and the room into the profile")
 	return cmd
 }
 
@@ -186,20 +260,25 @@ func NewListRoomsCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "rooms [bot]",
 		Short: "List all rooms of a bot",
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
-			botID := args[0]
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
+			args, err = idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
 			}
 
-			c, err := client.New(cfg)
+			botID := args[0]
+
+			c, err := newClient(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
 
 			rooms, err := c.ListRooms(context.TODO(), botID)
@@ -207,13 +286,11 @@ func NewListRoomsCmd() *cobra.Command {
 				return fmt.Errorf("list rooms: %w", err)
 			}
 
-			fmt.Println("ID                        Created   ")
-			fmt.Println("------------------------  ----------")
+			t := table{header: []string{"ID", "Created", "Metadata"}}
 			for _, room := range rooms {
-				fmt.Printf("%24s  %s\n", room.ID.Hex(), room.CreatedAt.In(time.Local).Format(time.Stamp))
+				t.add(room.ID.Hex(), p.Time(room.CreatedAt), formatMetadata(room.Metadata))
 			}
-
-			return nil
+			return p.Print(rooms, t)
 		},
 	}
 	return cmd
@@ -224,49 +301,52 @@ func NewReadCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "read [bot] [room]",
 		Short: "Read messages",
-		Args:  cobra.RangeArgs(1, 2),
+		Args:  cobra.MaximumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			args, err = botRoomArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+
 			botID := args[0]
 			var roomID string
 			if len(args) > 1 {
 				roomID = args[1]
 			}
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
-			}
-
-			c, err := client.New(cfg)
+			c, err := newClient(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
 			var msgs []easybot.MessageResponse
+			var t table
 			if roomID == "" {
 				msgs, err = c.Bot(botID).ReadMessages(context.TODO(), peek)
 				if err != nil {
 					return fmt.Errorf("read messages: %w", err)
 				}
-				fmt.Println("Room                      Created          Text")
-				fmt.Println("------------------------  ---------------  ----")
+				t.header = []string{"Room", "Created", "Text"}
 				for _, msg := range msgs {
-					fmt.Printf("%24s  %15s  %s\n", msg.RoomID.Hex(), msg.CreatedAt.In(time.Local).Format(time.Stamp), msg.Text)
+					t.add(msg.RoomID.Hex(), p.Time(msg.CreatedAt), msg.Text)
 				}
 			} else {
 				msgs, err = c.Room(botID, roomID).ReadMessages(context.TODO(), peek)
 				if err != nil {
 					return fmt.Errorf("read messages: %w", err)
 				}
-				fmt.Println("Created          Text")
-				fmt.Println("---------------  ----")
+				t.header = []string{"Created", "Text"}
 				for _, msg := range msgs {
-					fmt.Printf("%15s  %s\n", msg.CreatedAt.In(time.Local).Format(time.Stamp), msg.Text)
+					t.add(p.Time(msg.CreatedAt), msg.Text)
 				}
 			}
-
-			return nil
+			return p.Print(msgs, t)
 		},
 	}
 	cmd.Flags().BoolVarP(&peek, "peek", "p", true, "Peek only")
@@ -274,89 +354,184 @@ func NewReadCmd() *cobra.Command {
 }
 
 func NewWriteCmd() *cobra.Command {
+	var after time.Duration
+	var quickReplies []string
 	cmd := &cobra.Command{
 		Use:   "write [bot] [room] [text]",
-		Args:  cobra.ExactArgs(3),
+		Args:  cobra.RangeArgs(1, 3),
 		Short: "Write messages",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
+			args, err := idArgs(cmd, args, 3, 2)
+			if err != nil {
+				return err
+			}
+
 			botID := args[0]
 			roomID := args[1]
 			text := args[2]
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
 			}
+			msg := easybot.MessageRequest{Text: text, QuickReplies: quickReplies}
+			if after > 0 {
+				sendAt := time.Now().Add(after)
+				msg.SendAt = &sendAt
+			}
+			if err := c.Room(botID, roomID).WriteMessages(context.TODO(), []easybot.MessageRequest{msg}); err != nil {
+				return fmt.Errorf("write messages: %w", err)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().DurationVarP(&after, "after", "a", 0, "Schedule the message to be sent after the duration")
+	cmd.Flags().StringArrayVarP(&quickReplies, "quick-reply", "q", nil, "Offer a quick reply, shown as a button (bots only; repeatable)")
+	return cmd
+}
 
-			c, err := client.New(cfg)
+func NewBroadcastCmd() *cobra.Command {
+	var metadata map[string]string
+	var wait bool
+	cmd := &cobra.Command{
+		Use:   "broadcast [bot] [text]",
+		Short: "Broadcast a message to all rooms of a bot",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
 			if err != nil {
-				return fmt.Errorf("new client: %w", err)
+				return err
 			}
-			if err := c.Room(botID, roomID).WriteMessages(context.TODO(), []easybot.MessageRequest{{Text: text}}); err != nil {
-				return fmt.Errorf("write messages: %w", err)
+
+			args, err = idArgs(cmd, args, 2, 1)
+			if err != nil {
+				return err
 			}
 
+			botID := args[0]
+			text := args[1]
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			bot := c.Bot(botID)
+			b, err := bot.Broadcast(context.TODO(), easybot.BroadcastRequest{
+				Message:  easybot.MessageRequest{Text: text},
+				Metadata: metadata,
+			})
+			if err != nil {
+				return fmt.Errorf("broadcast: %w", err)
+			}
+			if wait {
+				fmt.Fprintf(os.Stderr, "broadcast id: %s\n", b.ID.Hex())
+				for b.Status == easybot.BroadcastPending || b.Status == easybot.BroadcastRunning {
+					time.Sleep(500 * time.Millisecond)
+					b, err = bot.GetBroadcast(context.TODO(), b.ID.Hex())
+					if err != nil {
+						return fmt.Errorf("get broadcast: %w", err)
+					}
+					fmt.Fprintf(os.Stderr, "%s: %d/%d\n", b.Status, b.Sent, b.Total)
+				}
+			}
+
+			t := table{header: []string{"ID", "Status", "Sent", "Total", "Error"}}
+			t.add(b.ID.Hex(), string(b.Status), strconv.Itoa(b.Sent), strconv.Itoa(b.Total), b.Error)
+			if err := p.Print(b, t); err != nil {
+				return err
+			}
+			if b.Status == easybot.BroadcastFailed {
+				return fmt.Errorf("broadcast failed: %s", b.Error)
+			}
 			return nil
 		},
 	}
+	cmd.Flags().StringToStringVarP(&metadata, "meta", "m", nil, "Broadcast only to rooms with matching metadata")
+	cmd.Flags().BoolVarP(&wait, "wait", "w", false, "Wait for the broadcast to finish, reporting progress")
 	return cmd
 }
 
-func NewInteractCmd() *cobra.Command {
+func NewListScheduledCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "interact [bot] [room]",
-		Short: "Interact within a room",
-		Args:  cobra.ExactArgs(2),
+		Use:   "scheduled [bot] [room]",
+		Short: "List scheduled messages",
+		Args:  cobra.MaximumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cmd.SilenceUsage = true
 
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			args, err = botRoomArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+
 			botID := args[0]
-			roomID := args[1]
+			var roomID string
+			if len(args) > 1 {
+				roomID = args[1]
+			}
 
-			cfg := client.DefaultConfig
-			if err := viper.UnmarshalKey("client", &cfg); err != nil {
-				return fmt.Errorf("unmarshal client config: %w", err)
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
 			}
 
-			c, err := client.New(cfg)
+			var msgs []easybot.ScheduledMessageResponse
+			if roomID == "" {
+				msgs, err = c.Bot(botID).ScheduledMessages(context.TODO())
+			} else {
+				msgs, err = c.Room(botID, roomID).ScheduledMessages(context.TODO())
+			}
 			if err != nil {
-				return fmt.Errorf("access key must be provided")
+				return fmt.Errorf("list scheduled messages: %w", err)
 			}
 
-			room := c.Room(botID, roomID)
-			for {
-				fmt.Print("text> ")
-				text, err := read.Line()
-				if err != nil {
-					return fmt.Errorf("read line: %w", err)
-				}
+			t := table{header: []string{"ID", "Room", "Send At", "Text"}}
+			for _, msg := range msgs {
+				t.add(msg.ID.Hex(), msg.RoomID.Hex(), p.Time(msg.SendAt), msg.Text)
+			}
+			return p.Print(msgs, t)
+		},
+	}
+	return cmd
+}
 
-				if err := room.WriteMessages(context.TODO(), []easybot.MessageRequest{
-					{Text: text},
-				}); err != nil {
-					return fmt.Errorf("write messages: %w", err)
-				}
+func NewCancelScheduledCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cancel [bot] [room] [id]",
+		Short: "Cancel a scheduled message",
+		Args:  cobra.RangeArgs(1, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
 
-				for {
-					time.Sleep(100 * time.Millisecond)
+			args, err := idArgs(cmd, args, 3, 2)
+			if err != nil {
+				return err
+			}
 
-					msgs, err := room.ReadMessages(context.TODO(), false)
-					if err != nil {
-						return fmt.Errorf("read messages: %w", err)
-					}
+			botID := args[0]
+			roomID := args[1]
+			id := args[2]
 
-					received := false
-					for _, msg := range msgs {
-						fmt.Printf("received: %s\n", msg.Text)
-						received = true
-					}
-					if received {
-						break
-					}
-				}
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
 			}
+
+			if err := c.Room(botID, roomID).CancelScheduledMessage(context.TODO(), id); err != nil {
+				return fmt.Errorf("cancel scheduled message: %w", err)
+			}
+			return nil
 		},
 	}
 	return cmd
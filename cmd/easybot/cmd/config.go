@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultProfileName is the name of the profile created by `easybot config
+// init` when no name is given.
+const DefaultProfileName = "default"
+
+func NewConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage profiles in the config file",
+		Long: `Manage profiles in the config file.
+
+A profile holds a server URL, an access key and optionally a default bot and
+room, which commands use when they're not given. Choose a profile with
+--profile or $` + EnvProfile + `, or make it current with "easybot config use".`,
+	}
+	cmd.AddCommand(
+		NewConfigInitCmd(),
+		NewConfigSetCmd(),
+		NewConfigGetCmd(),
+		NewConfigUseCmd(),
+		NewConfigListCmd(),
+	)
+	return cmd
+}
+
+// targetProfile returns the name of the profile `easybot config` commands
+// work on: the --profile flag, or the current profile.
+func targetProfile(cmd *cobra.Command, f *configFile) (string, error) {
+	name := profileName(cmd)
+	if name == "" {
+		name = f.currentProfile()
+	}
+	if name == "" {
+		return "", fmt.Errorf("no current profile: run easybot config init")
+	}
+	return name, nil
+}
+
+func NewConfigInitCmd() *cobra.Command {
+	var prof Profile
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "init [profile]",
+		Short: "Create a profile and make it current",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			name := DefaultProfileName
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			f, err := readConfigFile()
+			if err != nil {
+				return fmt.Errorf("read config: %w", err)
+			}
+			if _, ok, err := f.profile(name); err != nil {
+				return err
+			} else if ok && !force {
+				return fmt.Errorf("profile %q already exists; use --force to overwrite it", name)
+			}
+			f.setProfile(name, prof)
+			f.setCurrentProfile(name)
+			if err := f.write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "created profile %s in %s\n", name, f.path)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&prof.ServerURL, "server-url", "", "Server URL")
+	cmd.Flags().StringVar(&prof.AccessKey, "access-key", "", "Access key")
+	cmd.Flags().StringVar(&prof.Bot, "bot", "", "Default bot ID")
+	cmd.Flags().StringVar(&prof.Room, "room", "", "Default room ID")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite an existing profile")
+	return cmd
+}
+
+func NewConfigSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a value of the profile",
+		Long: `Set a value of the profile, creating the profile if needed.
+
+Keys are ` + profileKeys + `. An empty value unsets the key.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			key, value := args[0], args[1]
+
+			f, err := readConfigFile()
+			if err != nil {
+				return fmt.Errorf("read config: %w", err)
+			}
+			name := profileName(cmd)
+			if name == "" {
+				name = f.currentProfile()
+			}
+			if name == "" {
+				name = DefaultProfileName
+			}
+			prof, _, err := f.profile(name)
+			if err != nil {
+				return err
+			}
+			field := profileField(&prof, key)
+			if field == nil {
+				return fmt.Errorf("unknown key %q: must be one of %s", key, profileKeys)
+			}
+			*field = value
+			f.setProfile(name, prof)
+			if f.currentProfile() == "" {
+				f.setCurrentProfile(name)
+			}
+			if err := f.write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func NewConfigGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get [key]",
+		Short: "Print a value of the profile, or the whole profile",
+		Long: `Print a value of the profile, or the whole profile.
+
+Keys are ` + profileKeys + `.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			f, err := readConfigFile()
+			if err != nil {
+				return fmt.Errorf("read config: %w", err)
+			}
+			name, err := targetProfile(cmd, f)
+			if err != nil {
+				return err
+			}
+			prof, ok, err := f.profile(name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("profile %q not found", name)
+			}
+
+			if len(args) > 0 {
+				field := profileField(&prof, args[0])
+				if field == nil {
+					return fmt.Errorf("unknown key %q: must be one of %s", args[0], profileKeys)
+				}
+				fmt.Println(*field)
+				return nil
+			}
+			t := table{header: []string{"Key", "Value"}}
+			t.add("server-url", prof.ServerURL)
+			t.add("access-key", prof.AccessKey)
+			t.add("bot", prof.Bot)
+			t.add("room", prof.Room)
+			return p.Print(prof, t)
+		},
+	}
+	return cmd
+}
+
+func NewConfigUseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "use [profile]",
+		Short: "Make a profile current",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			name := args[0]
+
+			f, err := readConfigFile()
+			if err != nil {
+				return fmt.Errorf("read config: %w", err)
+			}
+			if _, ok, err := f.profile(name); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("profile %q not found", name)
+			}
+			f.setCurrentProfile(name)
+			if err := f.write(); err != nil {
+				return fmt.Errorf("write config: %w", err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func NewConfigListCmd() *cobra.Command {
+	var showKeys bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			f, err := readConfigFile()
+			if err != nil {
+				return fmt.Errorf("read config: %w", err)
+			}
+
+			type profileResponse struct {
+				Name    string `json:"name"`
+				Current bool   `json:"current"`
+				Profile
+			}
+			var profiles []profileResponse
+			t := table{header: []string{"", "Name", "Server URL", "Access Key", "Bot", "Room"}}
+			current := f.currentProfile()
+			for _, name := range f.profileNames() {
+				prof, _, err := f.profile(name)
+				if err != nil {
+					return err
+				}
+				if !showKeys {
+					prof.AccessKey = maskKey(prof.AccessKey)
+				}
+				resp := profileResponse{Name: name, Current: name == current, Profile: prof}
+				profiles = append(profiles, resp)
+				mark := ""
+				if resp.Current {
+					mark = "*"
+				}
+				t.add(mark, name, prof.ServerURL, prof.AccessKey, prof.Bot, prof.Room)
+			}
+			return p.Print(profiles, t)
+		},
+	}
+	cmd.Flags().BoolVar(&showKeys, "show-keys", false, "Show access keys instead of masking them")
+	return cmd
+}
+
+// maskKey masks all but the last 4 characters of an access key.
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return key
+	}
+	return "****" + key[len(key)-4:]
+}
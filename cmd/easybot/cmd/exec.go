@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+// Modes and input formats of `easybot bot run`.
+const (
+	execModeMessage = "message" // a process per message.
+	execModeRoom    = "room"    // a long-lived process per room.
+
+	execInputText = "text"
+	execInputJSON = "json"
+)
+
+// execStopTimeout is how long a room process has to exit after its stdin is
+// closed, before it's killed.
+const execStopTimeout = 5 * time.Second
+
+type execConfig struct {
+	command     string
+	args        []string
+	mode        string
+	input       string
+	timeout     time.Duration // of a process in message mode, or a write in room mode.
+	idleTimeout time.Duration // of a process in room mode.
+}
+
+// execHandler handles messages by an external program.
+type execHandler struct {
+	botID string
+	cfg   execConfig
+
+	mu    sync.Mutex
+	procs map[string]*roomProcess // by room ID, in room mode.
+	stop  chan struct{}
+}
+
+// roomProcess is a long-lived process of a room.
+type roomProcess struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	lastUsed time.Time // guarded by execHandler.mu.
+	writing  int       // writes in flight, guarded by execHandler.mu.
+	done     chan struct{}
+}
+
+func newExecHandler(botID string, cfg execConfig) *execHandler {
+	h := &execHandler{
+		botID: botID,
+		cfg:   cfg,
+		procs: make(map[string]*roomProcess),
+		stop:  make(chan struct{}),
+	}
+	if cfg.mode == execModeRoom && cfg.idleTimeout > 0 {
+		go h.reap()
+	}
+	return h
+}
+
+func (h *execHandler) HandleMessage(ctx *client.Context) error {
+	if h.cfg.mode == execModeRoom {
+		return h.handleInRoom(ctx)
+	}
+	return h.handleOnce(ctx)
+}
+
+// handleOnce runs a process for the message, and replies with its output.
+func (h *execHandler) handleOnce(ctx *client.Context) error {
+	input, err := h.encode(ctx.Message, false)
+	if err != nil {
+		return err
+	}
+	runCtx := context.Context(ctx)
+	if h.cfg.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, h.cfg.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(runCtx, h.cfg.command, h.cfg.args...)
+	cmd.Env = h.env(ctx.Room.ID)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", h.cfg.command, err)
+	}
+	// Don't wait for the output to end after a timeout, since children of
+	// the killed process may still hold it open.
+	outc := make(chan []byte, 1)
+	go func() {
+		out, _ := io.ReadAll(stdout)
+		outc <- out
+	}()
+	var out []byte
+	select {
+	case out = <-outc:
+	case <-runCtx.Done():
+	}
+	err = cmd.Wait()
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", h.cfg.command, h.cfg.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("run %s: %w", h.cfg.command, err)
+	}
+	msgs := h.decode(out)
+	if len(msgs) == 0 {
+		return nil
+	}
+	return ctx.Room.WriteMessages(ctx, msgs)
+}
+
+// handleInRoom writes the message to the room's process, starting it if
+// needed. The process replies on its own.
+func (h *execHandler) handleInRoom(ctx *client.Context) error {
+	input, err := h.encode(ctx.Message, true)
+	if err != nil {
+		return err
+	}
+	p, err := h.roomProcess(ctx.Room)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.stdin.Write(input)
+		errc <- err
+	}()
+	var timeout <-chan time.Time
+	if h.cfg.timeout > 0 {
+		t := time.NewTimer(h.cfg.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	timedOut := false
+	select {
+	case err = <-errc:
+	case <-timeout:
+		timedOut = true
+	}
+	h.mu.Lock()
+	p.writing--
+	p.lastUsed = time.Now()
+	if timedOut && h.procs[ctx.Room.ID] == p {
+		delete(h.procs, ctx.Room.ID)
+	}
+	h.mu.Unlock()
+	if timedOut {
+		// The process doesn't read its input, so kill it; the next message
+		// starts a new one. Closing stdin unblocks the pending write.
+		p.stdin.Close()
+		p.cmd.Process.Kill()
+		return fmt.Errorf("write to %s timed out after %s", h.cfg.command, h.cfg.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("write to %s: %w", h.cfg.command, err)
+	}
+	return nil
+}
+
+// roomProcess returns the running process of the room, or starts one. The
+// process counts as being written to until the caller decrements writing, so
+// that it isn't reaped in between.
+func (h *execHandler) roomProcess(room *client.Room) (*roomProcess, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok := h.procs[room.ID]; ok {
+		select {
+		case <-p.done:
+		default:
+			p.lastUsed = time.Now()
+			p.writing++
+			return p, nil
+		}
+	}
+
+	cmd := exec.Command(h.cfg.command, h.cfg.args...)
+	cmd.Env = h.env(room.ID)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", h.cfg.command, err)
+	}
+	p := &roomProcess{cmd: cmd, stdin: stdin, lastUsed: time.Now(), writing: 1, done: make(chan struct{})}
+	h.procs[room.ID] = p
+
+	go func() {
+		defer close(p.done)
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			msgs := h.decode(sc.Bytes())
+			if len(msgs) == 0 {
+				continue
+			}
+			if err := room.WriteMessages(context.Background(), msgs); err != nil {
+				fmt.Fprintf(os.Stderr, "room %s: write messages: %v\n", room.ID, err)
+			}
+		}
+		if err := cmd.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "room %s: %s exited: %v\n", room.ID, h.cfg.command, err)
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.procs[room.ID] == p {
+			delete(h.procs, room.ID)
+		}
+	}()
+	return p, nil
+}
+
+// reap stops room processes which have been idle for the idle timeout.
+func (h *execHandler) reap() {
+	interval := h.cfg.idleTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+		h.mu.Lock()
+		for id, p := range h.procs {
+			if p.writing == 0 && time.Since(p.lastUsed) >= h.cfg.idleTimeout {
+				delete(h.procs, id)
+				go p.terminate()
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// close stops all room processes.
+func (h *execHandler) close() {
+	close(h.stop)
+	h.mu.Lock()
+	procs := h.procs
+	h.procs = make(map[string]*roomProcess)
+	h.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, p := range procs {
+		wg.Add(1)
+		go func(p *roomProcess) {
+			defer wg.Done()
+			p.terminate()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// terminate closes the process's stdin so that it exits, and kills it if it
+// doesn't in time.
+func (p *roomProcess) terminate() {
+	p.stdin.Close()
+	select {
+	case <-p.done:
+	case <-time.After(execStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+func (h *execHandler) env(roomID string) []string {
+	return append(os.Environ(),
+		"EASYBOT_BOT_ID="+h.botID,
+		"EASYBOT_ROOM_ID="+roomID,
+	)
+}
+
+// encode encodes msg for the program's stdin, on a single line if line is
+// set.
+func (h *execHandler) encode(msg easybot.MessageResponse, line bool) ([]byte, error) {
+	if h.cfg.input == execInputJSON {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	text := msg.Text
+	if line {
+		text = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(text)
+	}
+	return []byte(text + "\n"), nil
+}
+
+// decode decodes output of the program into messages. In json mode, a JSON
+// object or an array of them is decoded as messages; anything else is text.
+func (h *execHandler) decode(out []byte) []easybot.MessageRequest {
+	text := strings.TrimSpace(string(out))
+	if text == "" {
+		return nil
+	}
+	if h.cfg.input == execInputJSON {
+		switch text[0] {
+		case '{':
+			var msg easybot.MessageRequest
+			if err := json.Unmarshal([]byte(text), &msg); err == nil {
+				return []easybot.MessageRequest{msg}
+			}
+		case '[':
+			var msgs []easybot.MessageRequest
+			if err := json.Unmarshal([]byte(text), &msgs); err == nil {
+				return msgs
+			}
+		}
+	}
+	return []easybot.MessageRequest{{Text: text}}
+}
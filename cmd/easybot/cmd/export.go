@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+func NewExportCmd() *cobra.Command {
+	var (
+		bots       []string
+		accessKeys bool
+		format     string
+	)
+	cmd := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export bots, rooms and messages (admin)",
+		Long: `Export bots, with their rooms and messages, to a file or stdout.
+
+The access key must be the server's admin key. Access keys of bots and rooms
+are included only with --access-keys, so keep such exports safe. The format is
+ndjson (a JSON record per line) or tar (an archive of ndjson files); it's
+chosen by the file's extension unless --format is given.`,
+		Example: `  easybot export backup.ndjson
+  easybot export --bot <bot-id> --access-keys backup.tar`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			path := "-"
+			if len(args) > 0 {
+				path = args[0]
+			}
+			f, err := exportFormat(format, path)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			return writeFile(path, func(w io.Writer) error {
+				if err := c.Export(context.TODO(), w, client.ExportOptions{
+					Bots:       bots,
+					AccessKeys: accessKeys,
+					Format:     f,
+				}); err != nil {
+					return fmt.Errorf("export: %w", err)
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringArrayVarP(&bots, "bot", "b", nil, "Export only the bot (repeatable)")
+	cmd.Flags().BoolVar(&accessKeys, "access-keys", false, "Include access keys of bots and rooms")
+	cmd.Flags().StringVar(&format, "format", "", "Format: ndjson|tar (default: by the file extension, or ndjson)")
+	return cmd
+}
+
+func NewImportCmd() *cobra.Command {
+	var (
+		format   string
+		conflict string
+	)
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import bots, rooms and messages from an export (admin)",
+		Long: `Import bots, rooms and messages from a file made by easybot export, or
+stdin with -.
+
+The access key must be the server's admin key. IDs and timestamps are
+preserved. Bots and rooms whose IDs already exist are kept with --conflict
+skip (the default), replaced with --conflict overwrite, or make the import
+fail without importing anything with --conflict fail. Bots and rooms created
+without access keys in the export get new ones, which are printed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			policy := easybot.ConflictPolicy(conflict)
+			switch policy {
+			case easybot.ConflictSkip, easybot.ConflictOverwrite, easybot.ConflictFail:
+			default:
+				return fmt.Errorf("unknown conflict policy %q: must be one of skip, overwrite, fail", conflict)
+			}
+
+			// The export is streamed to the server, which may take more than
+			// fits in memory.
+			file := os.Stdin
+			if args[0] != "-" {
+				if file, err = os.Open(args[0]); err != nil {
+					return fmt.Errorf("open export: %w", err)
+				}
+				defer file.Close()
+			}
+			r := bufio.NewReader(file)
+			f, err := exportFormat(format, args[0])
+			if err != nil {
+				return err
+			}
+			if format == "" {
+				// A short export is never a tar archive, so the error of a
+				// short peek doesn't matter.
+				if head, _ := r.Peek(tarMagicEnd); isTar(head) {
+					f = easybot.ExportTar
+				}
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			resp, err := c.Import(context.TODO(), r, client.ImportOptions{
+				Format:   f,
+				Conflict: policy,
+			})
+			if err != nil {
+				return fmt.Errorf("import: %w", err)
+			}
+
+			t := table{header: []string{"Kind", "Created", "Overwritten", "Skipped"}}
+			for _, kind := range []struct {
+				name  string
+				count easybot.ImportCount
+			}{{"bots", resp.Bots}, {"rooms", resp.Rooms}, {"messages", resp.Messages}} {
+				t.add(kind.name, strconv.Itoa(kind.count.Created), strconv.Itoa(kind.count.Overwritten), strconv.Itoa(kind.count.Skipped))
+			}
+			if err := p.Print(resp, t); err != nil {
+				return err
+			}
+			if p.Table() && len(resp.AccessKeys) > 0 {
+				fmt.Println("\nNew access keys:")
+				ids := make([]string, 0, len(resp.AccessKeys))
+				for id := range resp.AccessKeys {
+					ids = append(ids, id)
+				}
+				sort.Strings(ids)
+				w := bufio.NewWriter(os.Stdout)
+				for _, id := range ids {
+					fmt.Fprintf(w, "%s  %s\n", id, resp.AccessKeys[id])
+				}
+				return w.Flush()
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "", "Format: ndjson|tar (default: detected)")
+	cmd.Flags().StringVar(&conflict, "conflict", string(easybot.ConflictSkip), "What to do with existing IDs: skip|overwrite|fail")
+	return cmd
+}
+
+// exportFormat returns the export format named by the flag, or chosen by
+// the extension of path.
+func exportFormat(flag, path string) (easybot.ExportFormat, error) {
+	switch f := easybot.ExportFormat(flag); f {
+	case easybot.ExportNDJSON, easybot.ExportTar:
+		return f, nil
+	case "":
+		if filepath.Ext(path) == ".tar" {
+			return easybot.ExportTar, nil
+		}
+		return easybot.ExportNDJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q: must be one of ndjson, tar", flag)
+}
+
+// tarMagicEnd is the end of the magic in the header of a tar archive.
+const tarMagicEnd = 263
+
+// isTar reports whether data, the start of a file, looks like a tar archive.
+func isTar(data []byte) bool {
+	return len(data) >= tarMagicEnd && bytes.HasPrefix(data[257:], []byte("ustar"))
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot/transcript"
+)
+
+func NewGradeCmd() *cobra.Command {
+	var (
+		csvPath, jsonPath string
+		concurrency       int
+		timeLimit         time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "grade [rubric] [bot...]",
+		Short: "Grade bots with a rubric of transcripts",
+		Long: `Grade bots with a rubric of transcripts.
+
+Every case of the rubric is run against each bot, and the bot's score is the
+sum of the weights of passed cases. If no bot is given, all bots are graded.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			rubric, err := transcript.LoadRubric(args[0])
+			if err != nil {
+				return fmt.Errorf("load rubric: %w", err)
+			}
+			if concurrency > 0 {
+				rubric.Concurrency = concurrency
+			}
+			if timeLimit > 0 {
+				rubric.TimeLimit = timeLimit
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var bots []transcript.BotRef
+			if len(args) > 1 {
+				for _, id := range args[1:] {
+					ref := transcript.BotRef{ID: id}
+					if bot, err := c.GetBot(context.TODO(), id); err == nil {
+						ref.Name = bot.Name
+					}
+					bots = append(bots, ref)
+				}
+			} else {
+				resp, err := c.ListBots(context.TODO())
+				if err != nil {
+					return fmt.Errorf("list bots: %w", err)
+				}
+				for _, bot := range resp {
+					bots = append(bots, transcript.BotRef{ID: bot.ID.Hex(), Name: bot.Name})
+				}
+			}
+
+			grades := transcript.GradeBots(context.TODO(), c, bots, rubric, func(g transcript.Grade) {
+				fmt.Fprintf(os.Stderr, "graded %s %s: %g/%g\n", g.BotID, g.BotName, g.Score, g.MaxScore)
+			})
+
+			t := table{header: []string{"Bot", "Name", "Score", "Percent", "Failures"}}
+			for _, g := range grades {
+				t.add(g.BotID, g.BotName, fmt.Sprintf("%g/%g", g.Score, g.MaxScore), fmt.Sprintf("%.1f%%", g.Percent()), strconv.Itoa(len(g.Failures())))
+			}
+			if err := p.Print(grades, t); err != nil {
+				return err
+			}
+			if p.Table() {
+				for _, g := range grades {
+					for _, f := range g.Failures() {
+						fmt.Printf("%s: %s\n", g.BotID, strings.ReplaceAll(f, "\n", " "))
+					}
+				}
+			}
+
+			if csvPath != "" {
+				if err := writeFile(csvPath, func(w io.Writer) error {
+					return transcript.WriteGradebookCSV(w, rubric, grades)
+				}); err != nil {
+					return fmt.Errorf("write csv gradebook: %w", err)
+				}
+			}
+			if jsonPath != "" {
+				if err := writeFile(jsonPath, func(w io.Writer) error {
+					return transcript.WriteGradebookJSON(w, grades)
+				}); err != nil {
+					return fmt.Errorf("write json gradebook: %w", err)
+				}
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&csvPath, "csv", "", "Write a CSV gradebook to the file")
+	cmd.Flags().StringVar(&jsonPath, "json", "", "Write a JSON gradebook to the file")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", 0, "Number of bots graded at the same time (overrides the rubric)")
+	cmd.Flags().DurationVarP(&timeLimit, "time-limit", "t", 0, "Time limit per bot (overrides the rubric)")
+	return cmd
+}
@@ -0,0 +1,652 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+func NewInteractCmd() *cobra.Command {
+	var history int
+	cmd := &cobra.Command{
+		Use:   "interact [bot] [room]",
+		Short: "Chat within a room",
+		Long: `Chat within a room in a full-screen terminal UI.
+
+Type a message and press Enter to send it. Messages of the bot are shown as
+they arrive, and marked as read. A bot message may offer quick replies: press
+Tab to pick one, or Alt+1..9, and Enter to send it, or click it.
+
+Keys: PgUp/PgDn or the mouse wheel scroll, Esc clears the input and Ctrl+C
+quits.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			args, err := idArgs(cmd, args, 2, 2)
+			if err != nil {
+				return err
+			}
+
+			botID := args[0]
+			roomID := args[1]
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			bot, err := c.GetBot(context.TODO(), botID)
+			if err != nil {
+				return fmt.Errorf("get bot: %w", err)
+			}
+			room := c.Room(botID, roomID)
+			var msgs []easybot.MessageResponse
+			if history > 0 {
+				msgs, err = loadHistory(context.TODO(), room, history)
+				if err != nil {
+					return fmt.Errorf("load history: %w", err)
+				}
+			}
+
+			screen, err := tcell.NewScreen()
+			if err != nil {
+				return fmt.Errorf("interact needs a terminal: %w", err)
+			}
+			if err := screen.Init(); err != nil {
+				return fmt.Errorf("init terminal: %w", err)
+			}
+			defer screen.Fini()
+			screen.EnableMouse()
+
+			ch := newChat(screen, room, bot)
+			for _, msg := range msgs {
+				ch.add(msg)
+			}
+			if !bot.Online {
+				ch.status = "warning: bot is offline"
+			}
+			return ch.run(context.Background())
+		},
+	}
+	cmd.Flags().IntVar(&history, "history", 50, "Number of earlier messages to show")
+	return cmd
+}
+
+// loadHistory returns the last n messages of the room, up to
+// easybot.MaxHistoryLimit.
+func loadHistory(ctx context.Context, room *client.Room, n int) ([]easybot.MessageResponse, error) {
+	return room.History(ctx, client.HistoryOptions{Last: n})
+}
+
+// Styles of the chat UI.
+var (
+	chatHeaderStyle = tcell.StyleDefault.Reverse(true)
+	chatTimeStyle   = tcell.StyleDefault.Dim(true)
+	chatUserStyle   = tcell.StyleDefault.Foreground(tcell.ColorGreen).Bold(true)
+	chatBotStyle    = tcell.StyleDefault.Foreground(tcell.ColorTeal).Bold(true)
+	chatDimStyle    = tcell.StyleDefault.Dim(true)
+	chatButtonStyle = tcell.StyleDefault.Reverse(true)
+	chatPickedStyle = tcell.StyleDefault.Reverse(true).Bold(true).Foreground(tcell.ColorYellow)
+)
+
+// span is a run of text in a style.
+type span struct {
+	text  string
+	style tcell.Style
+}
+
+// button is a quick-reply button on the screen, for mouse clicks.
+type button struct {
+	x, y, width int
+	text        string
+}
+
+// chat is the state of the chat UI. Everything but run's goroutines runs on
+// the event loop.
+type chat struct {
+	screen tcell.Screen
+	room   *client.Room
+	bot    easybot.BotResponse
+
+	msgs []easybot.MessageResponse
+	seen map[primitive.ObjectID]bool
+
+	input    []rune
+	cursor   int
+	scroll   int // lines scrolled up from the bottom.
+	picked   int // index of the picked quick reply, or -1.
+	buttons  []button
+	status   string
+	sending  int // number of messages being sent.
+	readBots chan struct{}
+	receipts chan struct{}
+
+	// Messages are sent one at a time in the order they were typed, by
+	// sendQueued.
+	mu     sync.Mutex
+	outbox []string
+	sends  chan struct{}
+}
+
+// Data of interrupt events posted to the event loop.
+type (
+	chatReceipts []easybot.MessageResponse
+	chatSent     struct{ err error }
+	chatError    struct{ err error }
+)
+
+func newChat(screen tcell.Screen, room *client.Room, bot easybot.BotResponse) *chat {
+	return &chat{
+		screen:   screen,
+		room:     room,
+		bot:      bot,
+		seen:     make(map[primitive.ObjectID]bool),
+		picked:   -1,
+		readBots: make(chan struct{}, 1),
+		receipts: make(chan struct{}, 1),
+		sends:    make(chan struct{}, 1),
+	}
+}
+
+// run runs the event loop until the user quits.
+func (ch *chat) run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	after := ""
+	if n := len(ch.msgs); n > 0 {
+		after = ch.msgs[n-1].ID.Hex()
+	}
+	go func() {
+		err := ch.room.Watch(ctx, func(msg easybot.MessageResponse) error {
+			ch.screen.PostEventWait(tcell.NewEventInterrupt(msg))
+			return nil
+		}, client.WatchConfig{
+			After: after,
+			OnError: func(err error) {
+				ch.screen.PostEvent(tcell.NewEventInterrupt(chatError{err}))
+			},
+		})
+		if err != nil {
+			ch.screen.PostEvent(tcell.NewEventInterrupt(chatError{err}))
+		}
+	}()
+	go ch.markRead(ctx)
+	go ch.pollReceipts(ctx)
+	go ch.sendQueued(ctx)
+	ch.signal(ch.readBots)
+	ch.signal(ch.receipts)
+
+	for {
+		ch.draw()
+		switch ev := ch.screen.PollEvent().(type) {
+		case nil:
+			return nil
+		case *tcell.EventResize:
+			ch.screen.Sync()
+		case *tcell.EventKey:
+			if quit := ch.handleKey(ctx, ev); quit {
+				return nil
+			}
+		case *tcell.EventMouse:
+			ch.handleMouse(ctx, ev)
+		case *tcell.EventInterrupt:
+			switch data := ev.Data().(type) {
+			case easybot.MessageResponse:
+				ch.add(data)
+				if data.Type == easybot.BotMessage {
+					ch.signal(ch.readBots)
+				} else {
+					ch.signal(ch.receipts)
+				}
+			case chatReceipts:
+				ch.updateReceipts(data)
+			case chatSent:
+				ch.sending--
+				if data.err != nil {
+					ch.status = "error: " + data.err.Error()
+				}
+			case chatError:
+				ch.status = "error: " + data.err.Error()
+			}
+		}
+	}
+}
+
+// signal wakes up a goroutine waiting on c, if it's not awake already.
+func (ch *chat) signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// markRead reads bot messages, so that the bot sees them as read. They're
+// shown by the watch.
+func (ch *chat) markRead(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch.readBots:
+		}
+		if _, err := ch.room.ReadMessages(ctx, false); err != nil && ctx.Err() == nil {
+			ch.screen.PostEvent(tcell.NewEventInterrupt(chatError{fmt.Errorf("read messages: %w", err)}))
+		}
+	}
+}
+
+// pollReceipts polls the delivery status of sent messages until the last one
+// is read.
+func (ch *chat) pollReceipts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch.receipts:
+		}
+		for {
+			sent, err := ch.room.SentMessages(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				ch.screen.PostEvent(tcell.NewEventInterrupt(chatError{fmt.Errorf("sent messages: %w", err)}))
+				break
+			}
+			ch.screen.PostEvent(tcell.NewEventInterrupt(chatReceipts(sent)))
+			if n := len(sent); n == 0 || sent[n-1].Status == easybot.MessageRead {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (ch *chat) add(msg easybot.MessageResponse) {
+	if ch.seen[msg.ID] {
+		return
+	}
+	ch.seen[msg.ID] = true
+	ch.msgs = append(ch.msgs, msg)
+	if msg.Type == easybot.BotMessage {
+		ch.picked = -1
+	}
+}
+
+func (ch *chat) updateReceipts(sent []easybot.MessageResponse) {
+	byID := make(map[primitive.ObjectID]easybot.MessageResponse, len(sent))
+	for _, msg := range sent {
+		byID[msg.ID] = msg
+	}
+	for i, msg := range ch.msgs {
+		if s, ok := byID[msg.ID]; ok {
+			ch.msgs[i].Status = s.Status
+			ch.msgs[i].ReadAt = s.ReadAt
+		}
+	}
+}
+
+// quickReplies returns the quick replies on offer: those of the last message,
+// if it's from the bot.
+func (ch *chat) quickReplies() []string {
+	n := len(ch.msgs)
+	if n == 0 || ch.msgs[n-1].Type != easybot.BotMessage {
+		return nil
+	}
+	return ch.msgs[n-1].QuickReplies
+}
+
+func (ch *chat) send(ctx context.Context, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	ch.sending++
+	ch.status = ""
+	ch.scroll = 0
+	ch.mu.Lock()
+	ch.outbox = append(ch.outbox, text)
+	ch.mu.Unlock()
+	ch.signal(ch.sends)
+}
+
+// sendQueued writes queued messages in order, so that messages typed in quick
+// succession aren't reordered.
+func (ch *chat) sendQueued(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch.sends:
+		}
+		for {
+			ch.mu.Lock()
+			if len(ch.outbox) == 0 {
+				ch.mu.Unlock()
+				break
+			}
+			text := ch.outbox[0]
+			ch.outbox = ch.outbox[1:]
+			ch.mu.Unlock()
+			err := ch.room.WriteMessages(ctx, []easybot.MessageRequest{{Text: text}})
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				err = fmt.Errorf("write messages: %w", err)
+			}
+			ch.screen.PostEvent(tcell.NewEventInterrupt(chatSent{err}))
+		}
+	}
+}
+
+// handleKey handles a key, and reports whether the user quits.
+func (ch *chat) handleKey(ctx context.Context, ev *tcell.EventKey) bool {
+	quick := ch.quickReplies()
+	_, h := ch.screen.Size()
+	page := h - 4
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		return true
+	case tcell.KeyCtrlD:
+		if len(ch.input) == 0 {
+			return true
+		}
+	case tcell.KeyEnter:
+		if len(ch.input) == 0 && ch.picked >= 0 && ch.picked < len(quick) {
+			ch.send(ctx, quick[ch.picked])
+		} else {
+			ch.send(ctx, string(ch.input))
+		}
+		ch.input, ch.cursor, ch.picked = nil, 0, -1
+	case tcell.KeyTab, tcell.KeyBacktab:
+		if len(quick) > 0 {
+			step := 1
+			if ev.Key() == tcell.KeyBacktab {
+				step = len(quick) - 1
+			}
+			// Cycle through the quick replies and none, which is -1.
+			ch.picked = (ch.picked+1+step)%(len(quick)+1) - 1
+		}
+	case tcell.KeyEscape:
+		ch.input, ch.cursor, ch.picked = nil, 0, -1
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if ch.cursor > 0 {
+			ch.input = append(ch.input[:ch.cursor-1], ch.input[ch.cursor:]...)
+			ch.cursor--
+		}
+	case tcell.KeyDelete:
+		if ch.cursor < len(ch.input) {
+			ch.input = append(ch.input[:ch.cursor], ch.input[ch.cursor+1:]...)
+		}
+	case tcell.KeyLeft:
+		if ch.cursor > 0 {
+			ch.cursor--
+		}
+	case tcell.KeyRight:
+		if ch.cursor < len(ch.input) {
+			ch.cursor++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		ch.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		ch.cursor = len(ch.input)
+	case tcell.KeyCtrlU:
+		ch.input = append([]rune(nil), ch.input[ch.cursor:]...)
+		ch.cursor = 0
+	case tcell.KeyUp:
+		ch.scroll++
+	case tcell.KeyDown:
+		ch.scroll--
+	case tcell.KeyPgUp:
+		ch.scroll += page
+	case tcell.KeyPgDn:
+		ch.scroll -= page
+	case tcell.KeyRune:
+		r := ev.Rune()
+		if ev.Modifiers()&tcell.ModAlt != 0 {
+			if i := int(r - '1'); i >= 0 && i < len(quick) && i < 9 {
+				ch.send(ctx, quick[i])
+				ch.picked = -1
+			}
+			return false
+		}
+		ch.input = append(ch.input[:ch.cursor], append([]rune{r}, ch.input[ch.cursor:]...)...)
+		ch.cursor++
+		ch.picked = -1
+	}
+	if ch.scroll < 0 {
+		ch.scroll = 0
+	}
+	return false
+}
+
+func (ch *chat) handleMouse(ctx context.Context, ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	switch ev.Buttons() {
+	case tcell.WheelUp:
+		ch.scroll++
+	case tcell.WheelDown:
+		if ch.scroll > 0 {
+			ch.scroll--
+		}
+	case tcell.Button1:
+		for _, b := range ch.buttons {
+			if y == b.y && x >= b.x && x < b.x+b.width {
+				ch.send(ctx, b.text)
+				ch.picked = -1
+				return
+			}
+		}
+	}
+}
+
+// draw draws the screen: the header, the scrollback, the quick replies, the
+// status line and the input line.
+func (ch *chat) draw() {
+	s := ch.screen
+	s.Clear()
+	w, h := s.Size()
+	if w < 10 || h < 5 {
+		s.Show()
+		return
+	}
+
+	presence := "offline"
+	if ch.bot.Online {
+		presence = "online"
+	}
+	header := fmt.Sprintf(" %s · room %s · %s", ch.bot.Name, ch.room.ID, presence)
+	ch.fill(0, chatHeaderStyle)
+	ch.drawSpans(0, 0, w, []span{{header, chatHeaderStyle}})
+
+	// Scrollback, from the bottom up.
+	lines := ch.lines(w)
+	height := h - 4
+	if max := len(lines) - height; ch.scroll > max {
+		ch.scroll = max
+		if ch.scroll < 0 {
+			ch.scroll = 0
+		}
+	}
+	end := len(lines) - ch.scroll
+	start := end - height
+	if start < 0 {
+		start = 0
+	}
+	for i, line := range lines[start:end] {
+		ch.drawSpans(0, 1+i, w, line)
+	}
+
+	// Quick replies.
+	ch.buttons = ch.buttons[:0]
+	x, y := 0, h-3
+	for i, text := range ch.quickReplies() {
+		label := fmt.Sprintf(" %d %s ", i+1, text)
+		if i >= 9 {
+			label = " " + text + " "
+		}
+		style := chatButtonStyle
+		if i == ch.picked {
+			style = chatPickedStyle
+		}
+		width := runewidth.StringWidth(label)
+		if x+width > w {
+			break
+		}
+		ch.drawSpans(x, y, w, []span{{label, style}})
+		ch.buttons = append(ch.buttons, button{x: x, y: y, width: width, text: text})
+		x += width + 1
+	}
+
+	// Status line.
+	status := ch.status
+	if status == "" {
+		switch {
+		case ch.sending > 0:
+			status = "sending..."
+		case ch.scroll > 0:
+			status = fmt.Sprintf("scrolled up %d lines · PgDn to go back", ch.scroll)
+		case len(ch.buttons) > 0:
+			status = "Tab/Alt+1..9 to pick a quick reply · Ctrl+C to quit"
+		default:
+			status = "Enter to send · PgUp/PgDn to scroll · Ctrl+C to quit"
+		}
+	}
+	ch.drawSpans(0, h-2, w, []span{{status, chatDimStyle}})
+
+	// Input line, scrolled to keep the cursor visible.
+	prompt := "> "
+	avail := w - len(prompt) - 1
+	offset := 0
+	for runewidth.StringWidth(string(ch.input[offset:ch.cursor])) > avail {
+		offset++
+	}
+	ch.drawSpans(0, h-1, w, []span{{prompt, chatDimStyle}, {string(ch.input[offset:]), tcell.StyleDefault}})
+	s.ShowCursor(len(prompt)+runewidth.StringWidth(string(ch.input[offset:ch.cursor])), h-1)
+	s.Show()
+}
+
+// lines renders the messages into lines of width w.
+func (ch *chat) lines(w int) [][]span {
+	var lines [][]span
+	var lastDay string
+	for _, msg := range ch.msgs {
+		t := msg.CreatedAt.In(time.Local)
+		if day := t.Format("Mon, Jan 2 2006"); day != lastDay {
+			lastDay = day
+			lines = append(lines, []span{{"── " + day + " ──", chatDimStyle}})
+		}
+		label, style := "you", chatUserStyle
+		if msg.Type == easybot.BotMessage {
+			label, style = ch.bot.Name, chatBotStyle
+		}
+		label = truncate(label, 12)
+		prefix := []span{{t.Format("15:04:05") + " ", chatTimeStyle}, {fmt.Sprintf("%-12s ", label), style}}
+		indent := strings.Repeat(" ", 9+12+1)
+		text := msg.Text
+		if msg.Type == easybot.UserMessage && msg.Status == easybot.MessageRead {
+			text += " ✓"
+		}
+		for i, line := range wrap(text, w-len(indent)) {
+			if i == 0 {
+				lines = append(lines, append(prefix, span{line, tcell.StyleDefault}))
+			} else {
+				lines = append(lines, []span{{indent + line, tcell.StyleDefault}})
+			}
+		}
+		if len(msg.QuickReplies) > 0 {
+			line := []span{{indent, tcell.StyleDefault}}
+			for _, text := range msg.QuickReplies {
+				line = append(line, span{"[" + text + "]", chatDimStyle}, span{" ", tcell.StyleDefault})
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (ch *chat) fill(y int, style tcell.Style) {
+	w, _ := ch.screen.Size()
+	for x := 0; x < w; x++ {
+		ch.screen.SetContent(x, y, ' ', nil, style)
+	}
+}
+
+// drawSpans draws spans from x on row y, clipped at the width w.
+func (ch *chat) drawSpans(x, y, w int, spans []span) {
+	for _, sp := range spans {
+		for _, r := range sp.text {
+			rw := runewidth.RuneWidth(r)
+			if x+rw > w {
+				return
+			}
+			ch.screen.SetContent(x, y, r, nil, sp.style)
+			x += rw
+		}
+	}
+}
+
+// wrap wraps text into lines of the width, breaking at spaces when it can.
+func wrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := []rune(strings.TrimRightFunc(para, unicode.IsSpace))
+		for runewidth.StringWidth(string(line)) > width {
+			cut, w := 0, 0
+			for cut < len(line) && w+runewidth.RuneWidth(line[cut]) <= width {
+				w += runewidth.RuneWidth(line[cut])
+				cut++
+			}
+			if i := lastSpace(line[:cut+1]); i > 0 {
+				lines = append(lines, string(line[:i]))
+				line = line[i+1:]
+				continue
+			}
+			if cut == 0 {
+				cut = 1
+			}
+			lines = append(lines, string(line[:cut]))
+			line = line[cut:]
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// lastSpace returns the index of the last space in rs, or -1.
+func lastSpace(rs []rune) int {
+	for i := len(rs) - 1; i >= 0; i-- {
+		if unicode.IsSpace(rs[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// truncate truncates s to the width, with an ellipsis.
+func truncate(s string, width int) string {
+	if runewidth.StringWidth(s) <= width {
+		return s
+	}
+	return runewidth.Truncate(s, width, "…")
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats.
+const (
+	OutputTable    = "table"
+	OutputJSON     = "json"
+	OutputYAML     = "yaml"
+	OutputCSV      = "csv"
+	OutputTemplate = "template"
+)
+
+var outputFormats = []string{OutputTable, OutputJSON, OutputYAML, OutputCSV, OutputTemplate}
+
+// addOutputFlags adds the global output flags to the root command.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", OutputTable, "Output format: "+strings.Join(outputFormats, "|"))
+	cmd.PersistentFlags().String("template", "", "Go template for the template output format, executed for each item")
+}
+
+// table is the tabular form of a command's output, used by the table and csv
+// formats.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) add(row ...string) {
+	t.rows = append(t.rows, row)
+}
+
+// printer prints the output of a command in the chosen format.
+type printer struct {
+	w        io.Writer
+	format   string
+	template *template.Template
+
+	wroteHeader bool // by Item.
+}
+
+// newPrinter returns a printer for the output flags of cmd.
+func newPrinter(cmd *cobra.Command) (*printer, error) {
+	format, _ := cmd.Flags().GetString("output")
+	text, _ := cmd.Flags().GetString("template")
+	p := &printer{w: os.Stdout, format: format}
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML, OutputCSV:
+	case OutputTemplate:
+		if text == "" {
+			return nil, fmt.Errorf("--template is required for the template output format")
+		}
+		tmpl, err := template.New("output").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+		p.template = tmpl
+	default:
+		return nil, fmt.Errorf("unknown output format %q: must be one of %s", format, strings.Join(outputFormats, ", "))
+	}
+	return p, nil
+}
+
+// Table reports whether the output is for humans.
+func (p *printer) Table() bool {
+	return p.format == OutputTable
+}
+
+// Print prints v, which is a value or a slice of values, or t for the table
+// and csv formats.
+func (p *printer) Print(v interface{}, t table) error {
+	switch p.format {
+	case OutputJSON:
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYAML:
+		return p.printYAML(v)
+	case OutputCSV:
+		w := csv.NewWriter(p.w)
+		_ = w.Write(t.header)
+		_ = w.WriteAll(t.rows)
+		return w.Error()
+	case OutputTemplate:
+		return p.printTemplate(v)
+	default:
+		return p.printTable(t)
+	}
+}
+
+// Item prints v as an item of a stream, for commands which print items as
+// they come: a JSON line, a YAML document, a csv row or the template output.
+// header is printed before the first csv row. The table format is left to
+// the caller.
+func (p *printer) Item(v interface{}, header, row []string) error {
+	switch p.format {
+	case OutputJSON:
+		return json.NewEncoder(p.w).Encode(v)
+	case OutputYAML:
+		if _, err := io.WriteString(p.w, "---\n"); err != nil {
+			return err
+		}
+		return p.printYAML(v)
+	case OutputCSV:
+		w := csv.NewWriter(p.w)
+		if !p.wroteHeader {
+			_ = w.Write(header)
+			p.wroteHeader = true
+		}
+		_ = w.Write(row)
+		w.Flush()
+		return w.Error()
+	case OutputTemplate:
+		return p.printTemplate(v)
+	}
+	return nil
+}
+
+// printYAML prints v as YAML with the same field names as JSON, by way of
+// JSON.
+func (p *printer) printYAML(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	data, err = yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	_, err = p.w.Write(data)
+	return err
+}
+
+func (p *printer) printTemplate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		rv = reflect.ValueOf([]interface{}{v})
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := p.template.Execute(p.w, rv.Index(i).Interface()); err != nil {
+			return fmt.Errorf("execute template: %w", err)
+		}
+		if _, err := io.WriteString(p.w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTable prints t with columns sized to fit their contents.
+func (p *printer) printTable(t table) error {
+	widths := make([]int, len(t.header))
+	for i, h := range t.header {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); i < len(widths) && n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	dashes := make([]string, len(t.header))
+	for i, w := range widths {
+		dashes[i] = strings.Repeat("-", w)
+	}
+
+	var b strings.Builder
+	writeRow := func(row []string) {
+		for i, cell := range row {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(row)-1 && i < len(widths) {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		b.WriteString("\n")
+	}
+	writeRow(t.header)
+	writeRow(dashes)
+	for _, row := range t.rows {
+		writeRow(row)
+	}
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+// Time formats t for the table and csv formats. Tables show local time for
+// humans and csv shows RFC 3339 for scripts.
+func (p *printer) Time(t time.Time) string {
+	if p.format == OutputCSV {
+		return t.Format(time.RFC3339)
+	}
+	return t.In(time.Local).Format(time.Stamp)
+}
+
+// formatMetadata formats metadata for tables, e.g. "class=a,term=2".
+func formatMetadata(metadata map[string]string) string {
+	var kvs []string
+	for k, v := range metadata {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+// createdResponse is the output of create-bot and create-room.
+type createdResponse struct {
+	ID        string `json:"id"`
+	AccessKey string `json:"accessKey"`
+}
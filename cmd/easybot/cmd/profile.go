@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	"github.com/hallazzang/easybot/client"
+)
+
+// EnvProfile is the environment variable which selects a profile, like
+// --profile.
+const EnvProfile = "EASYBOT_PROFILE"
+
+// Config file keys.
+const (
+	configClientKey   = "Client"
+	configProfileKey  = "Profile"
+	configProfilesKey = "Profiles"
+)
+
+// Profile is a named set of client settings in the config file:
+//
+//	Profile: teacher
+//	Profiles:
+//	  teacher:
+//	    ServerURL: https://easybot.example.com
+//	    AccessKey: <bot-access-key>
+//	    Bot: <bot-id>
+//	  student:
+//	    ServerURL: https://easybot.example.com
+//	    AccessKey: <user-access-key>
+//	    Bot: <bot-id>
+//	    Room: <room-id>
+type Profile struct {
+	ServerURL string `yaml:"ServerURL,omitempty" json:"serverURL,omitempty"`
+	AccessKey string `yaml:"AccessKey,omitempty" json:"accessKey,omitempty"`
+	Bot       string `yaml:"Bot,omitempty" json:"bot,omitempty"`   // default bot ID.
+	Room      string `yaml:"Room,omitempty" json:"room,omitempty"` // default room ID.
+}
+
+// profileField returns the field of prof for a key of `easybot config
+// get|set`, or nil if there's no such key.
+func profileField(prof *Profile, key string) *string {
+	switch key {
+	case "server-url":
+		return &prof.ServerURL
+	case "access-key":
+		return &prof.AccessKey
+	case "bot":
+		return &prof.Bot
+	case "room":
+		return &prof.Room
+	}
+	return nil
+}
+
+// profileKeys are the keys of `easybot config get|set`.
+const profileKeys = "server-url, access-key, bot, room"
+
+// addProfileFlag adds the global --profile flag to the root command.
+func addProfileFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("profile", "P", "", "Profile in the config file to use (default is the current profile, or $"+EnvProfile+")")
+}
+
+// profileName returns the name of the profile to use, or an empty string if
+// there's none.
+func profileName(cmd *cobra.Command) string {
+	if name, _ := cmd.Flags().GetString("profile"); name != "" {
+		return name
+	}
+	if name := os.Getenv(EnvProfile); name != "" {
+		return name
+	}
+	return viper.GetString(configProfileKey)
+}
+
+// loadProfile returns the profile to use. It returns an empty profile if no
+// profile is chosen.
+func loadProfile(cmd *cobra.Command) (Profile, error) {
+	name := profileName(cmd)
+	if name == "" {
+		return Profile{}, nil
+	}
+	key := configProfilesKey + "." + name
+	if !viper.IsSet(key) {
+		return Profile{}, fmt.Errorf("profile %q not found", name)
+	}
+	var prof Profile
+	if err := viper.UnmarshalKey(key, &prof); err != nil {
+		return Profile{}, fmt.Errorf("unmarshal profile %s: %w", name, err)
+	}
+	return prof, nil
+}
+
+// newClient returns a client configured by, in order of precedence, the
+// environment variables, the profile and the Client section of the config
+// file. A profile given with --profile takes precedence over the environment
+// variables, though. opts take precedence over all of them.
+func newClient(cmd *cobra.Command, opts ...client.Option) (*client.Client, error) {
+	cfg := client.DefaultConfig
+	if err := viper.UnmarshalKey(configClientKey, &cfg); err != nil {
+		return nil, fmt.Errorf("unmarshal client config: %w", err)
+	}
+	prof, err := loadProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	profCfg := client.Config{
+		ServerURL: prof.ServerURL,
+		AccessKey: prof.AccessKey,
+	}
+	if cmd.Flags().Changed("profile") {
+		opts = append([]client.Option{cfg, client.FromEnv(), profCfg}, opts...)
+	} else {
+		opts = append([]client.Option{cfg, profCfg, client.FromEnv()}, opts...)
+	}
+	c, err := client.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("new client: %w", err)
+	}
+	return c, nil
+}
+
+// idArgs fills bot and room IDs missing from the front of args with the
+// profile's defaults. n is the number of args including the IDs, and ids is
+// the number of leading IDs, i.e. 1 for [bot] and 2 for [bot] [room]. For
+// example, [bot] [room] [text] may be given as [room] [text] or [text].
+func idArgs(cmd *cobra.Command, args []string, n, ids int) ([]string, error) {
+	missing := n - len(args)
+	if missing <= 0 {
+		return args, nil
+	}
+	if missing > ids {
+		return nil, fmt.Errorf("accepts %d arg(s), received %d", n, len(args))
+	}
+	prof, err := loadProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defaults := []string{prof.Bot, prof.Room}[:missing]
+	for i, id := range defaults {
+		if id == "" {
+			name := []string{"bot", "room"}[i]
+			return nil, fmt.Errorf("%s is required: pass it or set a default %s in the profile", name, name)
+		}
+	}
+	return append(defaults, args...), nil
+}
+
+// botRoomArgs is like idArgs for [bot] [room] where the room is optional.
+// Without args, the profile's default bot and room are used, if any.
+func botRoomArgs(cmd *cobra.Command, args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	prof, err := loadProfile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if prof.Bot == "" {
+		return nil, errors.New("bot is required: pass it or set a default bot in the profile")
+	}
+	if prof.Room == "" {
+		return []string{prof.Bot}, nil
+	}
+	return []string{prof.Bot, prof.Room}, nil
+}
+
+// configFile is the YAML config file, edited by `easybot config`. It keeps
+// keys it doesn't know about as they are.
+type configFile struct {
+	path string
+	data yaml.MapSlice
+}
+
+// configFilePath returns the path of the config file in use, or the default
+// path, ~/.easybot/easybot.yml.
+func configFilePath() (string, error) {
+	if p := viper.ConfigFileUsed(); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("find home directory: %w", err)
+	}
+	return filepath.Join(home, ".easybot", "easybot.yml"), nil
+}
+
+// readConfigFile reads the config file. A missing file is empty.
+func readConfigFile() (*configFile, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	f := &configFile{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &f.data); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// write writes the config file. It's only readable by the user, since it
+// holds access keys.
+func (f *configFile) write() error {
+	data, err := yaml.Marshal(f.data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(f.path, data, 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file, which may have been
+	// created readable by others.
+	return os.Chmod(f.path, 0o600)
+}
+
+// index returns the index of key in ms ignoring case, like viper, or -1.
+func index(ms yaml.MapSlice, key string) int {
+	for i, item := range ms {
+		if k, ok := item.Key.(string); ok && strings.EqualFold(k, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func set(ms yaml.MapSlice, key string, v interface{}) yaml.MapSlice {
+	if i := index(ms, key); i >= 0 {
+		ms[i].Value = v
+		return ms
+	}
+	return append(ms, yaml.MapItem{Key: key, Value: v})
+}
+
+// currentProfile returns the name of the current profile.
+func (f *configFile) currentProfile() string {
+	if i := index(f.data, configProfileKey); i >= 0 {
+		name, _ := f.data[i].Value.(string)
+		return name
+	}
+	return ""
+}
+
+func (f *configFile) setCurrentProfile(name string) {
+	f.data = set(f.data, configProfileKey, name)
+}
+
+func (f *configFile) profiles() yaml.MapSlice {
+	if i := index(f.data, configProfilesKey); i >= 0 {
+		ms, _ := f.data[i].Value.(yaml.MapSlice)
+		return ms
+	}
+	return nil
+}
+
+// profile returns the profile with name, and whether it exists.
+func (f *configFile) profile(name string) (Profile, bool, error) {
+	profiles := f.profiles()
+	i := index(profiles, name)
+	if i < 0 {
+		return Profile{}, false, nil
+	}
+	// Round-trip through YAML to decode the profile.
+	data, err := yaml.Marshal(profiles[i].Value)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	var prof Profile
+	if err := yaml.Unmarshal(data, &prof); err != nil {
+		return Profile{}, false, fmt.Errorf("parse profile %s: %w", name, err)
+	}
+	return prof, true, nil
+}
+
+// profileNames returns the names of profiles in the order of the file.
+func (f *configFile) profileNames() []string {
+	var names []string
+	for _, item := range f.profiles() {
+		if name, ok := item.Key.(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (f *configFile) setProfile(name string, prof Profile) {
+	f.data = set(f.data, configProfilesKey, set(f.profiles(), name, prof))
+}
+
+// saveToProfile updates the profile with name by f, creating it if needed.
+// A new profile gets the server URL in use.
+func saveToProfile(cmd *cobra.Command, name string, update func(prof *Profile)) error {
+	f, err := readConfigFile()
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	prof, ok, err := f.profile(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		cur, err := loadProfile(cmd)
+		if err != nil {
+			return err
+		}
+		prof.ServerURL = cur.ServerURL
+		if prof.ServerURL == "" {
+			prof.ServerURL = viper.GetString(configClientKey + ".ServerURL")
+		}
+		if prof.ServerURL == "" {
+			prof.ServerURL = os.Getenv(client.EnvServerURL)
+		}
+	}
+	update(&prof)
+	f.setProfile(name, prof)
+	if f.currentProfile() == "" {
+		f.setCurrentProfile(name)
+	}
+	if err := f.write(); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
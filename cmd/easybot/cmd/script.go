@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+func NewBotScriptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "script",
+		Short: "Manage scripts run by the server for bots",
+		Long: `Manage scripts run by the server for bots.
+
+A bot may have a JavaScript script, which the server runs on every user
+message, so that the bot needs no process of its own. The script sees the
+message as message (id, roomID, text, createdAt), and bot and room. It can
+call:
+
+  reply(text, [quickReplies])      send a message into the room
+  state.get(key)                   get a value of the room state
+  state.set(key, value)            set a value of the room state
+  state.delete(key)                delete a value of the room state
+  botState.get/set/delete          the same, for the bot state
+  console.log/info/warn/error      write to the script logs
+  http.get(url)                    make an HTTP request, if the server
+  http.request({method, url,       allows it; returns {status, headers,
+                headers, body})    body}
+
+Scripts run under time and memory limits set by the server. Errors are
+written to the script logs.`,
+	}
+	cmd.AddCommand(
+		NewBotScriptSetCmd(),
+		NewBotScriptGetCmd(),
+		NewBotScriptDeleteCmd(),
+		NewBotScriptLogsCmd(),
+	)
+	return cmd
+}
+
+func NewBotScriptSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set [bot] <script.js>",
+		Short: "Set the script of a bot, read from a file or - for stdin",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			args, err := idArgs(cmd, args, 2, 1)
+			if err != nil {
+				return err
+			}
+			botID, path := args[0], args[1]
+
+			var script []byte
+			if path == "-" {
+				script, err = io.ReadAll(os.Stdin)
+			} else {
+				script, err = os.ReadFile(path)
+			}
+			if err != nil {
+				return fmt.Errorf("read script: %w", err)
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			if err := c.Bot(botID).SetScript(context.TODO(), string(script)); err != nil {
+				return fmt.Errorf("set script: %w", err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func NewBotScriptGetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get [bot]",
+		Short: "Print the script of a bot",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			args, err := idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			script, err := c.Bot(args[0]).Script(context.TODO())
+			if err != nil {
+				return fmt.Errorf("get script: %w", err)
+			}
+			fmt.Print(script)
+			return nil
+		},
+	}
+	return cmd
+}
+
+func NewBotScriptDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete [bot]",
+		Short: "Remove the script of a bot",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			args, err := idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			if err := c.Bot(args[0]).DeleteScript(context.TODO()); err != nil {
+				return fmt.Errorf("delete script: %w", err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+func NewBotScriptLogsCmd() *cobra.Command {
+	var (
+		room     string
+		limit    int
+		follow   bool
+		interval time.Duration
+		noColor  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "logs [bot]",
+		Short: "Print the latest logs of the script of a bot",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			args, err = idArgs(cmd, args, 1, 1)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			bot := c.Bot(args[0])
+
+			opts := client.ScriptLogOptions{Room: room, Limit: limit}
+			logs, err := bot.ScriptLogs(context.TODO(), opts)
+			if err != nil {
+				return fmt.Errorf("get script logs: %w", err)
+			}
+			header := []string{"Created", "Room", "Level", "Text"}
+			row := func(l easybot.ScriptLogResponse) []string {
+				return []string{p.Time(l.CreatedAt), l.RoomID.Hex(), string(l.Level), l.Text}
+			}
+			if !follow {
+				t := table{header: header}
+				for _, l := range logs {
+					t.add(row(l)...)
+				}
+				return p.Print(logs, t)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			color := !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+			print := func(l easybot.ScriptLogResponse) error {
+				if !p.Table() {
+					return p.Item(l, header, row(l))
+				}
+				fmt.Println(formatScriptLogLine(l, room == "", color))
+				return nil
+			}
+
+			// Logs are polled, since there's no event stream of them; the
+			// latest logs are compared by ID to find new ones.
+			var last string
+			for {
+				for _, l := range logs {
+					if last != "" && l.ID.Hex() <= last {
+						continue
+					}
+					if err := print(l); err != nil {
+						return err
+					}
+				}
+				if len(logs) > 0 {
+					last = logs[len(logs)-1].ID.Hex()
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval):
+				}
+				opts.Limit = easybot.MaxScriptLogLimit
+				if logs, err = bot.ScriptLogs(ctx, opts); err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("get script logs: %w", err)
+				}
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&room, "room", "r", "", "Show logs of the room only")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "Number of latest logs to show (default: the server's default)")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing new logs")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Polling interval with --follow")
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "Disable colors with --follow (also disabled by $NO_COLOR or when not printing to a terminal)")
+	return cmd
+}
+
+// formatScriptLogLine formats a script log as a line for following logs in
+// the table format, like:
+//
+//	Feb 21 15:04:05 6213... error TypeError: x is undefined
+//
+// The room ID is omitted when following a single room.
+func formatScriptLogLine(l easybot.ScriptLogResponse, showRoom, color bool) string {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	line := paint(colorDim, l.CreatedAt.In(time.Local).Format(time.Stamp)) + " "
+	if showRoom {
+		line += l.RoomID.Hex() + " "
+	}
+	level := fmt.Sprintf("%-5s", l.Level)
+	switch l.Level {
+	case easybot.ScriptLogError:
+		level = paint(colorRed, level)
+	case easybot.ScriptLogWarn:
+		level = paint(colorYellow, level)
+	default:
+		level = paint(colorDim, level)
+	}
+	return line + level + " " + l.Text
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+// Directions of `easybot tail --direction`.
+const (
+	DirectionIn  = "in"  // user messages, sent to the bot.
+	DirectionOut = "out" // bot messages, sent to users.
+	DirectionAll = "all"
+)
+
+// ANSI escape codes for colors.
+const (
+	colorReset  = "\x1b[0m"
+	colorDim    = "\x1b[2m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// roomColors are the colors rooms are told apart by.
+var roomColors = []string{"\x1b[31m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[91m", "\x1b[93m", "\x1b[94m", "\x1b[95m"}
+
+func NewTailCmd() *cobra.Command {
+	var (
+		direction string
+		since     time.Duration
+		poll      bool
+		consume   bool
+		noColor   bool
+		interval  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "tail [bot] [room]",
+		Short: "Follow messages of a bot or a room live",
+		Long: `Follow messages of a bot or a room live.
+
+Messages of both directions are shown as they're written, from all rooms of the
+bot or from the room. By default messages are only peeked at, so tailing a bot
+doesn't take messages away from it; with --consume, messages sent to you are
+read and marked as read instead.
+
+Messages are pushed by the server's event stream. If the server doesn't
+support it, or with --poll, the history is polled instead.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			var msgType easybot.MessageType
+			switch direction {
+			case DirectionIn:
+				msgType = easybot.UserMessage
+			case DirectionOut:
+				msgType = easybot.BotMessage
+			case DirectionAll:
+			default:
+				return fmt.Errorf("unknown direction %q: must be one of in, out, all", direction)
+			}
+
+			args, err = botRoomArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			botID := args[0]
+			var roomID string
+			if len(args) > 1 {
+				roomID = args[1]
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			color := !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+			print := func(msg easybot.MessageResponse) error {
+				if msgType != "" && msg.Type != msgType {
+					return nil
+				}
+				if !p.Table() {
+					dir := DirectionIn
+					if msg.Type == easybot.BotMessage {
+						dir = DirectionOut
+					}
+					return p.Item(msg, []string{"Room", "Direction", "Created", "Text"},
+						[]string{msg.RoomID.Hex(), dir, p.Time(msg.CreatedAt), msg.Text})
+				}
+				fmt.Println(formatTailLine(msg, roomID == "", color))
+				return nil
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			if consume {
+				return consumeMessages(ctx, c, botID, roomID, interval, print)
+			}
+
+			cfg := client.WatchConfig{PollInterval: interval, Poll: poll}
+			if since > 0 {
+				cfg.Since = time.Now().Add(-since)
+			}
+			if roomID == "" {
+				err = c.Bot(botID).Watch(ctx, print, cfg)
+			} else {
+				err = c.Room(botID, roomID).Watch(ctx, print, cfg)
+			}
+			if err != nil {
+				return fmt.Errorf("watch messages: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&direction, "direction", "d", DirectionAll, "Direction of messages to show: in (to the bot), out (from the bot) or all")
+	cmd.Flags().DurationVar(&since, "since", 0, "Show messages written within the duration first, e.g. 10m")
+	cmd.Flags().BoolVar(&poll, "poll", false, "Poll instead of using the event stream")
+	cmd.Flags().BoolVar(&consume, "consume", false, "Read messages sent to you, marking them as read, instead of peeking")
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "Disable colors (also disabled by $NO_COLOR or when not printing to a terminal)")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "Polling interval")
+	return cmd
+}
+
+// consumeMessages reads messages sent to the access key's owner, like
+// `easybot read`, until ctx is done.
+func consumeMessages(ctx context.Context, c *client.Client, botID, roomID string, interval time.Duration, print func(msg easybot.MessageResponse) error) error {
+	for {
+		var msgs []easybot.MessageResponse
+		var err error
+		if roomID == "" {
+			msgs, err = c.Bot(botID).ReadMessages(ctx, false)
+		} else {
+			msgs, err = c.Room(botID, roomID).ReadMessages(ctx, false)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read messages: %w", err)
+		}
+		for _, msg := range msgs {
+			if err := print(msg); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// formatTailLine formats a message for `easybot tail`, e.g.
+//
+//	Feb 21 15:04:05 6213... in  hello
+//
+// The room ID is omitted when tailing a single room.
+func formatTailLine(msg easybot.MessageResponse, showRoom, color bool) string {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	line := paint(colorDim, msg.CreatedAt.In(time.Local).Format(time.Stamp)) + " "
+	if showRoom {
+		id := msg.RoomID.Hex()
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		line += paint(roomColors[h.Sum32()%uint32(len(roomColors))], id) + " "
+	}
+	if msg.Type == easybot.BotMessage {
+		line += paint(colorCyan, DirectionOut)
+	} else {
+		line += paint(colorGreen, DirectionIn+" ")
+	}
+	return line + " " + msg.Text
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
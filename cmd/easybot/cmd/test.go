@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot/transcript"
+)
+
+func NewTestCmd() *cobra.Command {
+	var junitPath, jsonPath string
+	cmd := &cobra.Command{
+		Use:   "test [bot] [transcript...]",
+		Short: "Test a bot by replaying transcripts",
+		Long: `Test a bot by replaying transcripts.
+
+Each transcript runs in a fresh room of the bot. The bot must be running.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			p, err := newPrinter(cmd)
+			if err != nil {
+				return err
+			}
+
+			botID := args[0]
+			var ts []*transcript.Transcript
+			for _, path := range args[1:] {
+				t, err := transcript.Load(path)
+				if err != nil {
+					return fmt.Errorf("load transcript: %w", err)
+				}
+				ts = append(ts, t)
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			var results []transcript.Result
+			failed := 0
+			for _, t := range ts {
+				res := transcript.Run(context.TODO(), c, botID, t)
+				if !res.Passed {
+					failed++
+				}
+				results = append(results, res)
+			}
+
+			if p.Table() {
+				if err := transcript.WriteSummary(os.Stdout, results); err != nil {
+					return err
+				}
+			} else {
+				t := table{header: []string{"Transcript", "Step", "Status", "Failure"}}
+				for _, res := range results {
+					if res.Error != "" {
+						t.add(res.Name, "", "error", res.Error)
+					}
+					for _, st := range res.Steps {
+						status := "pass"
+						if st.Skipped {
+							status = "skip"
+						} else if !st.Passed {
+							status = "fail"
+						}
+						t.add(res.Name, st.Name(), status, st.Failure)
+					}
+				}
+				if err := p.Print(results, t); err != nil {
+					return err
+				}
+			}
+			if junitPath != "" {
+				if err := writeFile(junitPath, func(w io.Writer) error {
+					return transcript.WriteJUnit(w, results)
+				}); err != nil {
+					return fmt.Errorf("write junit report: %w", err)
+				}
+			}
+			if jsonPath != "" {
+				if err := writeFile(jsonPath, func(w io.Writer) error {
+					return transcript.WriteJSON(w, results)
+				}); err != nil {
+					return fmt.Errorf("write json report: %w", err)
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d transcripts failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&junitPath, "junit", "", "Write a JUnit XML report to the file")
+	cmd.Flags().StringVar(&jsonPath, "json", "", "Write a JSON report to the file")
+	return cmd
+}
+
+// writeFile calls f to write the file at path, or stdout if path is "-".
+// The file is written to a temporary file which replaces it once f succeeds,
+// so that a failure doesn't leave an empty or truncated file.
+func writeFile(path string, f func(w io.Writer) error) error {
+	if path == "-" {
+		return f(os.Stdout)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := f(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+func NewTranscriptCmd() *cobra.Command {
+	var (
+		format string
+		tz     string
+		file   string
+		all    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "transcript [bot] [room]",
+		Short: "Save a room's conversation as Markdown, HTML or text",
+		Long: `Save the full history of a room as a transcript for humans, with
+timestamps and speaker labels, to a file or stdout.
+
+The format is markdown, html or text; it's chosen by the file's extension
+(.md, .html or .txt) unless --format is given. Timestamps are in UTC unless
+--tz is given. With --all, transcripts of every room of the bot are saved in a
+zip archive, which needs the bot's access key.`,
+		Example: `  easybot transcript <bot-id> <room-id> -F chat.html
+  easybot transcript <bot-id> --all --format text --tz Asia/Seoul -F transcripts.zip`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			var err error
+			if all {
+				if len(args) > 1 {
+					return fmt.Errorf("--all takes no room")
+				}
+				args, err = idArgs(cmd, args, 1, 1)
+			} else {
+				args, err = idArgs(cmd, args, 2, 2)
+			}
+			if err != nil {
+				return err
+			}
+
+			f, err := transcriptFormat(format, file)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd)
+			if err != nil {
+				return err
+			}
+			opts := client.TranscriptOptions{Format: f, TZ: tz}
+			return writeFile(file, func(w io.Writer) error {
+				var err error
+				if all {
+					err = c.Bot(args[0]).Transcripts(context.TODO(), w, opts)
+				} else {
+					err = c.Room(args[0], args[1]).Transcript(context.TODO(), w, opts)
+				}
+				if err != nil {
+					return fmt.Errorf("get transcript: %w", err)
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "", "Format: markdown|html|text (default: by the file extension, or markdown)")
+	cmd.Flags().StringVar(&tz, "tz", "", "Time zone of timestamps, e.g. Asia/Seoul (default: UTC)")
+	cmd.Flags().StringVarP(&file, "file", "F", "-", "Write to the file instead of stdout")
+	cmd.Flags().BoolVar(&all, "all", false, "Save transcripts of every room of the bot in a zip archive")
+	return cmd
+}
+
+// transcriptFormat returns the format by the flag, or by the extension of
+// path.
+func transcriptFormat(flag, path string) (easybot.TranscriptFormat, error) {
+	switch f := easybot.TranscriptFormat(flag); f {
+	case easybot.TranscriptMarkdown, easybot.TranscriptHTML, easybot.TranscriptText:
+		return f, nil
+	case "":
+		switch filepath.Ext(path) {
+		case ".html", ".htm":
+			return easybot.TranscriptHTML, nil
+		case ".txt":
+			return easybot.TranscriptText, nil
+		}
+		return easybot.TranscriptMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown format %q: must be one of markdown, html, text", flag)
+}
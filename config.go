@@ -1,6 +1,8 @@
 package easybot
 
 import (
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -9,21 +11,86 @@ var (
 		Fiber: fiber.Config{
 			ErrorHandler: ErrorHandler,
 		},
-		DB: DefaultDBConfig,
+		ImportBodyLimit: 256 << 20,
+		DB:              DefaultDBConfig,
+		Presence:        DefaultPresenceConfig,
+		Scheduler:       DefaultSchedulerConfig,
+		Script:          DefaultScriptConfig,
 	}
 
 	DefaultDBConfig = DBConfig{
 		URI:      "mongodb://localhost",
 		Database: "easybot",
 	}
+
+	DefaultPresenceConfig = PresenceConfig{
+		OfflineAfter: 30 * time.Second,
+	}
+
+	DefaultSchedulerConfig = SchedulerConfig{
+		Interval: time.Second,
+	}
+
+	DefaultScriptConfig = ScriptConfig{
+		Timeout:     time.Second,
+		MaxMemory:   32 << 20,
+		Concurrency: 8,
+		HTTPTimeout: 5 * time.Second,
+	}
 )
 
 type ServerConfig struct {
-	Fiber fiber.Config
-	DB    DBConfig
+	// AdminKey is the access key of the admin API, such as exports and
+	// imports. It must be a UUID; the admin API is disabled without one.
+	AdminKey string
+	// ImportBodyLimit is the maximum size of an import in bytes. Imports are
+	// streamed, so it may be larger than Fiber.BodyLimit, which limits the
+	// bodies of other requests. Defaults to Fiber.BodyLimit when zero.
+	ImportBodyLimit int64
+	Fiber           fiber.Config
+	DB              DBConfig
+	Presence        PresenceConfig
+	Scheduler       SchedulerConfig
+	Script          ScriptConfig
 }
 
 type DBConfig struct {
 	URI      string
 	Database string
 }
+
+type PresenceConfig struct {
+	// OfflineAfter is the duration after the bot's last poll to consider the
+	// bot offline.
+	OfflineAfter time.Duration
+	// OfflineReply, if not empty, is posted into a room as a bot message when
+	// a user writes while the bot has been offline longer than
+	// OfflineReplyAfter. It is posted once in a room until the bot is seen
+	// again.
+	OfflineReply string
+	// OfflineReplyAfter defaults to OfflineAfter when zero.
+	OfflineReplyAfter time.Duration
+}
+
+type SchedulerConfig struct {
+	// Interval is how often the scheduler looks for due scheduled messages.
+	Interval time.Duration
+}
+
+type ScriptConfig struct {
+	// Timeout is the time limit of a script run on a message, including the
+	// time spent in API calls.
+	Timeout time.Duration
+	// MaxMemory is the number of bytes the server's heap may grow by while
+	// scripts run. The heap is shared, so it's not a limit per script: when
+	// it's exceeded, every script running at the time is interrupted. Zero
+	// disables it.
+	MaxMemory uint64
+	// Concurrency is the maximum number of scripts running at the same time.
+	// Further messages wait for a slot.
+	Concurrency int
+	// AllowHTTP enables the http API of scripts.
+	AllowHTTP bool
+	// HTTPTimeout is the time limit of an HTTP request of a script.
+	HTTPTimeout time.Duration
+}
@@ -2,6 +2,7 @@ package easybot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,12 +15,19 @@ import (
 
 // MongoBD collection names.
 const (
-	BotCollectionName     = "bots"
-	RoomCollectionName    = "rooms"
-	MessageCollectionName = "messages"
+	BotCollectionName       = "bots"
+	RoomCollectionName      = "rooms"
+	MessageCollectionName   = "messages"
+	BroadcastCollectionName = "broadcasts"
+	ScheduledCollectionName = "scheduled_messages"
+	StateCollectionName     = "states"
+	ScriptLogCollectionName = "script_logs"
 )
 
-// DB is the database interface.
+// ScriptLogTTL is how long script logs are kept in MongoDB.
+const ScriptLogTTL = 7 * 24 * time.Hour
+
+// DB is a Store backed by MongoDB.
 type DB struct {
 	cfg         DBConfig
 	mongoClient *mongo.Client
@@ -31,10 +39,117 @@ func NewDB(ctx context.Context, cfg DBConfig) (*DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("connect: %w", err)
 	}
-	return &DB{
+	db := &DB{
 		cfg:         cfg,
 		mongoClient: mongoClient,
-	}, nil
+	}
+	if err := db.createIndexes(ctx); err != nil {
+		_ = mongoClient.Disconnect(ctx)
+		return nil, fmt.Errorf("create indexes: %w", err)
+	}
+	if err := db.assignMessageSeqs(ctx); err != nil {
+		_ = mongoClient.Disconnect(ctx)
+		return nil, fmt.Errorf("assign message sequence numbers: %w", err)
+	}
+	return db, nil
+}
+
+// createIndexes creates indexes required for constraints.
+func (db *DB) createIndexes(ctx context.Context) error {
+	// Client IDs are unique among messages of a type in a room, which makes
+	// message writes idempotent without letting users and bots take each
+	// other's IDs.
+	for _, name := range []string{MessageCollectionName, ScheduledCollectionName} {
+		if _, err := db.Database().Collection(name).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: MessageRoomIDKey, Value: 1},
+				{Key: MessageTypeKey, Value: 1},
+				{Key: MessageClientIDKey, Value: 1},
+			},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{MessageClientIDKey: bson.M{"$exists": true}}),
+		}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	// The history is read in the order of sequence numbers, and the last one
+	// is looked up on start.
+	if _, err := db.Database().Collection(MessageCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: MessageRoomIDKey, Value: 1}, {Key: MessageSeqKey, Value: 1}}},
+		{Keys: bson.D{{Key: MessageSeqKey, Value: 1}}},
+	}); err != nil {
+		return fmt.Errorf("%s: %w", MessageCollectionName, err)
+	}
+	if _, err := db.Database().Collection(StateCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: StateScopeKey, Value: 1},
+			{Key: StateOwnerIDKey, Value: 1},
+			{Key: StateKeyKey, Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return fmt.Errorf("%s: %w", StateCollectionName, err)
+	}
+	if _, err := db.Database().Collection(ScriptLogCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: CreatedAtKey, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ScriptLogTTL / time.Second)),
+	}); err != nil {
+		return fmt.Errorf("%s: %w", ScriptLogCollectionName, err)
+	}
+	return nil
+}
+
+// assignMessageSeqs assigns sequence numbers to messages written before
+// messages had them, in the order of their IDs.
+func (db *DB) assignMessageSeqs(ctx context.Context) error {
+	coll := db.Database().Collection(MessageCollectionName)
+	seq, err := db.GetLastMessageSeq(ctx)
+	if err != nil {
+		return err
+	}
+	for {
+		cursor, err := coll.Find(ctx,
+			bson.M{MessageSeqKey: bson.M{"$exists": false}},
+			options.Find().
+				SetSort(bson.M{IDKey: 1}).
+				SetLimit(MaxHistoryLimit).
+				SetProjection(bson.M{IDKey: 1}),
+		)
+		if err != nil {
+			return fmt.Errorf("find: %w", err)
+		}
+		var msgs []Message
+		if err := cursor.All(ctx, &msgs); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+		if len(msgs) == 0 {
+			return nil
+		}
+		writes := make([]mongo.WriteModel, len(msgs))
+		for i, msg := range msgs {
+			seq++
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(bson.M{IDKey: msg.ID}).
+				SetUpdate(bson.M{"$set": bson.M{MessageSeqKey: seq}})
+		}
+		if _, err := coll.BulkWrite(ctx, writes); err != nil {
+			return fmt.Errorf("bulk write: %w", err)
+		}
+	}
+}
+
+var _ Store = (*DB)(nil)
+
+// dbErr translates mongodb errors into Store errors.
+func dbErr(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	}
+	return err
 }
 
 // Close disconnects from the mongodb server.
@@ -69,7 +184,7 @@ func (db *DB) GetBot(ctx context.Context, id primitive.ObjectID) (Bot, error) {
 	coll := db.Database().Collection(BotCollectionName)
 	var bot Bot
 	if err := coll.FindOne(ctx, bson.M{IDKey: id}).Decode(&bot); err != nil {
-		return Bot{}, fmt.Errorf("find: %w", err)
+		return Bot{}, fmt.Errorf("find: %w", dbErr(err))
 	}
 	return bot, nil
 }
@@ -89,12 +204,66 @@ func (db *DB) GetBots(ctx context.Context) ([]Bot, error) {
 	return bots, nil
 }
 
+// TouchBot records that a bot has been seen at t.
+func (db *DB) TouchBot(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	coll := db.Database().Collection(BotCollectionName)
+	if _, err := coll.UpdateOne(ctx, bson.M{IDKey: id}, bson.M{"$set": bson.M{BotLastSeenAtKey: t}}); err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
+
+// SetBotScript sets the script of a bot. An empty script removes it.
+func (db *DB) SetBotScript(ctx context.Context, id primitive.ObjectID, script string) error {
+	coll := db.Database().Collection(BotCollectionName)
+	update := bson.M{"$set": bson.M{BotScriptKey: script}}
+	if script == "" {
+		update = bson.M{"$unset": bson.M{BotScriptKey: ""}}
+	}
+	ret, err := coll.UpdateOne(ctx, bson.M{IDKey: id}, update)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	if ret.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PutBots creates or replaces bots by their IDs.
+func (db *DB) PutBots(ctx context.Context, bots []Bot) error {
+	writes := make([]mongo.WriteModel, len(bots))
+	for i, bot := range bots {
+		writes[i] = replaceByID(bot.ID, bot)
+	}
+	return db.bulkWrite(ctx, BotCollectionName, writes)
+}
+
+// replaceByID returns a write which creates or replaces the document with id.
+func replaceByID(id primitive.ObjectID, doc interface{}) mongo.WriteModel {
+	return mongo.NewReplaceOneModel().
+		SetFilter(bson.M{IDKey: id}).
+		SetReplacement(doc).
+		SetUpsert(true)
+}
+
+func (db *DB) bulkWrite(ctx context.Context, collection string, writes []mongo.WriteModel) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	if _, err := db.Database().Collection(collection).BulkWrite(ctx, writes); err != nil {
+		return fmt.Errorf("bulk write: %w", dbErr(err))
+	}
+	return nil
+}
+
 // CreateRoom creates a new room.
-func (db *DB) CreateRoom(ctx context.Context, botID primitive.ObjectID) (Room, error) {
+func (db *DB) CreateRoom(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) (Room, error) {
 	coll := db.Database().Collection(RoomCollectionName)
 	room := Room{
 		BotID:     botID,
 		AccessKey: uuid.New().String(),
+		Metadata:  metadata,
 		CreatedAt: time.Now(),
 	}
 	ret, err := coll.InsertOne(ctx, room)
@@ -110,7 +279,7 @@ func (db *DB) GetRoom(ctx context.Context, id primitive.ObjectID) (Room, error)
 	coll := db.Database().Collection(RoomCollectionName)
 	var room Room
 	if err := coll.FindOne(ctx, bson.M{IDKey: id}).Decode(&room); err != nil {
-		return Room{}, fmt.Errorf("find: %w", err)
+		return Room{}, fmt.Errorf("find: %w", dbErr(err))
 	}
 	return room, nil
 }
@@ -118,8 +287,18 @@ func (db *DB) GetRoom(ctx context.Context, id primitive.ObjectID) (Room, error)
 // GetRooms returns all rooms.
 // TODO: use pagination
 func (db *DB) GetRooms(ctx context.Context, botID primitive.ObjectID) ([]Room, error) {
+	return db.FindRooms(ctx, botID, nil)
+}
+
+// FindRooms returns all rooms whose metadata contains given metadata.
+// TODO: use pagination
+func (db *DB) FindRooms(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) ([]Room, error) {
 	coll := db.Database().Collection(RoomCollectionName)
-	cursor, err := coll.Find(ctx, bson.M{RoomBotIDKey: botID})
+	filter := bson.M{RoomBotIDKey: botID}
+	for k, v := range metadata {
+		filter[RoomMetadataKey+"."+k] = v
+	}
+	cursor, err := coll.Find(ctx, filter)
 	if err != nil {
 		return nil, fmt.Errorf("find: %w", err)
 	}
@@ -130,6 +309,15 @@ func (db *DB) GetRooms(ctx context.Context, botID primitive.ObjectID) ([]Room, e
 	return rooms, nil
 }
 
+// PutRooms creates or replaces rooms by their IDs.
+func (db *DB) PutRooms(ctx context.Context, rooms []Room) error {
+	writes := make([]mongo.WriteModel, len(rooms))
+	for i, room := range rooms {
+		writes[i] = replaceByID(room.ID, room)
+	}
+	return db.bulkWrite(ctx, RoomCollectionName, writes)
+}
+
 // CreateMessages creates messages.
 func (db *DB) CreateMessages(ctx context.Context, msgs []Message) ([]Message, error) {
 	coll := db.Database().Collection(MessageCollectionName)
@@ -139,7 +327,7 @@ func (db *DB) CreateMessages(ctx context.Context, msgs []Message) ([]Message, er
 	}
 	ret, err := coll.InsertMany(ctx, docs)
 	if err != nil {
-		return nil, fmt.Errorf("insert: %w", err)
+		return nil, fmt.Errorf("insert: %w", dbErr(err))
 	}
 	res := make([]Message, len(msgs))
 	for i, msg := range msgs {
@@ -149,6 +337,25 @@ func (db *DB) CreateMessages(ctx context.Context, msgs []Message) ([]Message, er
 	return res, nil
 }
 
+// GetMessagesByClientIDs returns messages of a type in a room with given
+// client IDs.
+func (db *DB) GetMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		MessageRoomIDKey:   roomID,
+		MessageTypeKey:     msgType,
+		MessageClientIDKey: bson.M{"$in": clientIDs},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []Message
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
 // GetUnreadMessages returns messages with specific type.
 // TODO: use pagination
 func (db *DB) GetUnreadMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error) {
@@ -168,19 +375,449 @@ func (db *DB) GetUnreadMessages(ctx context.Context, roomID primitive.ObjectID,
 	return msgs, nil
 }
 
-// ReadMessages marks given messages as read.
+// GetMessages returns all messages with specific type, oldest first.
+// TODO: use pagination
+func (db *DB) GetMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		MessageRoomIDKey: roomID,
+		MessageTypeKey:   msgType,
+	}, options.Find().SetSort(bson.M{IDKey: 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []Message
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// GetMessagesAfter returns messages of both types in rooms, in the order of
+// their sequence numbers, which are greater than after and not greater than
+// until. At most limit messages are returned.
+func (db *DB) GetMessagesAfter(ctx context.Context, roomIDs []primitive.ObjectID, after, until, limit int64) ([]Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		MessageRoomIDKey: bson.M{"$in": roomIDs},
+		MessageSeqKey:    bson.M{"$gt": after, "$lte": until},
+	}, options.Find().SetSort(bson.M{MessageSeqKey: 1}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []Message
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// GetLastMessages returns the last limit messages of both types in rooms,
+// whose sequence numbers are not greater than until, in the order of their
+// sequence numbers.
+func (db *DB) GetLastMessages(ctx context.Context, roomIDs []primitive.ObjectID, until, limit int64) ([]Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		MessageRoomIDKey: bson.M{"$in": roomIDs},
+		MessageSeqKey:    bson.M{"$lte": until},
+	}, options.Find().SetSort(bson.M{MessageSeqKey: -1}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []Message
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// GetFirstMessageSince returns the first message of both types in rooms, in
+// the order of sequence numbers, which was created at t or later. It returns
+// ErrNotFound if there's no such message.
+func (db *DB) GetFirstMessageSince(ctx context.Context, roomIDs []primitive.ObjectID, t time.Time) (Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	var msg Message
+	if err := coll.FindOne(ctx, bson.M{
+		MessageRoomIDKey: bson.M{"$in": roomIDs},
+		CreatedAtKey:     bson.M{"$gte": t},
+	}, options.FindOne().SetSort(bson.M{MessageSeqKey: 1})).Decode(&msg); err != nil {
+		return Message{}, fmt.Errorf("find: %w", dbErr(err))
+	}
+	return msg, nil
+}
+
+// GetLastMessageSeq returns the greatest sequence number of messages, or 0 if
+// there's no message.
+func (db *DB) GetLastMessageSeq(ctx context.Context) (int64, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	var msg Message
+	if err := coll.FindOne(ctx, bson.M{},
+		options.FindOne().SetSort(bson.M{MessageSeqKey: -1}).SetProjection(bson.M{MessageSeqKey: 1}),
+	).Decode(&msg); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("find: %w", err)
+	}
+	return msg.Seq, nil
+}
+
+// ReadMessages marks given messages as read at readAt.
 // TODO: use pagination
-func (db *DB) ReadMessages(ctx context.Context, msgs []Message) error {
+func (db *DB) ReadMessages(ctx context.Context, msgs []Message, readAt time.Time) error {
 	coll := db.Database().Collection(MessageCollectionName)
 	var writes []mongo.WriteModel
 	for _, msg := range msgs {
 		writes = append(writes,
 			mongo.NewUpdateOneModel().
 				SetFilter(bson.M{IDKey: msg.ID}).
-				SetUpdate(bson.M{"$set": bson.M{MessageReadKey: true}}))
+				SetUpdate(bson.M{"$set": bson.M{
+					MessageReadKey:   true,
+					MessageReadAtKey: readAt,
+				}}))
 	}
 	if _, err := coll.BulkWrite(ctx, writes); err != nil {
 		return fmt.Errorf("bulk write: %w", err)
 	}
 	return nil
 }
+
+// GetMessagesByIDs returns messages with given IDs.
+func (db *DB) GetMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Message, error) {
+	coll := db.Database().Collection(MessageCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{IDKey: bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []Message
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// PutMessages creates or replaces messages by their IDs. It returns
+// ErrDuplicate if a message's client ID is taken by another message.
+func (db *DB) PutMessages(ctx context.Context, msgs []Message) error {
+	writes := make([]mongo.WriteModel, len(msgs))
+	for i, msg := range msgs {
+		writes[i] = replaceByID(msg.ID, msg)
+	}
+	return db.bulkWrite(ctx, MessageCollectionName, writes)
+}
+
+// CreateBroadcast creates a new broadcast.
+func (db *DB) CreateBroadcast(ctx context.Context, b Broadcast) (Broadcast, error) {
+	coll := db.Database().Collection(BroadcastCollectionName)
+	ret, err := coll.InsertOne(ctx, b)
+	if err != nil {
+		return Broadcast{}, fmt.Errorf("insert: %w", err)
+	}
+	b.ID = ret.InsertedID.(primitive.ObjectID)
+	return b, nil
+}
+
+// UpdateBroadcast replaces a broadcast.
+func (db *DB) UpdateBroadcast(ctx context.Context, b Broadcast) error {
+	coll := db.Database().Collection(BroadcastCollectionName)
+	if _, err := coll.ReplaceOne(ctx, bson.M{IDKey: b.ID}, b); err != nil {
+		return fmt.Errorf("replace: %w", err)
+	}
+	return nil
+}
+
+// GetBroadcast returns a broadcast.
+func (db *DB) GetBroadcast(ctx context.Context, id primitive.ObjectID) (Broadcast, error) {
+	coll := db.Database().Collection(BroadcastCollectionName)
+	var b Broadcast
+	if err := coll.FindOne(ctx, bson.M{IDKey: id}).Decode(&b); err != nil {
+		return Broadcast{}, fmt.Errorf("find: %w", dbErr(err))
+	}
+	return b, nil
+}
+
+// GetBroadcasts returns all broadcasts of a bot, newest first.
+// TODO: use pagination
+func (db *DB) GetBroadcasts(ctx context.Context, botID primitive.ObjectID) ([]Broadcast, error) {
+	coll := db.Database().Collection(BroadcastCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{BroadcastBotIDKey: botID}, options.Find().SetSort(bson.M{IDKey: -1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var bs []Broadcast
+	if err := cursor.All(ctx, &bs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return bs, nil
+}
+
+// GetUnfinishedBroadcasts returns pending and running broadcasts of all bots,
+// oldest first.
+func (db *DB) GetUnfinishedBroadcasts(ctx context.Context) ([]Broadcast, error) {
+	coll := db.Database().Collection(BroadcastCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		BroadcastStatusKey: bson.M{"$in": []BroadcastStatus{BroadcastPending, BroadcastRunning}},
+	}, options.Find().SetSort(bson.M{IDKey: 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var bs []Broadcast
+	if err := cursor.All(ctx, &bs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return bs, nil
+}
+
+// CreateScheduledMessages creates scheduled messages.
+func (db *DB) CreateScheduledMessages(ctx context.Context, msgs []ScheduledMessage) ([]ScheduledMessage, error) {
+	coll := db.Database().Collection(ScheduledCollectionName)
+	var docs []interface{}
+	for _, msg := range msgs {
+		docs = append(docs, msg)
+	}
+	ret, err := coll.InsertMany(ctx, docs)
+	if err != nil {
+		return nil, fmt.Errorf("insert: %w", dbErr(err))
+	}
+	res := make([]ScheduledMessage, len(msgs))
+	for i, msg := range msgs {
+		msg.ID = ret.InsertedIDs[i].(primitive.ObjectID)
+		res[i] = msg
+	}
+	return res, nil
+}
+
+// GetScheduledMessages returns pending scheduled messages of a bot, in the
+// order they will be sent. If roomID is not zero, only messages in the room
+// are returned.
+// TODO: use pagination
+func (db *DB) GetScheduledMessages(ctx context.Context, botID, roomID primitive.ObjectID) ([]ScheduledMessage, error) {
+	coll := db.Database().Collection(ScheduledCollectionName)
+	filter := bson.M{ScheduledMessageBotIDKey: botID}
+	if !roomID.IsZero() {
+		filter[ScheduledMessageRoomIDKey] = roomID
+	}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{
+		{Key: ScheduledMessageSendAtKey, Value: 1},
+		{Key: IDKey, Value: 1},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []ScheduledMessage
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// GetScheduledMessagesByClientIDs returns scheduled messages of a type in a
+// room with given client IDs.
+func (db *DB) GetScheduledMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]ScheduledMessage, error) {
+	coll := db.Database().Collection(ScheduledCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		ScheduledMessageRoomIDKey:   roomID,
+		ScheduledMessageTypeKey:     msgType,
+		ScheduledMessageClientIDKey: bson.M{"$in": clientIDs},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []ScheduledMessage
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// DeleteScheduledMessage deletes a scheduled message in a room.
+// It returns ErrNotFound if there's no such message.
+func (db *DB) DeleteScheduledMessage(ctx context.Context, roomID, id primitive.ObjectID) error {
+	coll := db.Database().Collection(ScheduledCollectionName)
+	ret, err := coll.DeleteOne(ctx, bson.M{IDKey: id, ScheduledMessageRoomIDKey: roomID})
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if ret.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetDueScheduledMessages returns at most limit scheduled messages which are
+// due at t, earliest first.
+func (db *DB) GetDueScheduledMessages(ctx context.Context, t time.Time, limit int64) ([]ScheduledMessage, error) {
+	coll := db.Database().Collection(ScheduledCollectionName)
+	cursor, err := coll.Find(ctx,
+		bson.M{ScheduledMessageSendAtKey: bson.M{"$lte": t}},
+		options.Find().SetSort(bson.M{ScheduledMessageSendAtKey: 1}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var msgs []ScheduledMessage
+	if err := cursor.All(ctx, &msgs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return msgs, nil
+}
+
+// GetState returns a state.
+func (db *DB) GetState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string) (State, error) {
+	coll := db.Database().Collection(StateCollectionName)
+	var state State
+	if err := coll.FindOne(ctx, bson.M{
+		StateScopeKey:   scope,
+		StateOwnerIDKey: ownerID,
+		StateKeyKey:     key,
+	}).Decode(&state); err != nil {
+		return State{}, fmt.Errorf("find: %w", dbErr(err))
+	}
+	return state, nil
+}
+
+// GetStates returns all states of a bot or a room, ordered by key.
+// TODO: use pagination
+func (db *DB) GetStates(ctx context.Context, scope StateScope, ownerID primitive.ObjectID) ([]State, error) {
+	coll := db.Database().Collection(StateCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{
+		StateScopeKey:   scope,
+		StateOwnerIDKey: ownerID,
+	}, options.Find().SetSort(bson.M{StateKeyKey: 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var states []State
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return states, nil
+}
+
+// PutState creates or updates a state. If version is not nil, the state is
+// updated only when its current version equals *version, where zero means the
+// state must not exist yet; otherwise ErrVersionMismatch is returned.
+func (db *DB) PutState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key, value string, version *int64) (State, error) {
+	coll := db.Database().Collection(StateCollectionName)
+	now := time.Now()
+	if version != nil && *version == 0 {
+		state := State{
+			Scope:     scope,
+			OwnerID:   ownerID,
+			Key:       key,
+			Value:     value,
+			Version:   1,
+			UpdatedAt: now,
+		}
+		ret, err := coll.InsertOne(ctx, state)
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return State{}, ErrVersionMismatch
+			}
+			return State{}, fmt.Errorf("insert: %w", err)
+		}
+		state.ID = ret.InsertedID.(primitive.ObjectID)
+		return state, nil
+	}
+	filter := bson.M{
+		StateScopeKey:   scope,
+		StateOwnerIDKey: ownerID,
+		StateKeyKey:     key,
+	}
+	if version != nil {
+		filter[StateVersionKey] = *version
+	}
+	update := func() (State, error) {
+		var state State
+		err := coll.FindOneAndUpdate(ctx, filter, bson.M{
+			"$set": bson.M{StateValueKey: value, UpdatedAtKey: now},
+			"$inc": bson.M{StateVersionKey: 1},
+		}, options.FindOneAndUpdate().
+			SetUpsert(version == nil).
+			SetReturnDocument(options.After),
+		).Decode(&state)
+		return state, err
+	}
+	state, err := update()
+	if version == nil && mongo.IsDuplicateKeyError(err) {
+		// Another upsert has created the state concurrently.
+		state, err = update()
+	}
+	if err != nil {
+		if version != nil && errors.Is(err, mongo.ErrNoDocuments) {
+			return State{}, ErrVersionMismatch
+		}
+		return State{}, fmt.Errorf("find and update: %w", dbErr(err))
+	}
+	return state, nil
+}
+
+// DeleteState deletes a state. If version is not nil, the state is deleted
+// only when its current version equals *version; otherwise
+// ErrVersionMismatch is returned. It returns ErrNotFound if there's
+// no such state.
+func (db *DB) DeleteState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string, version *int64) error {
+	coll := db.Database().Collection(StateCollectionName)
+	filter := bson.M{
+		StateScopeKey:   scope,
+		StateOwnerIDKey: ownerID,
+		StateKeyKey:     key,
+	}
+	if version != nil {
+		filter[StateVersionKey] = *version
+	}
+	ret, err := coll.DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if ret.DeletedCount == 0 {
+		if version != nil {
+			if _, err := db.GetState(ctx, scope, ownerID, key); err == nil {
+				return ErrVersionMismatch
+			}
+		}
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateScriptLogs creates script logs.
+func (db *DB) CreateScriptLogs(ctx context.Context, logs []ScriptLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	coll := db.Database().Collection(ScriptLogCollectionName)
+	docs := make([]interface{}, len(logs))
+	for i, l := range logs {
+		docs[i] = l
+	}
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("insert many: %w", err)
+	}
+	return nil
+}
+
+// GetScriptLogs returns the latest limit script logs of a bot, oldest first.
+// If roomID is not zero, only logs of the room are returned.
+func (db *DB) GetScriptLogs(ctx context.Context, botID, roomID primitive.ObjectID, limit int64) ([]ScriptLog, error) {
+	coll := db.Database().Collection(ScriptLogCollectionName)
+	filter := bson.M{ScriptLogBotIDKey: botID}
+	if !roomID.IsZero() {
+		filter[ScriptLogRoomIDKey] = roomID
+	}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.M{IDKey: -1}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	var logs []ScriptLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
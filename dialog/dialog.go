@@ -0,0 +1,305 @@
+// Package dialog provides multi-step conversations for bots built with
+// client.Bot.Run.
+//
+// A Dialog is made of named states. Entering a state sends its prompt, and
+// the user's answer is validated, stored under the state's key and used to
+// choose the next state. The progress of each room is persisted in the room's
+// state store, so a dialog survives bot restarts.
+package dialog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hallazzang/easybot/client"
+)
+
+// DefaultCancelWords are the words which cancel a dialog when CancelWords is
+// nil.
+var DefaultCancelWords = []string{"cancel"}
+
+// Data holds the answers of a dialog, keyed by state keys.
+type Data map[string]string
+
+// State is a step of a dialog.
+type State struct {
+	// Prompt is sent when entering the state. It is executed as a
+	// text/template with the dialog's Data, e.g. "Is {{.name}} correct?".
+	Prompt string
+	// Validate checks the user's answer. If it returns an error, the error
+	// message is sent as a sentence, e.g. errors.New("please enter a number")
+	// is sent as "Please enter a number.", and the state is asked again.
+	Validate func(answer string) error
+	// Key is the key the answer is stored under. Defaults to the state name.
+	Key string
+	// Next is the name of the next state. An empty Next ends the dialog.
+	Next string
+	// NextFunc, if not nil, chooses the next state instead of Next.
+	NextFunc func(answer string, data Data) string
+}
+
+// Dialog is a multi-step conversation. It implements client.Handler: while
+// the dialog is active in a room, messages are answers to the dialog;
+// otherwise a message begins the dialog. Use Wrap to begin dialogs only on
+// demand.
+type Dialog struct {
+	// Name identifies the dialog's progress in the room state store.
+	Name string
+	// Start is the name of the first state.
+	Start  string
+	States map[string]*State
+	// Timeout, if not zero, ends the dialog when the user doesn't answer
+	// within the duration. The timeout is noticed on the next message.
+	Timeout        time.Duration
+	TimeoutMessage string
+	// CancelWords end the dialog when sent as an answer, ignoring case.
+	// Defaults to DefaultCancelWords.
+	CancelWords   []string
+	CancelMessage string
+	// OnComplete is called with the answers when the dialog ends.
+	OnComplete func(ctx *client.Context, data Data) error
+}
+
+// session is the progress of a dialog in a room.
+type session struct {
+	State     string    `json:"state"`
+	Data      Data      `json:"data"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (d *Dialog) stateKey() string {
+	return "dialog:" + d.Name
+}
+
+// Validate checks that all states referred to exist.
+func (d *Dialog) Validate() error {
+	if d.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, ok := d.States[d.Start]; !ok {
+		return fmt.Errorf("start state %q not found", d.Start)
+	}
+	for name, st := range d.States {
+		if st.Next != "" {
+			if _, ok := d.States[st.Next]; !ok {
+				return fmt.Errorf("state %q: next state %q not found", name, st.Next)
+			}
+		}
+		if _, err := template.New(name).Parse(st.Prompt); err != nil {
+			return fmt.Errorf("state %q: parse prompt: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Begin begins the dialog in the room of ctx, replacing its progress if the
+// dialog is already active.
+func (d *Dialog) Begin(ctx *client.Context) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("dialog %s: %w", d.Name, err)
+	}
+	sess := session{State: d.Start, Data: Data{}, UpdatedAt: time.Now()}
+	if _, err := ctx.State().Set(ctx, d.stateKey(), sess); err != nil {
+		return fmt.Errorf("save dialog: %w", err)
+	}
+	return d.prompt(ctx, sess)
+}
+
+// Active reports whether the dialog is active in the room of ctx.
+func (d *Dialog) Active(ctx *client.Context) (bool, error) {
+	var sess session
+	if _, err := ctx.State().Get(ctx, d.stateKey(), &sess); err != nil {
+		if errors.Is(err, client.ErrStateNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("load dialog: %w", err)
+	}
+	return true, nil
+}
+
+// End ends the dialog in the room of ctx without completing it.
+func (d *Dialog) End(ctx *client.Context) error {
+	if err := ctx.State().Delete(ctx, d.stateKey()); err != nil && !errors.Is(err, client.ErrStateNotFound) {
+		return fmt.Errorf("delete dialog: %w", err)
+	}
+	return nil
+}
+
+// HandleMessage implements client.Handler.
+func (d *Dialog) HandleMessage(ctx *client.Context) error {
+	handled, err := d.Handle(ctx)
+	if err != nil || handled {
+		return err
+	}
+	return d.Begin(ctx)
+}
+
+// Handle handles the message of ctx as an answer if the dialog is active in
+// the room, and reports whether it did.
+func (d *Dialog) Handle(ctx *client.Context) (bool, error) {
+	var sess session
+	version, err := ctx.State().Get(ctx, d.stateKey(), &sess)
+	if err != nil {
+		if errors.Is(err, client.ErrStateNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("load dialog: %w", err)
+	}
+	if d.Timeout > 0 && time.Since(sess.UpdatedAt) > d.Timeout {
+		if err := d.End(ctx); err != nil {
+			return false, err
+		}
+		if d.TimeoutMessage != "" {
+			if err := ctx.Reply(d.TimeoutMessage); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	answer := strings.TrimSpace(ctx.Text())
+	if d.isCancel(answer) {
+		if err := d.End(ctx); err != nil {
+			return true, err
+		}
+		if d.CancelMessage != "" {
+			return true, ctx.Reply(d.CancelMessage)
+		}
+		return true, nil
+	}
+	st, ok := d.States[sess.State]
+	if !ok {
+		// The dialog has changed since the progress was saved.
+		return true, d.Begin(ctx)
+	}
+	if st.Validate != nil {
+		if err := st.Validate(answer); err != nil {
+			return true, ctx.Reply(sentence(err.Error()))
+		}
+	}
+	if sess.Data == nil {
+		sess.Data = Data{}
+	}
+	key := st.Key
+	if key == "" {
+		key = sess.State
+	}
+	sess.Data[key] = answer
+	next := st.Next
+	if st.NextFunc != nil {
+		next = st.NextFunc(answer, sess.Data)
+	}
+	if next == "" {
+		if err := ctx.State().DeleteIfVersion(ctx, d.stateKey(), version); err != nil {
+			return true, fmt.Errorf("delete dialog: %w", err)
+		}
+		if d.OnComplete != nil {
+			return true, d.OnComplete(ctx, sess.Data)
+		}
+		return true, nil
+	}
+	if _, ok := d.States[next]; !ok {
+		return true, fmt.Errorf("dialog %s: state %q not found", d.Name, next)
+	}
+	sess.State = next
+	sess.UpdatedAt = time.Now()
+	if _, err := ctx.State().SetIfVersion(ctx, d.stateKey(), sess, version); err != nil {
+		return true, fmt.Errorf("save dialog: %w", err)
+	}
+	return true, d.prompt(ctx, sess)
+}
+
+func (d *Dialog) isCancel(answer string) bool {
+	words := d.CancelWords
+	if words == nil {
+		words = DefaultCancelWords
+	}
+	for _, w := range words {
+		if strings.EqualFold(answer, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dialog) prompt(ctx *client.Context, sess session) error {
+	st := d.States[sess.State]
+	if st.Prompt == "" {
+		return nil
+	}
+	tmpl, err := template.New(sess.State).Option("missingkey=zero").Parse(st.Prompt)
+	if err != nil {
+		return fmt.Errorf("parse prompt: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, sess.Data); err != nil {
+		return fmt.Errorf("execute prompt: %w", err)
+	}
+	return ctx.Reply(b.String())
+}
+
+// sentence capitalizes s and ends it with a period, to reply with an error
+// message.
+func sentence(s string) string {
+	if s == "" {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	s = string(unicode.ToUpper(r)) + s[size:]
+	if !strings.HasSuffix(s, ".") && !strings.HasSuffix(s, "?") && !strings.HasSuffix(s, "!") {
+		s += "."
+	}
+	return s
+}
+
+// Wrap returns a handler which passes messages to the active one of dialogs,
+// or to h if no dialog is active in the room.
+func Wrap(h client.Handler, dialogs ...*Dialog) client.Handler {
+	return client.HandlerFunc(func(ctx *client.Context) error {
+		for _, d := range dialogs {
+			handled, err := d.Handle(ctx)
+			if err != nil || handled {
+				return err
+			}
+		}
+		return h.HandleMessage(ctx)
+	})
+}
+
+// Starter returns a handler which begins d, to be used with a client.Router.
+func (d *Dialog) Starter() client.Handler {
+	return client.HandlerFunc(d.Begin)
+}
+
+// NotEmpty is a validator which requires a non-empty answer.
+func NotEmpty(answer string) error {
+	if answer == "" {
+		return errors.New("please enter something")
+	}
+	return nil
+}
+
+// Integer is a validator which requires an integer answer.
+func Integer(answer string) error {
+	if _, err := strconv.Atoi(answer); err != nil {
+		return errors.New("please enter a number")
+	}
+	return nil
+}
+
+// OneOf returns a validator which requires one of options, ignoring case.
+func OneOf(options ...string) func(answer string) error {
+	return func(answer string) error {
+		for _, opt := range options {
+			if strings.EqualFold(answer, opt) {
+				return nil
+			}
+		}
+		return fmt.Errorf("please answer one of: %s", strings.Join(options, ", "))
+	}
+}
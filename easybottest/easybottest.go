@@ -0,0 +1,244 @@
+// Package easybottest provides an in-process EasyBot server for testing bots
+// without a live server or MongoDB.
+//
+// A typical test runs the bot's handler against a Server and talks to it as a
+// user:
+//
+//	func TestEcho(t *testing.T) {
+//		srv := easybottest.NewServer(t)
+//		bot := srv.CreateBot("echo")
+//		srv.RunBot(bot, client.HandlerFunc(func(ctx *client.Context) error {
+//			return ctx.Reply(ctx.Text())
+//		}))
+//		room := srv.CreateRoom(bot)
+//		room.Say("hello")
+//		room.ExpectReplyText("hello", time.Second)
+//	}
+package easybottest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+	"github.com/hallazzang/easybot/transcript"
+)
+
+// DefaultTimeout is used by Room's Expect methods when the timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// pollInterval is how often rooms and bots poll in tests.
+const pollInterval = 10 * time.Millisecond
+
+// Server is a real easybot.Server backed by an easybot.MemoryStore, listening
+// on a random local port. It is shut down when the test ends.
+type Server struct {
+	*easybot.Server
+	// URL is the base URL of the server, e.g. http://127.0.0.1:12345.
+	URL string
+	// Store is the store of the server, for inspecting or seeding data.
+	Store *easybot.MemoryStore
+
+	t testing.TB
+}
+
+// NewServer starts a server for the test. Configs are merged over
+// easybot.DefaultServerConfig; the DB config is ignored.
+func NewServer(t testing.TB, configs ...easybot.ServerConfig) *Server {
+	t.Helper()
+	cfg := easybot.DefaultServerConfig
+	cfg.Fiber.DisableStartupMessage = true
+	cfg.Scheduler.Interval = pollInterval
+	for _, c := range configs {
+		if c.Presence.OfflineAfter != 0 {
+			cfg.Presence.OfflineAfter = c.Presence.OfflineAfter
+		}
+		if c.Presence.OfflineReply != "" {
+			cfg.Presence.OfflineReply = c.Presence.OfflineReply
+		}
+		if c.Presence.OfflineReplyAfter != 0 {
+			cfg.Presence.OfflineReplyAfter = c.Presence.OfflineReplyAfter
+		}
+		if c.Scheduler.Interval != 0 {
+			cfg.Scheduler.Interval = c.Scheduler.Interval
+		}
+	}
+
+	// Fiber doesn't serve net/http handlers, so listen on a local port the
+	// way httptest.Server does.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("easybottest: listen: %v", err)
+	}
+	store := easybot.NewMemoryStore()
+	server := easybot.NewServer(cfg, store)
+	ctx, cancel := context.WithCancel(context.Background())
+	go server.RunScheduler(ctx)
+	go server.Listener(ln)
+	t.Cleanup(func() {
+		cancel()
+		if err := server.Shutdown(); err != nil {
+			t.Errorf("easybottest: shutdown: %v", err)
+		}
+	})
+	return &Server{
+		Server: server,
+		URL:    "http://" + ln.Addr().String(),
+		Store:  store,
+		t:      t,
+	}
+}
+
+// Client returns a client of the server. Options are applied after the
+// server URL is set. Retries are disabled by default, so that failures show
+// up right away.
+func (s *Server) Client(opts ...client.Option) *client.Client {
+	s.t.Helper()
+	opts = append([]client.Option{
+		client.WithServerURL(s.URL),
+		client.WithRetry(client.RetryPolicy{MaxAttempts: 1}),
+		client.WithCircuitBreaker(client.CircuitBreakerConfig{}),
+		client.WithLogger(testLogger{s.t}),
+	}, opts...)
+	c, err := client.New(opts...)
+	if err != nil {
+		s.t.Fatalf("easybottest: new client: %v", err)
+	}
+	return c
+}
+
+// CreateBot creates a bot with name.
+func (s *Server) CreateBot(name string) *client.Bot {
+	s.t.Helper()
+	bot, err := s.Client().CreateBot(context.Background(), name, "")
+	if err != nil {
+		s.t.Fatalf("easybottest: create bot: %v", err)
+	}
+	return bot
+}
+
+// CreateRoom creates a room of bot, to talk to the bot as a user.
+func (s *Server) CreateRoom(bot *client.Bot) *Room {
+	s.t.Helper()
+	return s.CreateRoomWithMetadata(bot, nil)
+}
+
+// CreateRoomWithMetadata creates a room of bot with metadata.
+func (s *Server) CreateRoomWithMetadata(bot *client.Bot, metadata map[string]string) *Room {
+	s.t.Helper()
+	room, err := s.Client().CreateRoomWithMetadata(context.Background(), bot.ID, metadata)
+	if err != nil {
+		s.t.Fatalf("easybottest: create room: %v", err)
+	}
+	return &Room{
+		Room:    room,
+		t:       s.t,
+		replies: &transcript.Replies{Room: room, PollInterval: pollInterval},
+	}
+}
+
+// RunBot runs h for bot in background until the test ends. Errors from the
+// handler fail the test.
+func (s *Server) RunBot(bot *client.Bot, h client.Handler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = bot.Run(ctx, h, client.RunConfig{
+			PollInterval: pollInterval,
+			OnError: func(err error) {
+				s.t.Errorf("easybottest: bot %s: %v", bot.ID, err)
+			},
+		})
+	}()
+	// Cleanups run in reverse order, so the bot stops before the server.
+	s.t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// Room is a room seen from the user's side.
+type Room struct {
+	*client.Room
+
+	t       testing.TB
+	replies *transcript.Replies
+}
+
+// Say writes messages with texts as the user.
+func (r *Room) Say(texts ...string) {
+	r.t.Helper()
+	msgs := make([]easybot.MessageRequest, len(texts))
+	for i, text := range texts {
+		msgs[i] = easybot.MessageRequest{Text: text}
+	}
+	if err := r.WriteMessages(context.Background(), msgs); err != nil {
+		r.t.Fatalf("easybottest: say: %v", err)
+	}
+}
+
+// NextReply waits for the next message from the bot, up to timeout. It
+// returns false if no message arrived in time.
+func (r *Room) NextReply(timeout time.Duration) (easybot.MessageResponse, bool) {
+	r.t.Helper()
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	msg, ok, err := r.replies.Next(context.Background(), timeout)
+	if err != nil {
+		r.t.Fatalf("easybottest: read messages: %v", err)
+	}
+	return msg, ok
+}
+
+// ExpectReply waits for the next message from the bot and fails the test if
+// none arrives within timeout.
+func (r *Room) ExpectReply(timeout time.Duration) easybot.MessageResponse {
+	r.t.Helper()
+	msg, ok := r.NextReply(timeout)
+	if !ok {
+		r.t.Fatalf("easybottest: no reply within %s", timeoutOrDefault(timeout))
+	}
+	return msg
+}
+
+// ExpectReplyText waits for the next message from the bot and fails the test
+// unless its text is want.
+func (r *Room) ExpectReplyText(want string, timeout time.Duration) easybot.MessageResponse {
+	r.t.Helper()
+	msg := r.ExpectReply(timeout)
+	if msg.Text != want {
+		r.t.Fatalf("easybottest: reply = %q, want %q", msg.Text, want)
+	}
+	return msg
+}
+
+// ExpectNoReply fails the test if the bot sends a message within d.
+func (r *Room) ExpectNoReply(d time.Duration) {
+	r.t.Helper()
+	if msg, ok := r.NextReply(d); ok {
+		r.t.Fatalf("easybottest: unexpected reply %q", msg.Text)
+	}
+}
+
+func timeoutOrDefault(d time.Duration) time.Duration {
+	if d == 0 {
+		return DefaultTimeout
+	}
+	return d
+}
+
+// testLogger logs client messages to the test log.
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Printf(format string, v ...interface{}) {
+	l.t.Helper()
+	l.t.Log(fmt.Sprintf(format, v...))
+}
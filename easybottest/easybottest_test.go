@@ -0,0 +1,358 @@
+package easybottest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+	"github.com/hallazzang/easybot/easybottest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func echo(ctx *client.Context) error {
+	return ctx.Reply(ctx.Text())
+}
+
+func TestEcho(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("echo")
+	srv.RunBot(bot, client.HandlerFunc(echo))
+	room := srv.CreateRoom(bot)
+	room.Say("hello", "world")
+	room.ExpectReplyText("hello", 0)
+	room.ExpectReplyText("world", 0)
+	room.ExpectNoReply(100 * time.Millisecond)
+}
+
+func TestQuickReplies(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("menu")
+	srv.RunBot(bot, client.HandlerFunc(func(ctx *client.Context) error {
+		return ctx.ReplyWithQuickReplies("Pick one", "a", "b")
+	}))
+	room := srv.CreateRoom(bot)
+	room.Say("menu")
+	msg := room.ExpectReplyText("Pick one", 0)
+	if len(msg.QuickReplies) != 2 || msg.QuickReplies[0] != "a" || msg.QuickReplies[1] != "b" {
+		t.Errorf("quick replies = %q, want [a b]", msg.QuickReplies)
+	}
+}
+
+func TestQuickRepliesFromUser(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	room := srv.CreateRoom(srv.CreateBot("bot"))
+	err := room.WriteMessages(context.Background(), []easybot.MessageRequest{{Text: "hi", QuickReplies: []string{"a"}}})
+	if !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("writing quick replies as a user: err = %v, want ErrBadRequest", err)
+	}
+}
+
+func TestAccessKeys(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	room := srv.CreateRoom(bot)
+	other := srv.CreateRoom(srv.CreateBot("other"))
+	ctx := context.Background()
+
+	// A room's access key doesn't open another room.
+	stolen := srv.Client().Room(bot.ID, room.ID)
+	stolen.AccessKey = other.AccessKey
+	if _, err := stolen.ReadMessages(ctx, true); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("reading a room with another room's key: err = %v, want ErrUnauthorized", err)
+	}
+	// A room's access key doesn't act as the bot.
+	fake := srv.Client().Bot(bot.ID)
+	fake.AccessKey = room.AccessKey
+	if _, err := fake.ReadMessages(ctx, true); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("reading a bot's messages with a room key: err = %v, want ErrUnauthorized", err)
+	}
+	if _, err := srv.Client().Room(bot.ID, "000000000000000000000000").ReadMessages(ctx, true); err == nil {
+		t.Error("reading a missing room succeeded")
+	}
+}
+
+func TestClientIDs(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	room := srv.CreateRoom(bot)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := room.WriteMessages(ctx, []easybot.MessageRequest{{Text: "once", ClientID: "c1"}}); err != nil {
+			t.Fatalf("write #%d: %v", i+1, err)
+		}
+	}
+	msgs, err := bot.ReadMessages(ctx, false)
+	if err != nil {
+		t.Fatalf("read messages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != "once" || msgs[0].ClientID != "c1" {
+		t.Errorf("messages = %+v, want one message with client ID c1", msgs)
+	}
+
+	sent, err := room.SentMessages(ctx)
+	if err != nil {
+		t.Fatalf("sent messages: %v", err)
+	}
+	if len(sent) != 1 || sent[0].Status != easybot.MessageRead {
+		t.Errorf("sent messages = %+v, want one read message", sent)
+	}
+
+	// The bot's client IDs are its own, even if the user has taken them.
+	if err := bot.Room(room.ID).WriteMessages(ctx, []easybot.MessageRequest{{Text: "reply", ClientID: "c1"}}); err != nil {
+		t.Fatalf("write as the bot: %v", err)
+	}
+	room.ExpectReplyText("reply", 0)
+
+	err = room.WriteMessages(ctx, []easybot.MessageRequest{{Text: "fake", ClientID: easybot.ServerClientIDPrefix + "offline-x"}})
+	if !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("writing with a server client ID: err = %v, want ErrBadRequest", err)
+	}
+}
+
+func TestPeekAndMarkRead(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	room := srv.CreateRoom(bot)
+	ctx := context.Background()
+	room.Say("a", "b")
+
+	peeked, err := bot.ReadMessages(ctx, true)
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if len(peeked) != 2 {
+		t.Fatalf("peeked %d messages, want 2", len(peeked))
+	}
+	if err := bot.MarkRead(ctx, peeked[0].ID.Hex()); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	msgs, err := bot.ReadMessages(ctx, true)
+	if err != nil {
+		t.Fatalf("peek: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != "b" {
+		t.Errorf("unread messages after marking the first read = %+v, want b", msgs)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("echo")
+	srv.RunBot(bot, client.HandlerFunc(echo))
+	room := srv.CreateRoom(bot)
+	ctx := context.Background()
+	for _, text := range []string{"1", "2", "3"} {
+		room.Say(text)
+		room.ExpectReplyText(text, 0)
+	}
+
+	all, err := room.History(ctx, client.HistoryOptions{})
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(all) != 6 {
+		t.Fatalf("history has %d messages, want 6", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Seq <= all[i-1].Seq {
+			t.Errorf("history is out of order at %d: seq %d after %d", i, all[i].Seq, all[i-1].Seq)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts client.HistoryOptions
+		want []string
+	}{
+		{"last", client.HistoryOptions{Last: 2}, []string{all[4].Text, all[5].Text}},
+		{"limit", client.HistoryOptions{Limit: 2}, []string{all[0].Text, all[1].Text}},
+		{"after", client.HistoryOptions{After: all[3].ID.Hex()}, []string{all[4].Text, all[5].Text}},
+		{"seq", client.HistoryOptions{Seq: all[4].Seq}, []string{all[5].Text}},
+	} {
+		msgs, err := room.History(ctx, tc.opts)
+		if err != nil {
+			t.Errorf("%s: history: %v", tc.name, err)
+			continue
+		}
+		var got []string
+		for _, msg := range msgs {
+			got = append(got, msg.Text)
+		}
+		if len(got) != len(tc.want) || (len(got) > 0 && (got[0] != tc.want[0] || got[len(got)-1] != tc.want[len(tc.want)-1])) {
+			t.Errorf("%s: history = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := room.History(ctx, client.HistoryOptions{After: primitive.NewObjectID().Hex()}); !errors.Is(err, client.ErrBadRequest) {
+		t.Errorf("history after a missing message: err = %v, want ErrBadRequest", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("echo")
+	srv.RunBot(bot, client.HandlerFunc(echo))
+
+	for _, poll := range []bool{false, true} {
+		room := srv.CreateRoom(bot)
+		ctx, cancel := context.WithTimeout(context.Background(), easybottest.DefaultTimeout)
+		got := make(chan easybot.MessageResponse, 10)
+		done := make(chan error, 1)
+		cfg := client.WatchConfig{Since: time.Now(), Poll: poll, PollInterval: 10 * time.Millisecond}
+		go func() {
+			done <- room.Watch(ctx, func(msg easybot.MessageResponse) error {
+				got <- msg
+				return nil
+			}, cfg)
+		}()
+		room.Say("ping")
+		for _, want := range []easybot.MessageType{easybot.UserMessage, easybot.BotMessage} {
+			select {
+			case msg := <-got:
+				if msg.Type != want || msg.Text != "ping" {
+					t.Errorf("poll=%v: watched %s %q, want %s %q", poll, msg.Type, msg.Text, want, "ping")
+				}
+			case <-ctx.Done():
+				t.Errorf("poll=%v: no %s message watched", poll, want)
+			}
+		}
+		cancel()
+		<-done
+	}
+}
+
+func TestState(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	room := srv.CreateRoom(bot)
+	ctx := context.Background()
+	st := bot.Room(room.ID).State()
+
+	var v string
+	if _, err := st.Get(ctx, "name", &v); !errors.Is(err, client.ErrStateNotFound) {
+		t.Errorf("get a missing state: err = %v, want ErrStateNotFound", err)
+	}
+	version, err := st.SetIfVersion(ctx, "name", "alice", 0)
+	if err != nil {
+		t.Fatalf("set at version 0: %v", err)
+	}
+	if _, err := st.SetIfVersion(ctx, "name", "bob", 0); !errors.Is(err, client.ErrVersionConflict) {
+		t.Errorf("set an existing state at version 0: err = %v, want ErrVersionConflict", err)
+	}
+	if version, err = st.SetIfVersion(ctx, "name", "bob", version); err != nil {
+		t.Fatalf("set at version %d: %v", version, err)
+	}
+	got, err := st.Get(ctx, "name", &v)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if v != "bob" || got != version {
+		t.Errorf("get = %q at version %d, want bob at version %d", v, got, version)
+	}
+
+	// Room states are separate from the bot's.
+	if _, err := bot.State().Get(ctx, "name", &v); !errors.Is(err, client.ErrStateNotFound) {
+		t.Errorf("get a room's state from the bot: err = %v, want ErrStateNotFound", err)
+	}
+	// Only the bot can reach the room's state.
+	if _, err := room.State().Get(ctx, "name", &v); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("get the room's state as the user: err = %v, want ErrUnauthorized", err)
+	}
+
+	if err := st.DeleteIfVersion(ctx, "name", version-1); !errors.Is(err, client.ErrVersionConflict) {
+		t.Errorf("delete at a stale version: err = %v, want ErrVersionConflict", err)
+	}
+	if err := st.Delete(ctx, "name"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if err := st.Delete(ctx, "name"); !errors.Is(err, client.ErrStateNotFound) {
+		t.Errorf("delete a missing state: err = %v, want ErrStateNotFound", err)
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	room := srv.CreateRoom(bot)
+	ctx := context.Background()
+	soon := time.Now().Add(200 * time.Millisecond)
+	later := time.Now().Add(time.Hour)
+
+	botRoom := bot.Room(room.ID)
+	if err := botRoom.WriteMessages(ctx, []easybot.MessageRequest{
+		{Text: "soon", SendAt: &soon},
+		{Text: "later", SendAt: &later},
+	}); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if _, err := room.ScheduledMessages(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("list scheduled messages as the user: err = %v, want ErrUnauthorized", err)
+	}
+	scheduled, err := botRoom.ScheduledMessages(ctx)
+	if err != nil {
+		t.Fatalf("scheduled messages: %v", err)
+	}
+	if len(scheduled) != 2 {
+		t.Fatalf("%d scheduled messages, want 2", len(scheduled))
+	}
+	for _, msg := range scheduled {
+		if msg.Text == "later" {
+			if err := botRoom.CancelScheduledMessage(ctx, msg.ID.Hex()); err != nil {
+				t.Errorf("cancel: %v", err)
+			}
+		}
+	}
+	room.ExpectReplyText("soon", 0)
+	room.ExpectNoReply(100 * time.Millisecond)
+	if scheduled, err = bot.ScheduledMessages(ctx); err != nil || len(scheduled) != 0 {
+		t.Errorf("scheduled messages after sending = %+v, %v, want none", scheduled, err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	srv := easybottest.NewServer(t)
+	bot := srv.CreateBot("bot")
+	vip := srv.CreateRoomWithMetadata(bot, map[string]string{"tier": "vip"})
+	regular := srv.CreateRoomWithMetadata(bot, map[string]string{"tier": "regular"})
+	ctx := context.Background()
+
+	br, err := bot.Broadcast(ctx, easybot.BroadcastRequest{
+		Message:  easybot.MessageRequest{Text: "sale"},
+		Metadata: map[string]string{"tier": "vip"},
+	})
+	if err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+	vip.ExpectReplyText("sale", 0)
+	regular.ExpectNoReply(100 * time.Millisecond)
+
+	deadline := time.Now().Add(easybottest.DefaultTimeout)
+	for br.Status != easybot.BroadcastDone && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		if br, err = bot.GetBroadcast(ctx, br.ID.Hex()); err != nil {
+			t.Fatalf("get broadcast: %v", err)
+		}
+	}
+	if br.Status != easybot.BroadcastDone || br.Total != 1 || br.Sent != 1 {
+		t.Errorf("broadcast = %+v, want done with 1 of 1 sent", br)
+	}
+}
+
+func TestOfflineReply(t *testing.T) {
+	srv := easybottest.NewServer(t, easybot.ServerConfig{
+		Presence: easybot.PresenceConfig{
+			OfflineAfter: 50 * time.Millisecond,
+			OfflineReply: "We're away.",
+		},
+	})
+	room := srv.CreateRoom(srv.CreateBot("away"))
+	time.Sleep(100 * time.Millisecond)
+	room.Say("anyone?")
+	room.ExpectReplyText("We're away.", 0)
+	room.Say("hello?")
+	room.ExpectNoReply(100 * time.Millisecond)
+}
@@ -0,0 +1,85 @@
+package easybot
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorCode is a machine-readable error code in error responses.
+type ErrorCode string
+
+// ErrorCode enumerations.
+const (
+	ErrCodeBadRequest         = ErrorCode("bad_request")
+	ErrCodeUnauthorized       = ErrorCode("unauthorized")
+	ErrCodeNotFound           = ErrorCode("not_found")
+	ErrCodeConflict           = ErrorCode("conflict")
+	ErrCodePreconditionFailed = ErrorCode("precondition_failed")
+	ErrCodeTooManyRequests    = ErrorCode("too_many_requests")
+	ErrCodeStateNotFound      = ErrorCode("state_not_found") // a 404 for a missing state key.
+	ErrCodeInternal           = ErrorCode("internal")
+)
+
+// ErrorResponse is the body of an error response.
+type ErrorResponse struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// ErrorCodeFromStatus returns the error code for an HTTP status code.
+func ErrorCodeFromStatus(status int) ErrorCode {
+	switch status {
+	case fiber.StatusBadRequest:
+		return ErrCodeBadRequest
+	case fiber.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case fiber.StatusNotFound:
+		return ErrCodeNotFound
+	case fiber.StatusConflict:
+		return ErrCodeConflict
+	case fiber.StatusPreconditionFailed:
+		return ErrCodePreconditionFailed
+	case fiber.StatusTooManyRequests:
+		return ErrCodeTooManyRequests
+	}
+	if status >= 400 && status < 500 {
+		return ErrCodeBadRequest
+	}
+	return ErrCodeInternal
+}
+
+// Error is an error which is responded with a code more specific than the
+// one of its status, such as ErrCodeStateNotFound.
+type Error struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+}
+
+// NewError returns an Error.
+func NewError(status int, code ErrorCode, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorHandler is an error handler which returns JSON formatted error message.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	if e, ok := err.(*Error); ok {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.Status(e.Status).JSON(ErrorResponse{
+			Code:    e.Code,
+			Message: e.Message,
+		})
+	}
+	code := fiber.StatusInternalServerError
+	if e, ok := err.(*fiber.Error); ok {
+		code = e.Code
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	return c.Status(code).JSON(ErrorResponse{
+		Code:    ErrorCodeFromStatus(code),
+		Message: err.Error(),
+	})
+}
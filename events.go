@@ -0,0 +1,550 @@
+package easybot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultHistoryLimit is the number of messages returned by the history
+	// endpoints when no limit is given.
+	DefaultHistoryLimit = 100
+	// MaxHistoryLimit is the maximum number of messages returned by the
+	// history endpoints.
+	MaxHistoryLimit = 1000
+
+	// eventsHeartbeat is the interval of comments sent to keep event streams
+	// alive.
+	eventsHeartbeat = 15 * time.Second
+	// subscriberBuffer is the number of messages buffered for a subscriber.
+	// A subscriber which falls further behind is disconnected, and catches
+	// up from the history when it reconnects.
+	subscriberBuffer = 64
+)
+
+// hub fans out new messages to event stream subscribers of rooms and bots.
+type hub struct {
+	mu     sync.Mutex
+	subs   map[primitive.ObjectID]map[*subscription]struct{} // by room or bot ID.
+	closed bool
+}
+
+// subscription is a subscriber of a hub.
+type subscription struct {
+	h   *hub
+	key primitive.ObjectID
+	ch  chan Message // closed when the subscriber falls behind or the hub is closed.
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[primitive.ObjectID]map[*subscription]struct{})}
+}
+
+// subscribe subscribes to new messages of a room, or of all rooms of a bot
+// if key is a bot ID.
+func (h *hub) subscribe(key primitive.ObjectID) *subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub := &subscription{h: h, key: key, ch: make(chan Message, subscriberBuffer)}
+	if h.closed {
+		close(sub.ch)
+		return sub
+	}
+	if h.subs[key] == nil {
+		h.subs[key] = make(map[*subscription]struct{})
+	}
+	h.subs[key][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe ends the subscription.
+func (sub *subscription) unsubscribe() {
+	sub.h.mu.Lock()
+	defer sub.h.mu.Unlock()
+	sub.h.drop(sub)
+}
+
+// drained reports whether the subscription is still up and every message
+// published so far has been received.
+func (sub *subscription) drained() bool {
+	sub.h.mu.Lock()
+	defer sub.h.mu.Unlock()
+	_, ok := sub.h.subs[sub.key][sub]
+	return ok && len(sub.ch) == 0
+}
+
+// drop removes sub and closes its channel. h.mu must be held.
+func (h *hub) drop(sub *subscription) {
+	if _, ok := h.subs[sub.key][sub]; !ok {
+		return
+	}
+	delete(h.subs[sub.key], sub)
+	if len(h.subs[sub.key]) == 0 {
+		delete(h.subs, sub.key)
+	}
+	close(sub.ch)
+}
+
+func (h *hub) publish(botID primitive.ObjectID, msgs []Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, msg := range msgs {
+		for _, key := range []primitive.ObjectID{msg.RoomID, botID} {
+			for sub := range h.subs[key] {
+				select {
+				case sub.ch <- msg:
+				default:
+					h.drop(sub)
+				}
+			}
+		}
+	}
+}
+
+// close disconnects all subscribers.
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.drop(sub)
+		}
+	}
+}
+
+// sequencer assigns sequence numbers to messages, which order the history
+// and event streams. Concurrent writes may make messages visible out of
+// order, so the sequencer also tracks the stable sequence number, up to which
+// every message is visible.
+type sequencer struct {
+	db Store
+
+	mu      sync.Mutex
+	loaded  bool
+	next    int64              // the next sequence number to assign.
+	pending map[int64]struct{} // the first numbers of reservations not done.
+}
+
+func newSequencer(db Store) *sequencer {
+	return &sequencer{db: db, pending: make(map[int64]struct{})}
+}
+
+// load reads the last sequence number from the store once. s.mu must be held.
+func (s *sequencer) load(ctx context.Context) error {
+	if s.loaded {
+		return nil
+	}
+	last, err := s.db.GetLastMessageSeq(ctx)
+	if err != nil {
+		return fmt.Errorf("get last message seq: %w", err)
+	}
+	s.next = last + 1
+	s.loaded = true
+	return nil
+}
+
+// reserve reserves n sequence numbers and returns the first one. done must be
+// called with it after the messages are written and published, or failed to
+// be written.
+func (s *sequencer) reserve(ctx context.Context, n int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return 0, err
+	}
+	first := s.next
+	s.next += int64(n)
+	s.pending[first] = struct{}{}
+	return first, nil
+}
+
+func (s *sequencer) done(first int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, first)
+}
+
+// stable returns the sequence number up to which every message has been
+// written and published.
+func (s *sequencer) stable(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(ctx); err != nil {
+		return 0, err
+	}
+	stable := s.next - 1
+	for first := range s.pending {
+		if first <= stable {
+			stable = first - 1
+		}
+	}
+	return stable, nil
+}
+
+// Shutdown disconnects event streams and shuts down the server.
+func (server *Server) Shutdown() error {
+	server.cancel()
+	server.hub.close()
+	return server.App.Shutdown()
+}
+
+// createMessages creates messages in rooms of the bot and publishes them to
+// event streams.
+func (server *Server) createMessages(ctx context.Context, botID primitive.ObjectID, msgs []Message) ([]Message, error) {
+	first, err := server.seq.reserve(ctx, len(msgs))
+	if err != nil {
+		return nil, err
+	}
+	// The sequence numbers become stable only after the messages are
+	// published, so that event streams don't skip them.
+	defer server.seq.done(first)
+	for i := range msgs {
+		msgs[i].Seq = first + int64(i)
+	}
+	msgs, err = server.db.CreateMessages(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+	server.hub.publish(botID, msgs)
+	return msgs, nil
+}
+
+// historyScope is what the history and events endpoints read.
+type historyScope struct {
+	roomIDs []primitive.ObjectID
+	room    primitive.ObjectID // set if a single room is read.
+	seq     int64              // the sequence number to read after.
+	hasSeq  bool               // whether seq is given.
+	limit   int64
+	last    int64 // the number of last messages to read, if not zero.
+}
+
+// historyScope parses the query of the history and events endpoints. The
+// bot may name a room with the room query; otherwise all of its rooms are
+// read. The position to read after is given with the seq query, with the
+// after query as a message ID, or with the since query as a time in RFC 3339.
+func (server *Server) historyScope(c *fiber.Ctx) (historyScope, error) {
+	var query struct {
+		Room  string `query:"room"`
+		After string `query:"after"`
+		Seq   string `query:"seq"`
+		Since string `query:"since"`
+		Limit int64  `query:"limit"`
+		Last  int64  `query:"last"`
+	}
+	var scope historyScope
+	if err := c.QueryParser(&query); err != nil {
+		return scope, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	scope.limit = query.Limit
+	if scope.limit <= 0 {
+		scope.limit = DefaultHistoryLimit
+	} else if scope.limit > MaxHistoryLimit {
+		scope.limit = MaxHistoryLimit
+	}
+	scope.last = query.Last
+	if scope.last > MaxHistoryLimit {
+		scope.last = MaxHistoryLimit
+	}
+	if err := server.scopeRooms(c, &scope, query.Room); err != nil {
+		return scope, err
+	}
+	switch {
+	case query.Seq != "":
+		seq, err := strconv.ParseInt(query.Seq, 10, 64)
+		if err != nil || seq < 0 {
+			return scope, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid seq %q", query.Seq))
+		}
+		scope.seq, scope.hasSeq = seq, true
+	case query.After != "":
+		id, err := primitive.ObjectIDFromHex(query.After)
+		if err != nil {
+			return scope, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid after %q", query.After))
+		}
+		scope.hasSeq = true
+		if !id.IsZero() {
+			msgs, err := server.db.GetMessagesByIDs(context.TODO(), []primitive.ObjectID{id})
+			if err != nil {
+				return scope, fmt.Errorf("get messages: %w", err)
+			}
+			if len(msgs) == 0 {
+				return scope, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid after: message %s not found", id))
+			}
+			scope.seq = msgs[0].Seq
+		}
+	case query.Since != "":
+		t, err := time.Parse(time.RFC3339, query.Since)
+		if err != nil {
+			return scope, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid since %q", query.Since))
+		}
+		scope.hasSeq = true
+		msg, err := server.db.GetFirstMessageSince(context.TODO(), scope.roomIDs, t)
+		switch {
+		case err == nil:
+			scope.seq = msg.Seq - 1
+		case errors.Is(err, ErrNotFound):
+			if scope.seq, err = server.seq.stable(context.TODO()); err != nil {
+				return scope, err
+			}
+		default:
+			return scope, fmt.Errorf("get first message since: %w", err)
+		}
+	}
+	return scope, nil
+}
+
+// scopeRooms sets the rooms of scope: the room of the request, the bot's room
+// named by name, or all rooms of the bot.
+func (server *Server) scopeRooms(c *fiber.Ctx, scope *historyScope, name string) error {
+	if room, ok := c.Locals(RoomLocalsKey).(Room); ok {
+		scope.room = room.ID
+		scope.roomIDs = []primitive.ObjectID{room.ID}
+		return nil
+	}
+	bot := c.Locals(BotLocalsKey).(Bot)
+	if name != "" {
+		id, err := primitive.ObjectIDFromHex(name)
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("room %s not found", name))
+		}
+		room, err := server.db.GetRoom(context.TODO(), id)
+		if err != nil || room.BotID != bot.ID {
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("get room: %w", err)
+			}
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("room %s not found", id))
+		}
+		scope.room = room.ID
+		scope.roomIDs = []primitive.ObjectID{room.ID}
+		return nil
+	}
+	rooms, err := server.db.GetRooms(context.TODO(), bot.ID)
+	if err != nil {
+		return fmt.Errorf("get rooms: %w", err)
+	}
+	scope.roomIDs = make([]primitive.ObjectID, len(rooms))
+	for i, room := range rooms {
+		scope.roomIDs[i] = room.ID
+	}
+	return nil
+}
+
+// requireClient rejects requests to a room from anyone but the bot and the
+// room's user.
+func requireClient(c *fiber.Ctx) error {
+	if clientType, ok := c.Locals(ClientTypeLocalsKey).(ClientType); ok && clientType == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
+	}
+	return nil
+}
+
+// HistoryResponse is the response of the history endpoints.
+type HistoryResponse struct {
+	Messages []MessageResponse `json:"messages"`
+	// Seq is the sequence number to read the next page after.
+	Seq int64 `json:"seq"`
+}
+
+// ListHistory returns messages of both types, in the order of their sequence
+// numbers, without marking them as read. Page with the seq of the response.
+// With the last query, the last messages are returned instead.
+func (server *Server) ListHistory(c *fiber.Ctx) error {
+	if err := requireClient(c); err != nil {
+		return err
+	}
+	scope, err := server.historyScope(c)
+	if err != nil {
+		return err
+	}
+	// Read only messages up to the stable sequence number, so that a message
+	// which becomes visible later is not skipped by the next page.
+	stable, err := server.seq.stable(context.TODO())
+	if err != nil {
+		return err
+	}
+	var msgs []Message
+	next := stable
+	if scope.last > 0 {
+		msgs, err = server.db.GetLastMessages(context.TODO(), scope.roomIDs, stable, scope.last)
+	} else {
+		msgs, err = server.db.GetMessagesAfter(context.TODO(), scope.roomIDs, scope.seq, stable, scope.limit)
+		if int64(len(msgs)) == scope.limit {
+			next = msgs[len(msgs)-1].Seq
+		}
+		if next < scope.seq {
+			next = scope.seq
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("get messages: %w", err)
+	}
+	resp := HistoryResponse{Messages: make([]MessageResponse, len(msgs)), Seq: next}
+	for i, msg := range msgs {
+		resp.Messages[i] = NewMessageResponse(msg)
+	}
+	return c.JSON(resp)
+}
+
+// StreamEvents streams new messages of both types as server-sent events,
+// without marking them as read. Each event is a "message" event with a
+// MessageResponse as data. Event IDs are sequence numbers to resume from
+// with the seq query: every message up to it has been sent, though messages
+// after it may have been sent, too. With the seq or after query, messages
+// after it are sent first, so that a client can reconnect without missing
+// messages. A bot following its events is considered online.
+func (server *Server) StreamEvents(c *fiber.Ctx) error {
+	if err := requireClient(c); err != nil {
+		return err
+	}
+	scope, err := server.historyScope(c)
+	if err != nil {
+		return err
+	}
+	bot := c.Locals(BotLocalsKey).(Bot)
+	key := scope.room
+	if key.IsZero() {
+		key = bot.ID
+	}
+	// A bot following its events is online.
+	isBot := c.Locals(AccessKeyLocalsKey).(string) == bot.AccessKey
+	if isBot {
+		if err := server.touchBot(bot); err != nil {
+			return err
+		}
+	}
+
+	// Subscribe before reading the history, so that no message falls in
+	// between.
+	sub := server.hub.subscribe(key)
+	if !scope.hasSeq {
+		// Start from now.
+		scope.seq, err = server.seq.stable(context.TODO())
+		if err != nil {
+			sub.unsubscribe()
+			return err
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.unsubscribe()
+		ctx := context.TODO()
+		// resume is the event ID. Messages after it which have been sent
+		// are in sent.
+		resume := scope.seq
+		sent := make(map[int64]bool)
+		advance := func(seq int64) {
+			if seq <= resume {
+				return
+			}
+			resume = seq
+			for s := range sent {
+				if s <= resume {
+					delete(sent, s)
+				}
+			}
+		}
+		// send sends msg unless it has been sent. If before is not nil, it
+		// is called before writing the event, to update the position.
+		send := func(msg Message, before func()) error {
+			if msg.Seq <= resume || sent[msg.Seq] {
+				return nil
+			}
+			sent[msg.Seq] = true
+			data, err := json.Marshal(NewMessageResponse(msg))
+			if err != nil {
+				return err
+			}
+			if before != nil {
+				before()
+			}
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", resume, data)
+			return nil
+		}
+
+		// Send the messages after the position, page by page. Messages which
+		// become visible after a page is read are received from the
+		// subscription.
+		for after := scope.seq; ; {
+			stable, err := server.seq.stable(ctx)
+			if err != nil {
+				return
+			}
+			msgs, err := server.db.GetMessagesAfter(ctx, scope.roomIDs, after, math.MaxInt64, MaxHistoryLimit)
+			if err != nil {
+				return
+			}
+			for _, msg := range msgs {
+				if err := send(msg, nil); err != nil {
+					return
+				}
+			}
+			if len(msgs) < MaxHistoryLimit {
+				// Every message up to stable was visible to the read.
+				advance(stable)
+				break
+			}
+			after = msgs[len(msgs)-1].Seq
+			if after < stable {
+				advance(after)
+			} else {
+				advance(stable)
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+
+		// Send the position and a comment right away, so that the client
+		// knows the stream is up.
+		fmt.Fprintf(w, "id: %d\n: connected\n\n", resume)
+		if err := w.Flush(); err != nil {
+			return
+		}
+		// catchUp advances the position to the stable sequence number once
+		// every message published so far has been sent.
+		catchUp := func() {
+			stable, err := server.seq.stable(ctx)
+			if err == nil && sub.drained() {
+				advance(stable)
+			}
+		}
+		ticker := time.NewTicker(eventsHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case msg, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				if err := send(msg, catchUp); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if isBot {
+					if err := server.touchBot(bot); err != nil {
+						return
+					}
+				}
+				catchUp()
+				fmt.Fprintf(w, "id: %d\n: ping\n\n", resume)
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,775 @@
+package easybot
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExportVersion is the version of the export format. Imports of newer
+// versions are rejected.
+const ExportVersion = 1
+
+type ExportFormat string
+
+// ExportFormat enumerations.
+const (
+	// ExportNDJSON is newline delimited JSON: an ExportRecord per line,
+	// starting with the header, followed by bots, rooms and messages.
+	ExportNDJSON = ExportFormat("ndjson")
+	// ExportTar is a tar archive of manifest.json, the header, and
+	// bots.ndjson, rooms.ndjson and messages.ndjson, with a record per line.
+	ExportTar = ExportFormat("tar")
+)
+
+// Content types of export formats.
+const (
+	MIMEApplicationNDJSON = "application/x-ndjson"
+	MIMEApplicationTar    = "application/x-tar"
+)
+
+type ConflictPolicy string
+
+// ConflictPolicy enumerations, of imported bots, rooms and messages whose IDs
+// already exist.
+const (
+	ConflictSkip      = ConflictPolicy("skip")      // keep the existing ones.
+	ConflictOverwrite = ConflictPolicy("overwrite") // replace the existing ones.
+	ConflictFail      = ConflictPolicy("fail")      // import nothing.
+)
+
+// ExportHeader describes an export.
+type ExportHeader struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	AccessKeys bool      `json:"accessKeys"` // whether access keys are included.
+}
+
+type ExportBot struct {
+	ID          primitive.ObjectID `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	AccessKey   string             `json:"accessKey,omitempty"`
+	Script      string             `json:"script,omitempty"`
+	LastSeenAt  *time.Time         `json:"lastSeenAt,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+type ExportRoom struct {
+	ID        primitive.ObjectID `json:"id"`
+	BotID     primitive.ObjectID `json:"botID"`
+	AccessKey string             `json:"accessKey,omitempty"`
+	Metadata  map[string]string  `json:"metadata,omitempty"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+type ExportMessage struct {
+	ID           primitive.ObjectID `json:"id"`
+	RoomID       primitive.ObjectID `json:"roomID"`
+	Type         MessageType        `json:"type"`
+	Text         string             `json:"text"`
+	QuickReplies []string           `json:"quickReplies,omitempty"`
+	ClientID     string             `json:"clientID,omitempty"`
+	Read         bool               `json:"read"`
+	ReadAt       *time.Time         `json:"readAt,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt"`
+}
+
+// ExportRecord is a line of an export in NDJSON. Exactly one of the fields
+// other than Kind is set, as named by Kind.
+type ExportRecord struct {
+	Kind    string         `json:"kind"` // header, bot, room or message.
+	Header  *ExportHeader  `json:"header,omitempty"`
+	Bot     *ExportBot     `json:"bot,omitempty"`
+	Room    *ExportRoom    `json:"room,omitempty"`
+	Message *ExportMessage `json:"message,omitempty"`
+}
+
+// Export is a dump of bots, with their rooms and messages.
+type Export struct {
+	Header   ExportHeader
+	Bots     []ExportBot
+	Rooms    []ExportRoom
+	Messages []ExportMessage
+}
+
+// ImportCount counts imported documents of a kind.
+type ImportCount struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+type ImportResponse struct {
+	Bots     ImportCount `json:"bots"`
+	Rooms    ImportCount `json:"rooms"`
+	Messages ImportCount `json:"messages"`
+	// AccessKeys are the access keys generated for created bots and rooms
+	// which had none in the export, by their IDs.
+	AccessKeys map[string]string `json:"accessKeys,omitempty"`
+}
+
+// AdminMiddleware is a middleware which allows only the admin, by the
+// server's admin key. The admin API is disabled without one.
+func (server *Server) AdminMiddleware(c *fiber.Ctx) error {
+	if server.cfg.AdminKey == "" {
+		return fiber.NewError(fiber.StatusNotFound, "admin api is disabled: set an admin key in the server config")
+	}
+	if c.Locals(AccessKeyLocalsKey).(string) != server.cfg.AdminKey {
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
+	}
+	return c.Next()
+}
+
+// ExportData is a handler for exporting bots with their rooms and messages.
+// The bot query, repeatable, limits the export to some bots. Access keys are
+// included only with the accessKeys query.
+func (server *Server) ExportData(c *fiber.Ctx) error {
+	var query struct {
+		Bots       []string `query:"bot"`
+		AccessKeys bool     `query:"accessKeys"`
+		Format     string   `query:"format"`
+	}
+	if err := c.QueryParser(&query); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	format := ExportFormat(query.Format)
+	switch format {
+	case "":
+		format = ExportNDJSON
+	case ExportNDJSON, ExportTar:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown format %q: must be one of ndjson, tar", query.Format))
+	}
+	var botIDs []primitive.ObjectID
+	for _, s := range query.Bots {
+		for _, s := range strings.Split(s, ",") {
+			id, err := primitive.ObjectIDFromHex(s)
+			if err != nil {
+				return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("bot %s not found", s))
+			}
+			botIDs = append(botIDs, id)
+		}
+	}
+
+	e, err := server.export(context.TODO(), botIDs, query.AccessKeys)
+	if err != nil {
+		return err
+	}
+	name := "easybot-export-" + e.Header.ExportedAt.Format("20060102-150405")
+	write := e.WriteNDJSON
+	if format == ExportTar {
+		write = e.WriteTar
+		name += ".tar"
+		c.Set(fiber.HeaderContentType, MIMEApplicationTar)
+	} else {
+		name += ".ndjson"
+		c.Set(fiber.HeaderContentType, MIMEApplicationNDJSON)
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
+	// Stream the export rather than encoding it in memory first. The status
+	// is sent by then, so an error can only cut the export short.
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		err := write(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Printf("write export: %v", err)
+		}
+	})
+	return nil
+}
+
+// export dumps bots with botIDs, or all bots if botIDs is empty.
+func (server *Server) export(ctx context.Context, botIDs []primitive.ObjectID, accessKeys bool) (Export, error) {
+	e := Export{Header: ExportHeader{
+		Version:    ExportVersion,
+		ExportedAt: time.Now(),
+		AccessKeys: accessKeys,
+	}}
+	var bots []Bot
+	if len(botIDs) == 0 {
+		var err error
+		if bots, err = server.db.GetBots(ctx); err != nil {
+			return Export{}, fmt.Errorf("get bots: %w", err)
+		}
+	}
+	for _, id := range botIDs {
+		bot, err := server.db.GetBot(ctx, id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return Export{}, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("bot %s not found", id))
+			}
+			return Export{}, fmt.Errorf("get bot: %w", err)
+		}
+		bots = append(bots, bot)
+	}
+
+	for _, bot := range bots {
+		eb := ExportBot{
+			ID:          bot.ID,
+			Name:        bot.Name,
+			Description: bot.Description,
+			Script:      bot.Script,
+			CreatedAt:   bot.CreatedAt,
+		}
+		if accessKeys {
+			eb.AccessKey = bot.AccessKey
+		}
+		if !bot.LastSeenAt.IsZero() {
+			t := bot.LastSeenAt
+			eb.LastSeenAt = &t
+		}
+		e.Bots = append(e.Bots, eb)
+
+		rooms, err := server.db.GetRooms(ctx, bot.ID)
+		if err != nil {
+			return Export{}, fmt.Errorf("get rooms: %w", err)
+		}
+		roomIDs := make([]primitive.ObjectID, len(rooms))
+		for i, room := range rooms {
+			roomIDs[i] = room.ID
+			er := ExportRoom{
+				ID:        room.ID,
+				BotID:     room.BotID,
+				Metadata:  room.Metadata,
+				CreatedAt: room.CreatedAt,
+			}
+			if accessKeys {
+				er.AccessKey = room.AccessKey
+			}
+			e.Rooms = append(e.Rooms, er)
+		}
+		if len(roomIDs) == 0 {
+			continue
+		}
+		msgs, err := server.allMessages(ctx, roomIDs)
+		if err != nil {
+			return Export{}, err
+		}
+		for _, msg := range msgs {
+			e.Messages = append(e.Messages, ExportMessage{
+				ID:           msg.ID,
+				RoomID:       msg.RoomID,
+				Type:         msg.Type,
+				Text:         msg.Text,
+				QuickReplies: msg.QuickReplies,
+				ClientID:     msg.ClientID,
+				Read:         msg.Read,
+				ReadAt:       msg.ReadAt,
+				CreatedAt:    msg.CreatedAt,
+			})
+		}
+	}
+	return e, nil
+}
+
+// WriteNDJSON writes e in NDJSON.
+func (e Export) WriteNDJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(ExportRecord{Kind: "header", Header: &e.Header}); err != nil {
+		return err
+	}
+	for i := range e.Bots {
+		if err := enc.Encode(ExportRecord{Kind: "bot", Bot: &e.Bots[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range e.Rooms {
+		if err := enc.Encode(ExportRecord{Kind: "room", Room: &e.Rooms[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range e.Messages {
+		if err := enc.Encode(ExportRecord{Kind: "message", Message: &e.Messages[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTar writes e as a tar archive.
+func (e Export) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	file := func(name string, write func(enc *json.Encoder) error) error {
+		// A header holds the size of its file, so encode the file once to
+		// count its bytes rather than buffering it.
+		var size countWriter
+		if err := write(json.NewEncoder(&size)); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(size),
+			ModTime: e.Header.ExportedAt,
+		}); err != nil {
+			return err
+		}
+		return write(json.NewEncoder(tw))
+	}
+	if err := file("manifest.json", func(enc *json.Encoder) error {
+		return enc.Encode(e.Header)
+	}); err != nil {
+		return err
+	}
+	if err := file("bots.ndjson", func(enc *json.Encoder) error {
+		for _, bot := range e.Bots {
+			if err := enc.Encode(bot); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := file("rooms.ndjson", func(enc *json.Encoder) error {
+		for _, room := range e.Rooms {
+			if err := enc.Encode(room); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := file("messages.ndjson", func(enc *json.Encoder) error {
+		for _, msg := range e.Messages {
+			if err := enc.Encode(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// stickyEOFReader returns io.EOF on every read once its reader has. Reading
+// a chunked request body stream after its end blocks for the next chunk.
+type stickyEOFReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (r *stickyEOFReader) Read(p []byte) (int, error) {
+	if r.eof {
+		return 0, io.EOF
+	}
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// countWriter counts the bytes written to it.
+type countWriter int64
+
+func (n *countWriter) Write(p []byte) (int, error) {
+	*n += countWriter(len(p))
+	return len(p), nil
+}
+
+// ReadExport reads an export in the format.
+func ReadExport(r io.Reader, format ExportFormat) (Export, error) {
+	var e Export
+	var hasHeader bool
+	switch format {
+	case ExportNDJSON:
+		err := readLines(r, func(line []byte) error {
+			var rec ExportRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return err
+			}
+			switch {
+			case rec.Kind == "header" && rec.Header != nil:
+				e.Header = *rec.Header
+				hasHeader = true
+			case rec.Kind == "bot" && rec.Bot != nil:
+				e.Bots = append(e.Bots, *rec.Bot)
+			case rec.Kind == "room" && rec.Room != nil:
+				e.Rooms = append(e.Rooms, *rec.Room)
+			case rec.Kind == "message" && rec.Message != nil:
+				e.Messages = append(e.Messages, *rec.Message)
+			default:
+				return fmt.Errorf("invalid record of kind %q", rec.Kind)
+			}
+			return nil
+		})
+		if err != nil {
+			return Export{}, err
+		}
+	case ExportTar:
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return Export{}, err
+			}
+			switch hdr.Name {
+			case "manifest.json":
+				if err := json.NewDecoder(tr).Decode(&e.Header); err != nil {
+					return Export{}, fmt.Errorf("%s: %w", hdr.Name, err)
+				}
+				hasHeader = true
+			case "bots.ndjson":
+				err = readLines(tr, func(line []byte) error {
+					var bot ExportBot
+					err := json.Unmarshal(line, &bot)
+					e.Bots = append(e.Bots, bot)
+					return err
+				})
+			case "rooms.ndjson":
+				err = readLines(tr, func(line []byte) error {
+					var room ExportRoom
+					err := json.Unmarshal(line, &room)
+					e.Rooms = append(e.Rooms, room)
+					return err
+				})
+			case "messages.ndjson":
+				err = readLines(tr, func(line []byte) error {
+					var msg ExportMessage
+					err := json.Unmarshal(line, &msg)
+					e.Messages = append(e.Messages, msg)
+					return err
+				})
+			}
+			if err != nil {
+				return Export{}, fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+		}
+	default:
+		return Export{}, fmt.Errorf("unknown format %q", format)
+	}
+	if !hasHeader {
+		return Export{}, errors.New("missing header")
+	}
+	if e.Header.Version < 1 || e.Header.Version > ExportVersion {
+		return Export{}, fmt.Errorf("unsupported version %d", e.Header.Version)
+	}
+	return e, nil
+}
+
+// readLines calls fn with each non-empty line of r.
+func readLines(r io.Reader, fn func(line []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, MaxScriptSize+1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+// ImportData is a handler for importing an export, in the format given by
+// the format query or the Content-Type header. IDs and timestamps are
+// preserved; the conflict query decides what happens to existing ones.
+func (server *Server) ImportData(c *fiber.Ctx) error {
+	var query struct {
+		Format   string `query:"format"`
+		Conflict string `query:"conflict"`
+	}
+	if err := c.QueryParser(&query); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	policy := ConflictPolicy(query.Conflict)
+	switch policy {
+	case "":
+		policy = ConflictSkip
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown conflict policy %q: must be one of skip, overwrite, fail", query.Conflict))
+	}
+	format := ExportFormat(query.Format)
+	if format == "" {
+		format = ExportNDJSON
+		if strings.HasPrefix(c.Get(fiber.HeaderContentType), MIMEApplicationTar) {
+			format = ExportTar
+		}
+	}
+	limit := server.cfg.ImportBodyLimit
+	if int64(c.Request().Header.ContentLength()) > limit {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("export is too large: at most %d bytes are allowed", limit))
+	}
+	var body io.Reader = bytes.NewReader(c.Body())
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		body = &stickyEOFReader{r: stream}
+	}
+	lr := &io.LimitedReader{R: body, N: limit + 1}
+	e, err := ReadExport(lr, format)
+	if err == nil {
+		// Read the rest of the body, like the padding of a tar archive, so
+		// that a streamed body isn't left on the connection.
+		_, err = io.Copy(io.Discard, lr)
+	}
+	if lr.N <= 0 {
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("export is too large: at most %d bytes are allowed", limit))
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid export: %v", err))
+	}
+	resp, err := server.importData(context.TODO(), e, policy)
+	if err != nil {
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// importData imports e, handling existing bots, rooms and messages by
+// policy.
+func (server *Server) importData(ctx context.Context, e Export, policy ConflictPolicy) (ImportResponse, error) {
+	resp := ImportResponse{AccessKeys: make(map[string]string)}
+
+	// Find what exists, and check that rooms and messages belong to
+	// imported or existing bots and rooms.
+	existingBots := make(map[primitive.ObjectID]Bot)
+	bots := make(map[primitive.ObjectID]bool)
+	for _, bot := range e.Bots {
+		if bot.ID.IsZero() {
+			return resp, fiber.NewError(fiber.StatusBadRequest, "invalid export: bot without id")
+		}
+		bots[bot.ID] = true
+		existing, err := server.db.GetBot(ctx, bot.ID)
+		if err == nil {
+			existingBots[bot.ID] = existing
+		} else if !errors.Is(err, ErrNotFound) {
+			return resp, fmt.Errorf("get bot: %w", err)
+		}
+	}
+	existingRooms := make(map[primitive.ObjectID]Room)
+	rooms := make(map[primitive.ObjectID]bool)
+	for _, room := range e.Rooms {
+		if room.ID.IsZero() {
+			return resp, fiber.NewError(fiber.StatusBadRequest, "invalid export: room without id")
+		}
+		rooms[room.ID] = true
+		existing, err := server.db.GetRoom(ctx, room.ID)
+		if err == nil {
+			existingRooms[room.ID] = existing
+		} else if !errors.Is(err, ErrNotFound) {
+			return resp, fmt.Errorf("get room: %w", err)
+		}
+		if !bots[room.BotID] {
+			if _, err := server.db.GetBot(ctx, room.BotID); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					return resp, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid export: room %s: bot %s not found", room.ID, room.BotID))
+				}
+				return resp, fmt.Errorf("get bot: %w", err)
+			}
+			bots[room.BotID] = true
+		}
+	}
+	ids := make([]primitive.ObjectID, len(e.Messages))
+	for i, msg := range e.Messages {
+		if msg.ID.IsZero() {
+			return resp, fiber.NewError(fiber.StatusBadRequest, "invalid export: message without id")
+		}
+		if msg.Type != BotMessage && msg.Type != UserMessage {
+			return resp, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid export: message %s: unknown type %q", msg.ID, msg.Type))
+		}
+		ids[i] = msg.ID
+		if !rooms[msg.RoomID] {
+			if _, err := server.db.GetRoom(ctx, msg.RoomID); err != nil {
+				if errors.Is(err, ErrNotFound) {
+					return resp, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid export: message %s: room %s not found", msg.ID, msg.RoomID))
+				}
+				return resp, fmt.Errorf("get room: %w", err)
+			}
+			rooms[msg.RoomID] = true
+		}
+	}
+	existingMessages := make(map[primitive.ObjectID]int64) // sequence numbers by IDs.
+	for start := 0; start < len(ids); start += MaxHistoryLimit {
+		end := start + MaxHistoryLimit
+		if end > len(ids) {
+			end = len(ids)
+		}
+		msgs, err := server.db.GetMessagesByIDs(ctx, ids[start:end])
+		if err != nil {
+			return resp, fmt.Errorf("get messages: %w", err)
+		}
+		for _, msg := range msgs {
+			existingMessages[msg.ID] = msg.Seq
+		}
+	}
+	if policy == ConflictFail && len(existingBots)+len(existingRooms)+len(existingMessages) > 0 {
+		return resp, fiber.NewError(fiber.StatusConflict, fmt.Sprintf(
+			"%d bots, %d rooms and %d messages already exist", len(existingBots), len(existingRooms), len(existingMessages)))
+	}
+	if err := server.checkClientIDs(ctx, e.Messages, existingMessages, policy); err != nil {
+		return resp, err
+	}
+
+	// count counts an import and reports whether to write it.
+	count := func(c *ImportCount, exists bool) bool {
+		switch {
+		case !exists:
+			c.Created++
+		case policy == ConflictOverwrite:
+			c.Overwritten++
+		default:
+			c.Skipped++
+			return false
+		}
+		return true
+	}
+	// accessKey returns the access key to import: the exported one, the
+	// existing one, or a new one.
+	accessKey := func(id primitive.ObjectID, exported, existing string) string {
+		switch {
+		case exported != "":
+			return exported
+		case existing != "":
+			return existing
+		}
+		key := uuid.New().String()
+		resp.AccessKeys[id.Hex()] = key
+		return key
+	}
+
+	var putBots []Bot
+	for _, eb := range e.Bots {
+		existing, exists := existingBots[eb.ID]
+		if !count(&resp.Bots, exists) {
+			continue
+		}
+		bot := Bot{
+			ID:          eb.ID,
+			Name:        eb.Name,
+			Description: eb.Description,
+			AccessKey:   accessKey(eb.ID, eb.AccessKey, existing.AccessKey),
+			Script:      eb.Script,
+			CreatedAt:   eb.CreatedAt,
+		}
+		if eb.LastSeenAt != nil {
+			bot.LastSeenAt = *eb.LastSeenAt
+		}
+		putBots = append(putBots, bot)
+	}
+	if err := server.db.PutBots(ctx, putBots); err != nil {
+		return resp, fmt.Errorf("put bots: %w", err)
+	}
+
+	var putRooms []Room
+	for _, er := range e.Rooms {
+		existing, exists := existingRooms[er.ID]
+		if !count(&resp.Rooms, exists) {
+			continue
+		}
+		putRooms = append(putRooms, Room{
+			ID:        er.ID,
+			BotID:     er.BotID,
+			AccessKey: accessKey(er.ID, er.AccessKey, existing.AccessKey),
+			Metadata:  er.Metadata,
+			CreatedAt: er.CreatedAt,
+		})
+	}
+	if err := server.db.PutRooms(ctx, putRooms); err != nil {
+		return resp, fmt.Errorf("put rooms: %w", err)
+	}
+
+	// New messages get new sequence numbers, in the order of the export;
+	// overwritten ones keep theirs.
+	first, err := server.seq.reserve(ctx, len(e.Messages))
+	if err != nil {
+		return resp, err
+	}
+	defer server.seq.done(first)
+	var putMessages []Message
+	for i, em := range e.Messages {
+		seq, exists := existingMessages[em.ID]
+		if !count(&resp.Messages, exists) {
+			continue
+		}
+		if !exists {
+			seq = first + int64(i)
+		}
+		putMessages = append(putMessages, Message{
+			ID:           em.ID,
+			RoomID:       em.RoomID,
+			Type:         em.Type,
+			Text:         em.Text,
+			QuickReplies: em.QuickReplies,
+			ClientID:     em.ClientID,
+			Seq:          seq,
+			Read:         em.Read,
+			ReadAt:       em.ReadAt,
+			CreatedAt:    em.CreatedAt,
+		})
+	}
+	if err := server.db.PutMessages(ctx, putMessages); err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			return resp, fiber.NewError(fiber.StatusConflict, "a message's client id is taken by another message in its room")
+		}
+		return resp, fmt.Errorf("put messages: %w", err)
+	}
+	return resp, nil
+}
+
+// checkClientIDs checks that the client IDs of messages to import are unique
+// among messages of their types in their rooms, among the messages of the export and the existing ones, so
+// that an import doesn't fail halfway. A client ID held by another existing
+// message is a conflict even if that message is overwritten, since writes
+// aren't ordered to move client IDs between messages.
+func (server *Server) checkClientIDs(ctx context.Context, msgs []ExportMessage, existing map[primitive.ObjectID]int64, policy ConflictPolicy) error {
+	type scope struct {
+		roomID  primitive.ObjectID
+		msgType MessageType
+	}
+	type key struct {
+		scope
+		clientID string
+	}
+	taken := make(map[key]primitive.ObjectID) // IDs of messages to write, by client ID.
+	clientIDs := make(map[scope][]string)
+	for _, msg := range msgs {
+		if _, exists := existing[msg.ID]; exists && policy != ConflictOverwrite {
+			continue
+		}
+		if msg.ClientID == "" {
+			continue
+		}
+		s := scope{msg.RoomID, msg.Type}
+		k := key{s, msg.ClientID}
+		if id, ok := taken[k]; ok && id != msg.ID {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid export: messages %s and %s have the same client id %q", id.Hex(), msg.ID.Hex(), msg.ClientID))
+		}
+		taken[k] = msg.ID
+		clientIDs[s] = append(clientIDs[s], msg.ClientID)
+	}
+	for s, ids := range clientIDs {
+		holders, err := server.db.GetMessagesByClientIDs(ctx, s.roomID, s.msgType, ids)
+		if err != nil {
+			return fmt.Errorf("get messages: %w", err)
+		}
+		for _, holder := range holders {
+			if id := taken[key{s, holder.ClientID}]; id != holder.ID {
+				return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("client id %q of message %s is taken by message %s in room %s", holder.ClientID, id.Hex(), holder.ID.Hex(), s.roomID.Hex()))
+			}
+		}
+	}
+	return nil
+}
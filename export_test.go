@@ -0,0 +1,44 @@
+package easybot
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	bot := ExportBot{ID: primitive.NewObjectID(), Name: "bot", CreatedAt: now}
+	room := ExportRoom{ID: primitive.NewObjectID(), BotID: bot.ID, Metadata: map[string]string{"class": "a"}, CreatedAt: now}
+	e := Export{
+		Header: ExportHeader{Version: ExportVersion, ExportedAt: now},
+		Bots:   []ExportBot{bot},
+		Rooms:  []ExportRoom{room},
+	}
+	for i := 0; i < 3; i++ {
+		e.Messages = append(e.Messages, ExportMessage{ID: primitive.NewObjectID(), RoomID: room.ID, Type: UserMessage, Text: "hi", CreatedAt: now})
+	}
+	for _, tc := range []struct {
+		format ExportFormat
+		write  func(Export, io.Writer) error
+	}{
+		{ExportNDJSON, Export.WriteNDJSON},
+		{ExportTar, Export.WriteTar},
+	} {
+		var buf bytes.Buffer
+		if err := tc.write(e, &buf); err != nil {
+			t.Fatalf("%s: write: %v", tc.format, err)
+		}
+		got, err := ReadExport(&buf, tc.format)
+		if err != nil {
+			t.Fatalf("%s: ReadExport: %v", tc.format, err)
+		}
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("%s: ReadExport = %+v, want %+v", tc.format, got, e)
+		}
+	}
+}
@@ -0,0 +1,658 @@
+package easybot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore is a Store which keeps everything in memory. It is meant for
+// tests and trying out EasyBot without MongoDB.
+type MemoryStore struct {
+	mu         sync.Mutex
+	bots       []Bot
+	rooms      []Room
+	messages   []Message
+	broadcasts []Broadcast
+	scheduled  []ScheduledMessage
+	states     []State
+	scriptLogs []ScriptLog
+}
+
+// maxMemoryScriptLogs is the number of script logs a MemoryStore keeps; older
+// ones are dropped.
+const maxMemoryScriptLogs = 10000
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func (s *MemoryStore) CreateBot(ctx context.Context, name, desc string) (Bot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bot := Bot{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Description: desc,
+		AccessKey:   uuid.New().String(),
+		CreatedAt:   time.Now(),
+	}
+	s.bots = append(s.bots, bot)
+	return bot, nil
+}
+
+func (s *MemoryStore) GetBot(ctx context.Context, id primitive.ObjectID) (Bot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bot := range s.bots {
+		if bot.ID == id {
+			return bot, nil
+		}
+	}
+	return Bot{}, fmt.Errorf("find: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetBots(ctx context.Context) ([]Bot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Bot(nil), s.bots...), nil
+}
+
+func (s *MemoryStore) TouchBot(ctx context.Context, id primitive.ObjectID, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.bots {
+		if s.bots[i].ID == id {
+			s.bots[i].LastSeenAt = t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetBotScript(ctx context.Context, id primitive.ObjectID, script string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.bots {
+		if s.bots[i].ID == id {
+			s.bots[i].Script = script
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) PutBots(ctx context.Context, bots []Bot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+outer:
+	for _, bot := range bots {
+		for i := range s.bots {
+			if s.bots[i].ID == bot.ID {
+				s.bots[i] = bot
+				continue outer
+			}
+		}
+		s.bots = append(s.bots, bot)
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateRoom(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room := Room{
+		ID:        primitive.NewObjectID(),
+		BotID:     botID,
+		AccessKey: uuid.New().String(),
+		Metadata:  copyMetadata(metadata),
+		CreatedAt: time.Now(),
+	}
+	s.rooms = append(s.rooms, room)
+	return room, nil
+}
+
+func (s *MemoryStore) GetRoom(ctx context.Context, id primitive.ObjectID) (Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, room := range s.rooms {
+		if room.ID == id {
+			room.Metadata = copyMetadata(room.Metadata)
+			return room, nil
+		}
+	}
+	return Room{}, fmt.Errorf("find: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetRooms(ctx context.Context, botID primitive.ObjectID) ([]Room, error) {
+	return s.FindRooms(ctx, botID, nil)
+}
+
+func (s *MemoryStore) FindRooms(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) ([]Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rooms []Room
+outer:
+	for _, room := range s.rooms {
+		if room.BotID != botID {
+			continue
+		}
+		for k, v := range metadata {
+			if rv, ok := room.Metadata[k]; !ok || rv != v {
+				continue outer
+			}
+		}
+		room.Metadata = copyMetadata(room.Metadata)
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (s *MemoryStore) PutRooms(ctx context.Context, rooms []Room) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+outer:
+	for _, room := range rooms {
+		room.Metadata = copyMetadata(room.Metadata)
+		for i := range s.rooms {
+			if s.rooms[i].ID == room.ID {
+				s.rooms[i] = room
+				continue outer
+			}
+		}
+		s.rooms = append(s.rooms, room)
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateMessages(ctx context.Context, msgs []Message) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[clientKey]bool)
+	for _, msg := range msgs {
+		if msg.ClientID == "" {
+			continue
+		}
+		key := clientKey{msg.RoomID, msg.Type, msg.ClientID}
+		if seen[key] || s.hasMessageClientID(key) {
+			return nil, fmt.Errorf("insert: %w", ErrDuplicate)
+		}
+		seen[key] = true
+	}
+	res := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		msg.ID = primitive.NewObjectID()
+		s.insertMessage(msg)
+		res[i] = msg
+	}
+	return res, nil
+}
+
+// insertMessage inserts msg keeping messages in the order of their sequence
+// numbers, which reads rely on.
+func (s *MemoryStore) insertMessage(msg Message) {
+	i := sort.Search(len(s.messages), func(i int) bool {
+		return s.messages[i].Seq > msg.Seq
+	})
+	s.messages = append(s.messages, Message{})
+	copy(s.messages[i+1:], s.messages[i:])
+	s.messages[i] = msg
+}
+
+// clientKey is what a client ID is unique in: messages of a type in a room.
+type clientKey struct {
+	roomID   primitive.ObjectID
+	msgType  MessageType
+	clientID string
+}
+
+func (s *MemoryStore) hasMessageClientID(key clientKey) bool {
+	for _, msg := range s.messages {
+		if msg.RoomID == key.roomID && msg.Type == key.msgType && msg.ClientID == key.clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make(map[string]bool)
+	for _, id := range clientIDs {
+		ids[id] = true
+	}
+	var msgs []Message
+	for _, msg := range s.messages {
+		if msg.RoomID == roomID && msg.Type == msgType && msg.ClientID != "" && ids[msg.ClientID] {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetUnreadMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []Message
+	for _, msg := range s.messages {
+		if msg.RoomID == roomID && msg.Type == msgType && !msg.Read {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []Message
+	for _, msg := range s.messages {
+		if msg.RoomID == roomID && msg.Type == msgType {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetMessagesAfter(ctx context.Context, roomIDs []primitive.ObjectID, after, until, limit int64) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make(map[primitive.ObjectID]bool)
+	for _, id := range roomIDs {
+		rooms[id] = true
+	}
+	var msgs []Message
+	for _, msg := range s.messages {
+		if int64(len(msgs)) >= limit || msg.Seq > until {
+			break
+		}
+		if rooms[msg.RoomID] && msg.Seq > after {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetLastMessages(ctx context.Context, roomIDs []primitive.ObjectID, until, limit int64) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make(map[primitive.ObjectID]bool)
+	for _, id := range roomIDs {
+		rooms[id] = true
+	}
+	var msgs []Message
+	for i := len(s.messages) - 1; i >= 0 && int64(len(msgs)) < limit; i-- {
+		if msg := s.messages[i]; rooms[msg.RoomID] && msg.Seq <= until {
+			msgs = append(msgs, msg)
+		}
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetFirstMessageSince(ctx context.Context, roomIDs []primitive.ObjectID, t time.Time) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make(map[primitive.ObjectID]bool)
+	for _, id := range roomIDs {
+		rooms[id] = true
+	}
+	for _, msg := range s.messages {
+		if rooms[msg.RoomID] && !msg.CreatedAt.Before(t) {
+			return msg, nil
+		}
+	}
+	return Message{}, fmt.Errorf("find: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetLastMessageSeq(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return 0, nil
+	}
+	return s.messages[len(s.messages)-1].Seq, nil
+}
+
+func (s *MemoryStore) ReadMessages(ctx context.Context, msgs []Message, readAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make(map[primitive.ObjectID]bool)
+	for _, msg := range msgs {
+		ids[msg.ID] = true
+	}
+	for i := range s.messages {
+		if ids[s.messages[i].ID] {
+			t := readAt
+			s.messages[i].Read = true
+			s.messages[i].ReadAt = &t
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	var msgs []Message
+	for _, msg := range s.messages {
+		if set[msg.ID] {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) PutMessages(ctx context.Context, msgs []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := make(map[primitive.ObjectID]int, len(s.messages))
+	clientIDs := make(map[clientKey]primitive.ObjectID)
+	for i, msg := range s.messages {
+		index[msg.ID] = i
+		if msg.ClientID != "" {
+			clientIDs[clientKey{msg.RoomID, msg.Type, msg.ClientID}] = msg.ID
+		}
+	}
+	for _, msg := range msgs {
+		if msg.ClientID != "" {
+			key := clientKey{msg.RoomID, msg.Type, msg.ClientID}
+			if id, ok := clientIDs[key]; ok && id != msg.ID {
+				return fmt.Errorf("put: %w", ErrDuplicate)
+			}
+			clientIDs[key] = msg.ID
+		}
+		if i, ok := index[msg.ID]; ok {
+			s.messages[i] = msg
+			continue
+		}
+		index[msg.ID] = len(s.messages)
+		s.messages = append(s.messages, msg)
+	}
+	// Keep messages in the order of their sequence numbers, which reads rely
+	// on.
+	sort.SliceStable(s.messages, func(i, j int) bool {
+		return s.messages[i].Seq < s.messages[j].Seq
+	})
+	return nil
+}
+
+func (s *MemoryStore) CreateBroadcast(ctx context.Context, b Broadcast) (Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.ID = primitive.NewObjectID()
+	b.Metadata = copyMetadata(b.Metadata)
+	s.broadcasts = append(s.broadcasts, b)
+	return b, nil
+}
+
+func (s *MemoryStore) UpdateBroadcast(ctx context.Context, b Broadcast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.broadcasts {
+		if s.broadcasts[i].ID == b.ID {
+			b.Metadata = copyMetadata(b.Metadata)
+			s.broadcasts[i] = b
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetBroadcast(ctx context.Context, id primitive.ObjectID) (Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.broadcasts {
+		if b.ID == id {
+			b.Metadata = copyMetadata(b.Metadata)
+			return b, nil
+		}
+	}
+	return Broadcast{}, fmt.Errorf("find: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetBroadcasts(ctx context.Context, botID primitive.ObjectID) ([]Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bs []Broadcast
+	for i := len(s.broadcasts) - 1; i >= 0; i-- {
+		if b := s.broadcasts[i]; b.BotID == botID {
+			b.Metadata = copyMetadata(b.Metadata)
+			bs = append(bs, b)
+		}
+	}
+	return bs, nil
+}
+
+func (s *MemoryStore) GetUnfinishedBroadcasts(ctx context.Context) ([]Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bs []Broadcast
+	for _, b := range s.broadcasts {
+		if b.Status == BroadcastPending || b.Status == BroadcastRunning {
+			b.Metadata = copyMetadata(b.Metadata)
+			bs = append(bs, b)
+		}
+	}
+	return bs, nil
+}
+
+func (s *MemoryStore) CreateScheduledMessages(ctx context.Context, msgs []ScheduledMessage) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[clientKey]bool)
+	for _, msg := range msgs {
+		if msg.ClientID == "" {
+			continue
+		}
+		key := clientKey{msg.RoomID, msg.Type, msg.ClientID}
+		if seen[key] || s.hasScheduledClientID(key) {
+			return nil, fmt.Errorf("insert: %w", ErrDuplicate)
+		}
+		seen[key] = true
+	}
+	res := make([]ScheduledMessage, len(msgs))
+	for i, msg := range msgs {
+		msg.ID = primitive.NewObjectID()
+		s.scheduled = append(s.scheduled, msg)
+		res[i] = msg
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) hasScheduledClientID(key clientKey) bool {
+	for _, msg := range s.scheduled {
+		if msg.RoomID == key.roomID && msg.Type == key.msgType && msg.ClientID == key.clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedScheduled returns scheduled messages in the order they will be sent.
+func (s *MemoryStore) sortedScheduled() []ScheduledMessage {
+	msgs := append([]ScheduledMessage(nil), s.scheduled...)
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].SendAt.Before(msgs[j].SendAt)
+	})
+	return msgs
+}
+
+func (s *MemoryStore) GetScheduledMessages(ctx context.Context, botID, roomID primitive.ObjectID) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []ScheduledMessage
+	for _, msg := range s.sortedScheduled() {
+		if msg.BotID == botID && (roomID.IsZero() || msg.RoomID == roomID) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) GetScheduledMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make(map[string]bool)
+	for _, id := range clientIDs {
+		ids[id] = true
+	}
+	var msgs []ScheduledMessage
+	for _, msg := range s.scheduled {
+		if msg.RoomID == roomID && msg.Type == msgType && msg.ClientID != "" && ids[msg.ClientID] {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) DeleteScheduledMessage(ctx context.Context, roomID, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, msg := range s.scheduled {
+		if msg.ID == id && msg.RoomID == roomID {
+			s.scheduled = append(s.scheduled[:i], s.scheduled[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryStore) GetDueScheduledMessages(ctx context.Context, t time.Time, limit int64) ([]ScheduledMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []ScheduledMessage
+	for _, msg := range s.sortedScheduled() {
+		if msg.SendAt.After(t) || int64(len(msgs)) >= limit {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (s *MemoryStore) stateIndex(scope StateScope, ownerID primitive.ObjectID, key string) int {
+	for i, state := range s.states {
+		if state.Scope == scope && state.OwnerID == ownerID && state.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *MemoryStore) GetState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.stateIndex(scope, ownerID, key); i >= 0 {
+		return s.states[i], nil
+	}
+	return State{}, fmt.Errorf("find: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetStates(ctx context.Context, scope StateScope, ownerID primitive.ObjectID) ([]State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var states []State
+	for _, state := range s.states {
+		if state.Scope == scope && state.OwnerID == ownerID {
+			states = append(states, state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Key < states[j].Key
+	})
+	return states, nil
+}
+
+func (s *MemoryStore) PutState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key, value string, version *int64) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.stateIndex(scope, ownerID, key)
+	var cur int64
+	if i >= 0 {
+		cur = s.states[i].Version
+	}
+	if version != nil && *version != cur {
+		return State{}, ErrVersionMismatch
+	}
+	if i < 0 {
+		s.states = append(s.states, State{
+			ID:      primitive.NewObjectID(),
+			Scope:   scope,
+			OwnerID: ownerID,
+			Key:     key,
+		})
+		i = len(s.states) - 1
+	}
+	s.states[i].Value = value
+	s.states[i].Version++
+	s.states[i].UpdatedAt = time.Now()
+	return s.states[i], nil
+}
+
+func (s *MemoryStore) DeleteState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string, version *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.stateIndex(scope, ownerID, key)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if version != nil && *version != s.states[i].Version {
+		return ErrVersionMismatch
+	}
+	s.states = append(s.states[:i], s.states[i+1:]...)
+	return nil
+}
+
+func (s *MemoryStore) CreateScriptLogs(ctx context.Context, logs []ScriptLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range logs {
+		l.ID = primitive.NewObjectID()
+		s.scriptLogs = append(s.scriptLogs, l)
+	}
+	if n := len(s.scriptLogs) - maxMemoryScriptLogs; n > 0 {
+		s.scriptLogs = append([]ScriptLog(nil), s.scriptLogs[n:]...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetScriptLogs(ctx context.Context, botID, roomID primitive.ObjectID, limit int64) ([]ScriptLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var logs []ScriptLog
+	for i := len(s.scriptLogs) - 1; i >= 0 && int64(len(logs)) < limit; i-- {
+		l := s.scriptLogs[i]
+		if l.BotID == botID && (roomID.IsZero() || l.RoomID == roomID) {
+			logs = append(logs, l)
+		}
+	}
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
@@ -17,6 +17,8 @@ const (
 	BotNameKey        = "name"
 	BotDescriptionKey = "description"
 	BotAccessKeyKey   = "accessKey"
+	BotLastSeenAtKey  = "lastSeenAt"
+	BotScriptKey      = "script"
 )
 
 // Bot is the model for a bot.
@@ -24,7 +26,9 @@ type Bot struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
 	Name        string             `bson:"name"`
 	Description string             `bson:"description"`
-	AccessKey   string             `bson:"accessKey"` // access key of a bot.
+	AccessKey   string             `bson:"accessKey"`            // access key of a bot.
+	LastSeenAt  time.Time          `bson:"lastSeenAt,omitempty"` // last time the bot polled or connected.
+	Script      string             `bson:"script,omitempty"`     // JavaScript run by the server on user messages.
 	CreatedAt   time.Time          `bson:"createdAt"`
 }
 
@@ -32,6 +36,7 @@ type Bot struct {
 const (
 	RoomBotIDKey     = "botID"
 	RoomAccessKeyKey = "accessKey"
+	RoomMetadataKey  = "metadata"
 )
 
 // Room is the model for a room.
@@ -39,6 +44,7 @@ type Room struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	BotID     primitive.ObjectID `bson:"botID"`
 	AccessKey string             `bson:"accessKey"` // access key of a user.
+	Metadata  map[string]string  `bson:"metadata,omitempty"`
 	CreatedAt time.Time          `bson:"createdAt"`
 }
 
@@ -52,18 +58,134 @@ const (
 
 // Message key names.
 const (
-	MessageRoomIDKey = "roomID"
-	MessageTypeKey   = "type"
-	MessageTextKey   = "text"
-	MessageReadKey   = "read"
+	MessageRoomIDKey   = "roomID"
+	MessageTypeKey     = "type"
+	MessageTextKey     = "text"
+	MessageReadKey     = "read"
+	MessageReadAtKey   = "readAt"
+	MessageClientIDKey = "clientID"
+	MessageSeqKey      = "seq"
 )
 
 // Message is the model for a message.
 type Message struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	RoomID       primitive.ObjectID `bson:"roomID"`
+	Type         MessageType        `bson:"type"`
+	Text         string             `bson:"text"`
+	QuickReplies []string           `bson:"quickReplies,omitempty"` // suggested answers, shown as buttons by user interfaces.
+	ClientID     string             `bson:"clientID,omitempty"`     // client-supplied ID, unique among messages of the type in a room.
+	Seq          int64              `bson:"seq"`                    // sequence number assigned by the server, which orders the history.
+	Read         bool               `bson:"read"`
+	ReadAt       *time.Time         `bson:"readAt,omitempty"` // time when the other side read the message.
+	CreatedAt    time.Time          `bson:"createdAt"`
+}
+
+type BroadcastStatus string
+
+// BroadcastStatus enumerations.
+const (
+	BroadcastPending = BroadcastStatus("pending")
+	BroadcastRunning = BroadcastStatus("running")
+	BroadcastDone    = BroadcastStatus("done")
+	BroadcastFailed  = BroadcastStatus("failed")
+)
+
+// Broadcast key names.
+const (
+	BroadcastBotIDKey  = "botID"
+	BroadcastStatusKey = "status"
+)
+
+// Broadcast is the model for a broadcast, which fans a bot message out to
+// rooms of the bot.
+type Broadcast struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	BotID      primitive.ObjectID `bson:"botID"`
+	Text       string             `bson:"text"`
+	Metadata   map[string]string  `bson:"metadata,omitempty"` // only rooms with matching metadata are targeted.
+	Status     BroadcastStatus    `bson:"status"`
+	Total      int                `bson:"total"` // number of targeted rooms.
+	Sent       int                `bson:"sent"`  // number of rooms the message was sent to.
+	Error      string             `bson:"error,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	FinishedAt *time.Time         `bson:"finishedAt,omitempty"`
+}
+
+// ScheduledMessage key names.
+const (
+	ScheduledMessageBotIDKey    = "botID"
+	ScheduledMessageRoomIDKey   = "roomID"
+	ScheduledMessageTypeKey     = "type"
+	ScheduledMessageSendAtKey   = "sendAt"
+	ScheduledMessageClientIDKey = "clientID"
+)
+
+// ScheduledMessage is the model for a message to be sent later.
+type ScheduledMessage struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	BotID        primitive.ObjectID `bson:"botID"`
+	RoomID       primitive.ObjectID `bson:"roomID"`
+	Type         MessageType        `bson:"type"`
+	Text         string             `bson:"text"`
+	QuickReplies []string           `bson:"quickReplies,omitempty"`
+	ClientID     string             `bson:"clientID,omitempty"` // client-supplied ID, unique among messages of the type in a room.
+	SendAt       time.Time          `bson:"sendAt"`
+	CreatedAt    time.Time          `bson:"createdAt"`
+}
+
+type StateScope string
+
+// StateScope enumerations.
+const (
+	BotState  = StateScope("bot")
+	RoomState = StateScope("room")
+)
+
+// State key names.
+const (
+	StateScopeKey   = "scope"
+	StateOwnerIDKey = "ownerID"
+	StateKeyKey     = "key"
+	StateValueKey   = "value"
+	StateVersionKey = "version"
+	UpdatedAtKey    = "updatedAt"
+)
+
+// State is the model for a key/value pair stored for a bot or a room.
+type State struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	RoomID    primitive.ObjectID `bson:"roomID"`
-	Type      MessageType        `bson:"type"`
+	Scope     StateScope         `bson:"scope"`
+	OwnerID   primitive.ObjectID `bson:"ownerID"` // ID of the bot or the room.
+	Key       string             `bson:"key"`
+	Value     string             `bson:"value"`   // JSON encoded value.
+	Version   int64              `bson:"version"` // incremented on every update, starting from 1.
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+type ScriptLogLevel string
+
+// ScriptLogLevel enumerations.
+const (
+	ScriptLogInfo  = ScriptLogLevel("info")
+	ScriptLogWarn  = ScriptLogLevel("warn")
+	ScriptLogError = ScriptLogLevel("error")
+)
+
+// ScriptLog key names.
+const (
+	ScriptLogBotIDKey  = "botID"
+	ScriptLogRoomIDKey = "roomID"
+)
+
+// ScriptLog is the model for a line logged by a bot's script, or an error of
+// a script run.
+type ScriptLog struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	BotID     primitive.ObjectID `bson:"botID"`
+	RoomID    primitive.ObjectID `bson:"roomID"`    // room of the message the script ran on.
+	MessageID primitive.ObjectID `bson:"messageID"` // message the script ran on.
+	Level     ScriptLogLevel     `bson:"level"`
 	Text      string             `bson:"text"`
-	Read      bool               `bson:"read"`
 	CreatedAt time.Time          `bson:"createdAt"`
 }
@@ -0,0 +1,164 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/hallazzang/easybot/client"
+)
+
+// reloadDelay is how long WatchFile waits for a file to settle after a
+// change, since editors often write a file in several steps.
+const reloadDelay = 100 * time.Millisecond
+
+// Engine is a client.Handler which answers messages by rules. Its rules can
+// be replaced while it's running.
+type Engine struct {
+	mu    sync.RWMutex
+	rules *Rules
+}
+
+// NewEngine returns a new Engine with r.
+func NewEngine(r *Rules) *Engine {
+	return &Engine{rules: r}
+}
+
+// Rules returns the rules in use.
+func (e *Engine) Rules() *Rules {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules
+}
+
+// SetRules replaces the rules in use.
+func (e *Engine) SetRules(r *Rules) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = r
+}
+
+// HandleMessage implements client.Handler.
+func (e *Engine) HandleMessage(ctx *client.Context) error {
+	r := e.Rules()
+	rule, match := r.Match(ctx.Text())
+	if match == nil {
+		match = make(map[string]string)
+	}
+	state, err := roomState(ctx)
+	if err != nil {
+		return err
+	}
+	data := Data{
+		Text:  ctx.Text(),
+		Match: match,
+		State: state,
+		Bot:   ctx.Bot.ID,
+		Room:  ctx.Room.ID,
+	}
+
+	if rule == nil {
+		text, err := pick(r.fallback, data)
+		if err != nil {
+			return fmt.Errorf("fallback: %w", err)
+		}
+		if text == "" {
+			return nil
+		}
+		return ctx.Reply(text)
+	}
+
+	// Set values first, so that the answer can use them.
+	for key, t := range rule.set {
+		v, err := execute(t, data)
+		if err != nil {
+			return fmt.Errorf("rule %s: set %s: %w", rule.Name, key, err)
+		}
+		if _, err := ctx.State().Set(ctx, key, v); err != nil {
+			return fmt.Errorf("set state %s: %w", key, err)
+		}
+		data.State[key] = v
+	}
+	text, err := pick(rule.answers, data)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", rule.Name, err)
+	}
+	if text == "" {
+		return nil
+	}
+	return ctx.ReplyWithQuickReplies(text, rule.QuickReplies...)
+}
+
+// roomState returns the state of the room the message was received in, for
+// templates.
+func roomState(ctx *client.Context) (map[string]string, error) {
+	states, err := ctx.State().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list state: %w", err)
+	}
+	m := make(map[string]string, len(states))
+	for _, st := range states {
+		var s string
+		if err := json.Unmarshal(st.Value, &s); err == nil {
+			m[st.Key] = s
+		} else {
+			m[st.Key] = string(st.Value)
+		}
+	}
+	return m, nil
+}
+
+// WatchFile reloads the rules from the file at path whenever it changes,
+// until ctx is done. onReload, if not nil, is called after each reload with
+// its error; if the file is invalid, the rules in use are kept.
+func (e *Engine) WatchFile(ctx context.Context, path string, onReload func(err error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	// Watch the directory, since editors may replace the file rather than
+	// write to it.
+	path = filepath.Clean(path)
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	reload := func() {
+		r, err := Load(path)
+		if err == nil {
+			e.SetRules(r)
+		}
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(ev.Name) == path && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-timer.C:
+			reload()
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package rules is a declarative bot engine for FAQ-style bots, driven by a
+// YAML file of rules:
+//
+//	intents:
+//	  greeting: [hello, hi there, good morning]
+//	rules:
+//	  - intent: greeting
+//	    answers:
+//	      - Hello, {{default "stranger" .State.name}}!
+//	      - Hi!
+//	  - keywords: [hours, open]
+//	    answer: We're open from 9 to 5.
+//	    quick_replies: ["Where are you?"]
+//	  - regex: "(?i)^my name is (?P<name>\\w+)"
+//	    answer: Nice to meet you, {{.Match.name}}!
+//	    set:
+//	      name: "{{.Match.name}}"
+//	fallback:
+//	  - Sorry, I don't understand.
+//
+// Rules are tried in order and the first matching rule answers. A rule
+// matches when any of its keywords, its regular expression or its intent
+// matches. Answers are text/templates executed with Data, so they can use
+// variables from the room state, and set stores values into it. If no rule
+// matches, one of the fallback answers is sent.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultIntentThreshold is the minimum score of an intent to match when the
+// rules don't set one.
+const DefaultIntentThreshold = 0.6
+
+// Rules is a set of rules loaded from a file.
+type Rules struct {
+	// Intents are example phrases by intent name. A message matches an
+	// intent when it contains enough of the words of one of its examples.
+	Intents map[string][]string `yaml:"intents"`
+	// IntentThreshold is the fraction of an example's words a message must
+	// contain to match the intent. Defaults to DefaultIntentThreshold.
+	IntentThreshold float64  `yaml:"intent_threshold"`
+	Rules           []Rule   `yaml:"rules"`
+	Fallback        []string `yaml:"fallback"`
+
+	intents  map[string][][]string // words of examples.
+	fallback []*template.Template
+}
+
+// Rule answers messages matching any of its keywords, its regular
+// expression or its intent.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Keywords match messages containing any of them as whole words,
+	// ignoring case.
+	Keywords []string `yaml:"keywords"`
+	// Regex matches messages by a regular expression. Its submatches are
+	// available as .Match in templates, by name and by index.
+	Regex  string `yaml:"regex"`
+	Intent string `yaml:"intent"`
+	// Answer is the answer. Set Answers instead to pick one at random.
+	Answer       string   `yaml:"answer"`
+	Answers      []string `yaml:"answers"`
+	QuickReplies []string `yaml:"quick_replies"`
+	// Set stores values into the room state by key. Values are templates.
+	Set map[string]string `yaml:"set"`
+
+	keywords [][]string
+	re       *regexp.Regexp
+	answers  []*template.Template
+	set      map[string]*template.Template
+}
+
+// Data is passed to templates.
+type Data struct {
+	Text  string            // the user's message.
+	Match map[string]string // submatches of the rule's regular expression.
+	State map[string]string // the room state. Strings are unquoted; other values are JSON.
+	Bot   string            // the bot ID.
+	Room  string            // the room ID.
+}
+
+// Load reads a rules file.
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Parse parses and compiles rules from YAML.
+func Parse(data []byte) (*Rules, error) {
+	var r Rules
+	if err := yaml.UnmarshalStrict(data, &r); err != nil {
+		return nil, err
+	}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *Rules) compile() error {
+	if r.IntentThreshold == 0 {
+		r.IntentThreshold = DefaultIntentThreshold
+	}
+	if r.IntentThreshold < 0 || r.IntentThreshold > 1 {
+		return fmt.Errorf("intent_threshold must be between 0 and 1")
+	}
+	r.intents = make(map[string][][]string)
+	for name, examples := range r.Intents {
+		for _, ex := range examples {
+			if ws := words(ex); len(ws) > 0 {
+				r.intents[name] = append(r.intents[name], ws)
+			}
+		}
+		if len(r.intents[name]) == 0 {
+			return fmt.Errorf("intent %s: no examples", name)
+		}
+	}
+	if len(r.Rules) == 0 && len(r.Fallback) == 0 {
+		return errors.New("no rules")
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if err := rule.compile(r); err != nil {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return fmt.Errorf("rule %s: %w", name, err)
+		}
+	}
+	for i, text := range r.Fallback {
+		t, err := parseTemplate(text)
+		if err != nil {
+			return fmt.Errorf("fallback #%d: %w", i+1, err)
+		}
+		r.fallback = append(r.fallback, t)
+	}
+	return nil
+}
+
+func (rule *Rule) compile(r *Rules) error {
+	if len(rule.Keywords) == 0 && rule.Regex == "" && rule.Intent == "" {
+		return errors.New("one of keywords, regex and intent is required")
+	}
+	for _, kw := range rule.Keywords {
+		if ws := words(kw); len(ws) > 0 {
+			rule.keywords = append(rule.keywords, ws)
+		}
+	}
+	if rule.Regex != "" {
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		rule.re = re
+	}
+	if rule.Intent != "" {
+		if _, ok := r.intents[rule.Intent]; !ok {
+			return fmt.Errorf("unknown intent %q", rule.Intent)
+		}
+	}
+	answers := rule.Answers
+	if rule.Answer != "" {
+		if len(answers) > 0 {
+			return errors.New("only one of answer and answers may be set")
+		}
+		answers = []string{rule.Answer}
+	}
+	if len(answers) == 0 && len(rule.Set) == 0 {
+		return errors.New("answer, answers or set is required")
+	}
+	for _, text := range answers {
+		t, err := parseTemplate(text)
+		if err != nil {
+			return err
+		}
+		rule.answers = append(rule.answers, t)
+	}
+	rule.set = make(map[string]*template.Template)
+	for key, text := range rule.Set {
+		t, err := parseTemplate(text)
+		if err != nil {
+			return fmt.Errorf("set %s: %w", key, err)
+		}
+		rule.set[key] = t
+	}
+	return nil
+}
+
+// Match returns the first rule matching text and the submatches of its
+// regular expression, or nil if no rule matches.
+func (r *Rules) Match(text string) (*Rule, map[string]string) {
+	ws := words(text)
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.re != nil {
+			if m := rule.re.FindStringSubmatch(text); m != nil {
+				match := make(map[string]string)
+				for j, name := range rule.re.SubexpNames() {
+					match[fmt.Sprint(j)] = m[j]
+					if name != "" {
+						match[name] = m[j]
+					}
+				}
+				return rule, match
+			}
+		}
+		for _, kw := range rule.keywords {
+			if containsWords(ws, kw) {
+				return rule, nil
+			}
+		}
+		if rule.Intent != "" && r.IntentScore(rule.Intent, text) >= r.IntentThreshold {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// IntentScore returns how well text matches the intent, from 0 to 1: the
+// largest fraction of an example's words found in text.
+func (r *Rules) IntentScore(intent, text string) float64 {
+	set := make(map[string]bool)
+	for _, w := range words(text) {
+		set[w] = true
+	}
+	best := 0.0
+	for _, ex := range r.intents[intent] {
+		n := 0
+		for _, w := range ex {
+			if set[w] {
+				n++
+			}
+		}
+		if score := float64(n) / float64(len(ex)); score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// pick executes one of ts at random, or returns "" if ts is empty.
+func pick(ts []*template.Template, data Data) (string, error) {
+	if len(ts) == 0 {
+		return "", nil
+	}
+	return execute(ts[rand.Intn(len(ts))], data)
+}
+
+func execute(t *template.Template, data Data) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+var funcs = template.FuncMap{
+	// default returns value, or def if value is empty.
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("").Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return t, nil
+}
+
+// words returns lower-cased words in s.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
+
+// containsWords reports whether ws contains sub as consecutive words.
+func containsWords(ws, sub []string) bool {
+outer:
+	for i := 0; i+len(sub) <= len(ws); i++ {
+		for j, w := range sub {
+			if ws[i+j] != w {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
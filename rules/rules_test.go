@@ -0,0 +1,253 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRules = `
+intents:
+  greeting: [hello, good morning]
+  hours: [when are you open]
+rules:
+  - name: name
+    regex: "(?i)^my name is (?P<name>\\w+)"
+    answer: Nice to meet you, {{.Match.name}}!
+  - name: hours
+    keywords: [opening hours, open]
+    answer: We're open from 9 to 5.
+  - name: greeting
+    intent: greeting
+    answer: Hello, {{default "stranger" .State.name}}!
+  - name: when
+    intent: hours
+    answer: From 9 to 5.
+fallback:
+  - Sorry, I don't understand.
+`
+
+func mustParse(t *testing.T, data string) *Rules {
+	t.Helper()
+	r, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return r
+}
+
+func TestMatch(t *testing.T) {
+	r := mustParse(t, testRules)
+	for _, tc := range []struct {
+		text  string
+		rule  string // empty if no rule matches.
+		match map[string]string
+	}{
+		{"My name is Alice", "name", map[string]string{"0": "My name is Alice", "1": "Alice", "name": "Alice"}},
+		{"what are your opening hours?", "hours", nil},
+		{"Are you OPEN today?", "hours", nil},
+		{"reopened", "", nil},
+		{"hours", "", nil},
+		{"hello there", "greeting", nil},
+		{"Good morning!", "greeting", nil},
+		{"morning", "", nil},
+		{"when are you usually open", "hours", nil},
+		{"when will you be there", "", nil},
+		{"", "", nil},
+	} {
+		rule, match := r.Match(tc.text)
+		var name string
+		if rule != nil {
+			name = rule.Name
+		}
+		if name != tc.rule {
+			t.Errorf("Match(%q) rule = %q, want %q", tc.text, name, tc.rule)
+			continue
+		}
+		if !reflect.DeepEqual(match, tc.match) {
+			t.Errorf("Match(%q) match = %v, want %v", tc.text, match, tc.match)
+		}
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	r := mustParse(t, `
+rules:
+  - name: first
+    keywords: [price]
+    answer: first
+  - name: second
+    keywords: [price]
+    answer: second
+`)
+	rule, _ := r.Match("price")
+	if rule == nil || rule.Name != "first" {
+		t.Errorf("Match returned %v, want the first rule", rule)
+	}
+}
+
+func TestIntentScore(t *testing.T) {
+	r := mustParse(t, testRules)
+	for _, tc := range []struct {
+		intent, text string
+		want         float64
+	}{
+		{"greeting", "hello", 1},
+		{"greeting", "HELLO!", 1},
+		{"greeting", "good morning to you", 1},
+		{"greeting", "good night", 0.5},
+		{"greeting", "bye", 0},
+		{"hours", "when are you open", 1},
+		{"hours", "are you open", 0.75},
+		{"hours", "open", 0.25},
+		{"unknown", "hello", 0},
+	} {
+		if got := r.IntentScore(tc.intent, tc.text); got != tc.want {
+			t.Errorf("IntentScore(%q, %q) = %v, want %v", tc.intent, tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestIntentThreshold(t *testing.T) {
+	r := mustParse(t, `
+intents:
+  hours: [when are you open]
+intent_threshold: 0.5
+rules:
+  - intent: hours
+    answer: From 9 to 5.
+`)
+	for _, tc := range []struct {
+		text string
+		want bool
+	}{
+		{"are you open", true},
+		{"you open", true},
+		{"open", false},
+	} {
+		rule, _ := r.Match(tc.text)
+		if got := rule != nil; got != tc.want {
+			t.Errorf("Match(%q) matched = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestContainsWords(t *testing.T) {
+	for _, tc := range []struct {
+		s, sub string
+		want   bool
+	}{
+		{"what are your opening hours", "opening hours", true},
+		{"what are your opening hours", "hours", true},
+		{"what are your opening hours", "what", true},
+		{"what are your opening hours", "hours opening", false},
+		{"what are your opening hours", "opening your", false},
+		{"hours", "opening hours", false},
+		{"hour", "hours", false},
+		{"Opening, HOURS?", "opening hours", true},
+		{"", "hours", false},
+		{"anything", "", true},
+	} {
+		if got := containsWords(words(tc.s), words(tc.sub)); got != tc.want {
+			t.Errorf("containsWords(%q, %q) = %v, want %v", tc.s, tc.sub, got, tc.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"  it's 9-5  ", []string{"it", "s", "9", "5"}},
+		{"Ça va?", []string{"ça", "va"}},
+		{"?!", []string{}},
+	} {
+		if got := words(tc.s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("words(%q) = %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestTemplateData(t *testing.T) {
+	data := Data{
+		Text:  "my name is alice",
+		Match: map[string]string{"0": "my name is alice", "1": "alice", "name": "alice"},
+		State: map[string]string{"name": "Alice", "visits": "3"},
+		Bot:   "bot1",
+		Room:  "room1",
+	}
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"{{.Text}}", "my name is alice"},
+		{"{{.Match.name}} {{index .Match \"1\"}}", "alice alice"},
+		{"{{.State.name}} visited {{.State.visits}} times", "Alice visited 3 times"},
+		{"{{.Bot}}/{{.Room}}", "bot1/room1"},
+		{"{{.State.missing}}", ""},
+		{"{{.Match.missing}}", ""},
+		{`{{default "stranger" .State.missing}}`, "stranger"},
+		{`{{default "stranger" .State.name}}`, "Alice"},
+		{"{{upper .Match.name}} {{lower .State.name}}", "ALICE alice"},
+		{"{{trim \"  x  \"}}", "x"},
+		{"  Hi!\n", "Hi!"},
+	} {
+		tmpl, err := parseTemplate(tc.text)
+		if err != nil {
+			t.Errorf("parseTemplate(%q): %v", tc.text, err)
+			continue
+		}
+		got, err := execute(tmpl, data)
+		if err != nil {
+			t.Errorf("execute(%q): %v", tc.text, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("execute(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	r := mustParse(t, testRules)
+	rule, match := r.Match("my name is Bob")
+	got, err := pick(rule.answers, Data{Match: match})
+	if err != nil {
+		t.Fatalf("pick: %v", err)
+	}
+	if want := "Nice to meet you, Bob!"; got != want {
+		t.Errorf("pick = %q, want %q", got, want)
+	}
+	got, err = pick(r.fallback, Data{})
+	if err != nil {
+		t.Fatalf("pick fallback: %v", err)
+	}
+	if want := "Sorry, I don't understand."; got != want {
+		t.Errorf("pick fallback = %q, want %q", got, want)
+	}
+	if got, err := pick(nil, Data{}); got != "" || err != nil {
+		t.Errorf("pick(nil) = %q, %v, want empty", got, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, want string
+	}{
+		{"empty", "{}", "no rules"},
+		{"no matcher", "rules: [{answer: hi}]", "rule #1: one of keywords, regex and intent is required"},
+		{"no answer", "rules: [{name: x, keywords: [a]}]", "rule x: answer, answers or set is required"},
+		{"both answers", "rules: [{keywords: [a], answer: a, answers: [b]}]", "only one of answer and answers may be set"},
+		{"bad regex", `rules: [{regex: "(", answer: a}]`, "invalid regex"},
+		{"unknown intent", "rules: [{intent: x, answer: a}]", `unknown intent "x"`},
+		{"bad template", "rules: [{keywords: [a], answer: '{{'}]", "invalid template"},
+		{"bad threshold", "intent_threshold: 2\nfallback: [a]", "intent_threshold must be between 0 and 1"},
+		{"unknown field", "rulez: []", "field rulez not found"},
+	} {
+		_, err := Parse([]byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Parse error = %v, want containing %q", tc.name, err, tc.want)
+		}
+	}
+}
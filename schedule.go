@@ -0,0 +1,149 @@
+package easybot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ScheduledMessageResponse struct {
+	ID           primitive.ObjectID `json:"id"`
+	RoomID       primitive.ObjectID `json:"roomID"`
+	Type         MessageType        `json:"type"`
+	Text         string             `json:"text"`
+	QuickReplies []string           `json:"quickReplies,omitempty"`
+	ClientID     string             `json:"clientID,omitempty"`
+	SendAt       time.Time          `json:"sendAt"`
+	CreatedAt    time.Time          `json:"createdAt"`
+}
+
+// NewScheduledMessageResponse returns a ScheduledMessageResponse for msg.
+func NewScheduledMessageResponse(msg ScheduledMessage) ScheduledMessageResponse {
+	return ScheduledMessageResponse{
+		ID:           msg.ID,
+		RoomID:       msg.RoomID,
+		Type:         msg.Type,
+		Text:         msg.Text,
+		QuickReplies: msg.QuickReplies,
+		ClientID:     msg.ClientID,
+		SendAt:       msg.SendAt,
+		CreatedAt:    msg.CreatedAt,
+	}
+}
+
+// ListScheduledMessages is a handler for listing pending scheduled messages
+// of a bot, or of a room if the room is given.
+// TODO: use pagination
+func (server *Server) ListScheduledMessages(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	var roomID primitive.ObjectID
+	if room, ok := c.Locals(RoomLocalsKey).(Room); ok {
+		roomID = room.ID
+	}
+	msgs, err := server.db.GetScheduledMessages(context.TODO(), bot.ID, roomID)
+	if err != nil {
+		return fmt.Errorf("get scheduled messages: %w", err)
+	}
+	resp := make([]ScheduledMessageResponse, len(msgs))
+	for i, msg := range msgs {
+		resp[i] = NewScheduledMessageResponse(msg)
+	}
+	return c.JSON(fiber.Map{
+		"scheduled": resp,
+	})
+}
+
+// CancelScheduledMessage is a handler for cancelling a scheduled message.
+func (server *Server) CancelScheduledMessage(c *fiber.Ctx) error {
+	room := c.Locals(RoomLocalsKey).(Room)
+	id, err := primitive.ObjectIDFromHex(c.Params("scheduled"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("scheduled message %s not found", c.Params("scheduled")))
+	}
+	if err := server.db.DeleteScheduledMessage(context.TODO(), room.ID, id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("scheduled message %s not found", id))
+		}
+		return fmt.Errorf("delete scheduled message: %w", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RunScheduler sends scheduled messages when they are due, until ctx is
+// done.
+func (server *Server) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(server.cfg.Scheduler.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := server.sendDueMessages(ctx); err != nil {
+			log.Printf("send scheduled messages: %v", err)
+		}
+	}
+}
+
+// dueMessagesBatch is the number of due scheduled messages read at a time.
+const dueMessagesBatch = 100
+
+// sendDueMessages sends scheduled messages which are due. A scheduled message
+// is deleted only after it's sent, and sending it again is a no-op thanks to
+// its client ID, so that a failure or a crash in between doesn't lose it. A
+// message which fails is logged and left for the next run, without holding up
+// the others.
+func (server *Server) sendDueMessages(ctx context.Context) error {
+	failed := make(map[primitive.ObjectID]bool)
+	for {
+		// Failed messages are still due, so read past them.
+		limit := dueMessagesBatch + len(failed)
+		msgs, err := server.db.GetDueScheduledMessages(ctx, time.Now(), int64(limit))
+		if err != nil {
+			return fmt.Errorf("get due scheduled messages: %w", err)
+		}
+		for _, msg := range msgs {
+			if failed[msg.ID] {
+				continue
+			}
+			if err := server.sendScheduledMessage(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("send scheduled message %s: %v", msg.ID.Hex(), err)
+				failed[msg.ID] = true
+			}
+		}
+		if len(msgs) < limit {
+			return nil
+		}
+	}
+}
+
+// sendScheduledMessage sends a scheduled message and deletes it.
+func (server *Server) sendScheduledMessage(ctx context.Context, msg ScheduledMessage) error {
+	clientID := msg.ClientID
+	if clientID == "" {
+		clientID = ServerClientIDPrefix + "scheduled-" + msg.ID.Hex()
+	}
+	if _, err := server.createMessages(ctx, msg.BotID, []Message{{
+		RoomID:       msg.RoomID,
+		Type:         msg.Type,
+		Text:         msg.Text,
+		QuickReplies: msg.QuickReplies,
+		ClientID:     clientID,
+		CreatedAt:    time.Now(),
+	}}); err != nil && !errors.Is(err, ErrDuplicate) {
+		return fmt.Errorf("create messages: %w", err)
+	}
+	if err := server.db.DeleteScheduledMessage(ctx, msg.RoomID, msg.ID); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("delete scheduled message: %w", err)
+	}
+	return nil
+}
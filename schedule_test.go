@@ -0,0 +1,62 @@
+package easybot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// failingStore fails to create messages in a room.
+type failingStore struct {
+	Store
+	roomID primitive.ObjectID
+}
+
+func (s failingStore) CreateMessages(ctx context.Context, msgs []Message) ([]Message, error) {
+	for _, msg := range msgs {
+		if msg.RoomID == s.roomID {
+			return nil, errors.New("boom")
+		}
+	}
+	return s.Store.CreateMessages(ctx, msgs)
+}
+
+func TestSendDueMessagesSkipsFailures(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryStore()
+	bad := createRoom(t, mem)
+	good := createRoom(t, mem)
+	store := failingStore{Store: mem, roomID: bad.ID}
+	past := time.Now().Add(-time.Minute)
+	// The failing message is due first, so it would block the other one.
+	var scheduled []ScheduledMessage
+	for i := 0; i < dueMessagesBatch+1; i++ {
+		scheduled = append(scheduled, ScheduledMessage{BotID: bad.BotID, RoomID: bad.ID, Type: BotMessage, Text: "bad", SendAt: past})
+	}
+	scheduled = append(scheduled, ScheduledMessage{BotID: good.BotID, RoomID: good.ID, Type: BotMessage, Text: "good", SendAt: past.Add(time.Second)})
+	if _, err := store.CreateScheduledMessages(ctx, scheduled); err != nil {
+		t.Fatalf("CreateScheduledMessages: %v", err)
+	}
+
+	server := NewServer(DefaultServerConfig, store)
+	if err := server.sendDueMessages(ctx); err != nil {
+		t.Fatalf("sendDueMessages: %v", err)
+	}
+	msgs, err := store.GetMessages(ctx, good.ID, BotMessage)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Text != "good" {
+		t.Errorf("messages in the good room = %+v, want the scheduled one", msgs)
+	}
+	left, err := store.GetScheduledMessages(ctx, bad.BotID, primitive.NilObjectID)
+	if err != nil {
+		t.Fatalf("GetScheduledMessages: %v", err)
+	}
+	if len(left) != dueMessagesBatch+1 {
+		t.Errorf("%d scheduled messages left, want the %d failed ones", len(left), dueMessagesBatch+1)
+	}
+}
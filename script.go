@@ -0,0 +1,761 @@
+package easybot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxScriptSize is the maximum size of a bot's script in bytes.
+	MaxScriptSize = 64 << 10
+	// DefaultScriptLogLimit is the number of logs returned by the script
+	// logs endpoint when no limit is given.
+	DefaultScriptLogLimit = 100
+	// MaxScriptLogLimit is the maximum number of logs returned by the script
+	// logs endpoint.
+	MaxScriptLogLimit = 1000
+
+	// Limits of a script run on a message.
+	maxScriptReplies       = 10
+	maxScriptLogs          = 100
+	maxScriptLogLength     = 1000
+	maxScriptStateSize     = 64 << 10
+	maxScriptHTTPBody      = 1 << 20
+	maxScriptRedirects     = 10
+	maxScriptCallStack     = 1000
+	scriptMemoryCheckEvery = 10 * time.Millisecond
+)
+
+// nativeFrame matches the location of an error in a Go function.
+var nativeFrame = regexp.MustCompile(` at \S+ \(native\)$`)
+
+// Reasons a script run is interrupted.
+var (
+	errScriptTimeout = errors.New("script timed out")
+	errScriptMemory  = errors.New("scripts used too much memory")
+)
+
+type ScriptRequest struct {
+	Script string `json:"script"`
+}
+
+type ScriptResponse struct {
+	Script string `json:"script"`
+}
+
+type ScriptLogResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	RoomID    primitive.ObjectID `json:"roomID"`
+	MessageID primitive.ObjectID `json:"messageID"`
+	Level     ScriptLogLevel     `json:"level"`
+	Text      string             `json:"text"`
+	CreatedAt time.Time          `json:"createdAt"`
+}
+
+// NewScriptLogResponse returns a ScriptLogResponse for l.
+func NewScriptLogResponse(l ScriptLog) ScriptLogResponse {
+	return ScriptLogResponse{
+		ID:        l.ID,
+		RoomID:    l.RoomID,
+		MessageID: l.MessageID,
+		Level:     l.Level,
+		Text:      l.Text,
+		CreatedAt: l.CreatedAt,
+	}
+}
+
+// compileScript compiles a bot's script, reporting syntax errors.
+func compileScript(script string) (*goja.Program, error) {
+	return goja.Compile("script.js", script, false)
+}
+
+// GetScript is a handler for getting the bot's script.
+func (server *Server) GetScript(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	if bot.Script == "" {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("bot %s has no script", bot.ID))
+	}
+	return c.JSON(ScriptResponse{Script: bot.Script})
+}
+
+// PutScript is a handler for setting the bot's script, which the server runs
+// on every user message from then on.
+func (server *Server) PutScript(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	var body ScriptRequest
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if strings.TrimSpace(body.Script) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "script is required")
+	}
+	if len(body.Script) > MaxScriptSize {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("script is too large: at most %d bytes are allowed", MaxScriptSize))
+	}
+	if _, err := compileScript(body.Script); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid script: %v", err))
+	}
+	if err := server.db.SetBotScript(context.TODO(), bot.ID, body.Script); err != nil {
+		return fmt.Errorf("set bot script: %w", err)
+	}
+	return c.JSON(ScriptResponse{Script: body.Script})
+}
+
+// DeleteScript is a handler for removing the bot's script.
+func (server *Server) DeleteScript(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	if bot.Script == "" {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("bot %s has no script", bot.ID))
+	}
+	if err := server.db.SetBotScript(context.TODO(), bot.ID, ""); err != nil {
+		return fmt.Errorf("set bot script: %w", err)
+	}
+	server.scripts.programs.forget(bot.ID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListScriptLogs is a handler for listing the latest logs of the bot's
+// script, oldest first, optionally of a room only.
+func (server *Server) ListScriptLogs(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	var query struct {
+		Room  string `query:"room"`
+		Limit int64  `query:"limit"`
+	}
+	if err := c.QueryParser(&query); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultScriptLogLimit
+	} else if limit > MaxScriptLogLimit {
+		limit = MaxScriptLogLimit
+	}
+	var roomID primitive.ObjectID
+	if query.Room != "" {
+		id, err := primitive.ObjectIDFromHex(query.Room)
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("room %s not found", query.Room))
+		}
+		roomID = id
+	}
+	logs, err := server.db.GetScriptLogs(context.TODO(), bot.ID, roomID, limit)
+	if err != nil {
+		return fmt.Errorf("get script logs: %w", err)
+	}
+	resp := make([]ScriptLogResponse, len(logs))
+	for i, l := range logs {
+		resp[i] = NewScriptLogResponse(l)
+	}
+	return c.JSON(fiber.Map{
+		"logs": resp,
+	})
+}
+
+// scriptQueue runs scripts on messages of each room in order, one at a
+// time, and runs at most a limited number of scripts at the same time.
+type scriptQueue struct {
+	mu    sync.Mutex
+	rooms map[primitive.ObjectID][]scriptJob // pending jobs, by room ID while its worker runs.
+	sem   chan struct{}
+
+	programs scriptPrograms
+	memory   *memoryGuard
+}
+
+type scriptJob struct {
+	bot  Bot
+	room Room
+	msg  Message
+}
+
+func newScriptQueue(cfg ScriptConfig) *scriptQueue {
+	n := cfg.Concurrency
+	if n <= 0 {
+		n = 1
+	}
+	return &scriptQueue{
+		rooms:    make(map[primitive.ObjectID][]scriptJob),
+		sem:      make(chan struct{}, n),
+		programs: scriptPrograms{progs: make(map[primitive.ObjectID]scriptProgram)},
+		memory:   newMemoryGuard(cfg.MaxMemory),
+	}
+}
+
+// push queues jobs of a room, starting a worker for the room which calls run
+// for each job if there isn't one.
+func (q *scriptQueue) push(roomID primitive.ObjectID, jobs []scriptJob, run func(scriptJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, running := q.rooms[roomID]
+	q.rooms[roomID] = append(pending, jobs...)
+	if !running {
+		go q.work(roomID, run)
+	}
+}
+
+func (q *scriptQueue) work(roomID primitive.ObjectID, run func(scriptJob)) {
+	for {
+		q.mu.Lock()
+		jobs := q.rooms[roomID]
+		if len(jobs) == 0 {
+			delete(q.rooms, roomID)
+			q.mu.Unlock()
+			return
+		}
+		job := jobs[0]
+		q.rooms[roomID] = jobs[1:]
+		q.mu.Unlock()
+
+		q.sem <- struct{}{}
+		run(job)
+		<-q.sem
+	}
+}
+
+// scriptPrograms caches the compiled scripts of bots, so that a script is
+// compiled once rather than on every message.
+type scriptPrograms struct {
+	mu    sync.Mutex
+	progs map[primitive.ObjectID]scriptProgram // by bot ID.
+}
+
+type scriptProgram struct {
+	script string
+	prog   *goja.Program
+}
+
+// get returns the compiled script of bot, compiling it if the bot's script
+// has changed since it was cached.
+func (p *scriptPrograms) get(bot Bot) (*goja.Program, error) {
+	p.mu.Lock()
+	cached, ok := p.progs[bot.ID]
+	p.mu.Unlock()
+	if ok && cached.script == bot.Script {
+		return cached.prog, nil
+	}
+	prog, err := compileScript(bot.Script)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.progs[bot.ID] = scriptProgram{script: bot.Script, prog: prog}
+	p.mu.Unlock()
+	return prog, nil
+}
+
+// forget drops the compiled script of a bot.
+func (p *scriptPrograms) forget(botID primitive.ObjectID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.progs, botID)
+}
+
+// runScripts queues the bot's script to run on each of msgs in room, after
+// earlier messages of the room.
+func (server *Server) runScripts(bot Bot, room Room, msgs []Message) {
+	jobs := make([]scriptJob, len(msgs))
+	for i, msg := range msgs {
+		jobs[i] = scriptJob{bot: bot, room: room, msg: msg}
+	}
+	server.scripts.push(room.ID, jobs, server.runScriptJob)
+}
+
+// ResumeScripts queues scripts to run on unread user messages of bots with a
+// script, which were left in the queue when the server stopped. It should be
+// called once before the server starts.
+func (server *Server) ResumeScripts(ctx context.Context) error {
+	bots, err := server.db.GetBots(ctx)
+	if err != nil {
+		return fmt.Errorf("get bots: %w", err)
+	}
+	for _, bot := range bots {
+		if bot.Script == "" {
+			continue
+		}
+		rooms, err := server.db.GetRooms(ctx, bot.ID)
+		if err != nil {
+			return fmt.Errorf("get rooms of bot %s: %w", bot.ID.Hex(), err)
+		}
+		for _, room := range rooms {
+			msgs, err := server.db.GetUnreadMessages(ctx, room.ID, UserMessage)
+			if err != nil {
+				return fmt.Errorf("get unread messages of room %s: %w", room.ID.Hex(), err)
+			}
+			if len(msgs) > 0 {
+				server.runScripts(bot, room, msgs)
+			}
+		}
+	}
+	return nil
+}
+
+// runScriptJob runs the script of a job, stores its logs and marks the
+// message as read.
+func (server *Server) runScriptJob(job scriptJob) {
+	prog, err := server.scripts.programs.get(job.bot)
+	if err != nil {
+		log.Printf("compile script of bot %s: %v", job.bot.ID.Hex(), err)
+		return
+	}
+	logs := server.runScript(prog, job.bot, job.room, job.msg)
+	if err := server.db.CreateScriptLogs(context.Background(), logs); err != nil {
+		log.Printf("create script logs of bot %s: %v", job.bot.ID.Hex(), err)
+	}
+	if err := server.markRead([]Message{job.msg}); err != nil {
+		log.Printf("mark message %s read: %v", job.msg.ID.Hex(), err)
+	}
+}
+
+// runScript runs prog on msg in a new sandbox, and returns what it logged.
+// An error of the script is logged too.
+func (server *Server) runScript(prog *goja.Program, bot Bot, room Room, msg Message) []ScriptLog {
+	cfg := server.cfg.Script
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	s := &sandbox{
+		server: server,
+		ctx:    ctx,
+		vm:     goja.New(),
+		bot:    bot,
+		room:   room,
+		msg:    msg,
+	}
+	s.vm.SetMaxCallStackSize(maxScriptCallStack)
+	s.setup()
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.watch(done)
+	defer server.scripts.memory.add(s.vm)()
+
+	_, err := s.vm.RunProgram(prog)
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		var overflow *goja.StackOverflowError
+		if errors.As(err, &overflow) {
+			err = fmt.Errorf("stack overflow: more than %d nested calls", maxScriptCallStack)
+		} else if errors.As(err, &interrupted) {
+			switch interrupted.Value() {
+			case errScriptTimeout:
+				err = fmt.Errorf("%w after %s", errScriptTimeout, cfg.Timeout)
+			case errScriptMemory:
+				err = fmt.Errorf("%w: the server's heap grew by more than %d bytes while scripts ran", errScriptMemory, cfg.MaxMemory)
+			}
+		}
+		// Errors thrown by the API are located at its native functions,
+		// which means nothing to the script's author.
+		s.log(ScriptLogError, nativeFrame.ReplaceAllString(err.Error(), ""))
+	}
+	if s.dropped > 0 {
+		s.logs = append(s.logs, ScriptLog{
+			BotID:     bot.ID,
+			RoomID:    room.ID,
+			MessageID: msg.ID,
+			Level:     ScriptLogWarn,
+			Text:      fmt.Sprintf("%d more logs were dropped: at most %d are kept per message", s.dropped, maxScriptLogs),
+			CreatedAt: time.Now(),
+		})
+	}
+	return s.logs
+}
+
+// sandbox is the environment of a script run on a message.
+type sandbox struct {
+	server *Server
+	ctx    context.Context
+	vm     *goja.Runtime
+	bot    Bot
+	room   Room
+	msg    Message
+
+	replies int
+	logs    []ScriptLog
+	dropped int // number of logs over the limit.
+}
+
+// watch interrupts the script when it runs out of time, until done is
+// closed.
+func (s *sandbox) watch(done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-s.ctx.Done():
+		s.vm.Interrupt(errScriptTimeout)
+	}
+}
+
+// memoryGuard interrupts running scripts when the server's heap grows by
+// more than a limit while they run. The heap is shared by all scripts, so it
+// can't tell which script allocated; it protects the server rather than
+// metering each script, and interrupts every script running at the time. A
+// nil memoryGuard does nothing.
+type memoryGuard struct {
+	limit uint64
+
+	mu       sync.Mutex
+	vms      map[*goja.Runtime]struct{}
+	base     uint64 // lowest heap size seen since scripts started running.
+	watching bool
+}
+
+func newMemoryGuard(limit uint64) *memoryGuard {
+	if limit == 0 {
+		return nil
+	}
+	return &memoryGuard{limit: limit, vms: make(map[*goja.Runtime]struct{})}
+}
+
+// add watches vm until the returned function is called.
+func (g *memoryGuard) add(vm *goja.Runtime) func() {
+	if g == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.vms) == 0 {
+		g.base = heapBytes()
+	}
+	if !g.watching {
+		g.watching = true
+		go g.watch()
+	}
+	g.vms[vm] = struct{}{}
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		delete(g.vms, vm)
+	}
+}
+
+// watch checks the heap until no script is running.
+func (g *memoryGuard) watch() {
+	ticker := time.NewTicker(scriptMemoryCheckEvery)
+	defer ticker.Stop()
+	for range ticker.C {
+		heap := heapBytes()
+		g.mu.Lock()
+		if len(g.vms) == 0 {
+			g.watching = false
+			g.mu.Unlock()
+			return
+		}
+		// Garbage counted in the base may be collected meanwhile, which
+		// would leave more room than the limit.
+		if heap < g.base {
+			g.base = heap
+		}
+		if heap-g.base > g.limit {
+			for vm := range g.vms {
+				vm.Interrupt(errScriptMemory)
+				delete(g.vms, vm)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
+
+// heapBytes returns the number of bytes of live and unswept objects in the
+// heap.
+func heapBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// setup defines the API of scripts.
+func (s *sandbox) setup() {
+	vm := s.vm
+	vm.Set("message", map[string]interface{}{
+		"id":        s.msg.ID.Hex(),
+		"roomID":    s.msg.RoomID.Hex(),
+		"text":      s.msg.Text,
+		"createdAt": s.msg.CreatedAt.Format(time.RFC3339Nano),
+	})
+	vm.Set("bot", map[string]interface{}{
+		"id":   s.bot.ID.Hex(),
+		"name": s.bot.Name,
+	})
+	metadata := make(map[string]interface{}, len(s.room.Metadata))
+	for k, v := range s.room.Metadata {
+		metadata[k] = v
+	}
+	vm.Set("room", map[string]interface{}{
+		"id":       s.room.ID.Hex(),
+		"metadata": metadata,
+	})
+	vm.Set("reply", s.reply)
+	vm.Set("state", s.stateObject(RoomState, s.room.ID))
+	vm.Set("botState", s.stateObject(BotState, s.bot.ID))
+
+	console := vm.NewObject()
+	console.Set("log", s.logFunc(ScriptLogInfo))
+	console.Set("info", s.logFunc(ScriptLogInfo))
+	console.Set("warn", s.logFunc(ScriptLogWarn))
+	console.Set("error", s.logFunc(ScriptLogError))
+	vm.Set("console", console)
+
+	httpObj := vm.NewObject()
+	httpObj.Set("request", s.httpRequest)
+	httpObj.Set("get", func(call goja.FunctionCall) goja.Value {
+		opts := vm.NewObject()
+		opts.Set("url", call.Argument(0))
+		return s.httpRequest(goja.FunctionCall{Arguments: []goja.Value{opts}})
+	})
+	vm.Set("http", httpObj)
+}
+
+// throw throws a JavaScript Error with a formatted message.
+func (s *sandbox) throw(format string, args ...interface{}) {
+	e, err := s.vm.New(s.vm.Get("Error"), s.vm.ToValue(fmt.Sprintf(format, args...)))
+	if err != nil {
+		panic(err)
+	}
+	panic(e)
+}
+
+// reply(text, quickReplies) sends a bot message into the room.
+func (s *sandbox) reply(call goja.FunctionCall) goja.Value {
+	if s.replies >= maxScriptReplies {
+		s.throw("too many replies: at most %d are allowed", maxScriptReplies)
+	}
+	text := call.Argument(0).String()
+	var quickReplies []string
+	if v := call.Argument(1); !goja.IsUndefined(v) && !goja.IsNull(v) {
+		if err := s.vm.ExportTo(v, &quickReplies); err != nil {
+			s.throw("quick replies must be an array of strings")
+		}
+		if len(quickReplies) > MaxQuickReplies {
+			s.throw("too many quick replies: at most %d are allowed", MaxQuickReplies)
+		}
+	}
+	s.replies++
+	if _, err := s.server.createMessages(s.ctx, s.bot.ID, []Message{{
+		RoomID:       s.room.ID,
+		Type:         BotMessage,
+		Text:         text,
+		QuickReplies: quickReplies,
+		CreatedAt:    time.Now(),
+	}}); err != nil {
+		s.throw("reply: %v", err)
+	}
+	return goja.Undefined()
+}
+
+// stateObject returns an object with get, set and delete functions for the
+// states of the owner. Values are stored as JSON.
+func (s *sandbox) stateObject(scope StateScope, ownerID primitive.ObjectID) *goja.Object {
+	obj := s.vm.NewObject()
+	obj.Set("get", func(call goja.FunctionCall) goja.Value {
+		state, err := s.server.db.GetState(s.ctx, scope, ownerID, call.Argument(0).String())
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return goja.Undefined()
+			}
+			s.throw("get state: %v", err)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(state.Value), &v); err != nil {
+			s.throw("decode state: %v", err)
+		}
+		return s.vm.ToValue(v)
+	})
+	obj.Set("set", func(call goja.FunctionCall) goja.Value {
+		key := call.Argument(0).String()
+		if key == "" {
+			s.throw("key is required")
+		}
+		value, err := json.Marshal(call.Argument(1).Export())
+		if err != nil {
+			s.throw("encode state: %v", err)
+		}
+		if len(value) > maxScriptStateSize {
+			s.throw("value is too large: at most %d bytes are allowed", maxScriptStateSize)
+		}
+		if _, err := s.server.db.PutState(s.ctx, scope, ownerID, key, string(value), nil); err != nil {
+			s.throw("set state: %v", err)
+		}
+		return goja.Undefined()
+	})
+	obj.Set("delete", func(call goja.FunctionCall) goja.Value {
+		err := s.server.db.DeleteState(s.ctx, scope, ownerID, call.Argument(0).String(), nil)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			s.throw("delete state: %v", err)
+		}
+		return goja.Undefined()
+	})
+	return obj
+}
+
+// logFunc returns a console function logging its arguments at level.
+func (s *sandbox) logFunc(level ScriptLogLevel) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		args := make([]string, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = s.format(arg)
+		}
+		s.log(level, strings.Join(args, " "))
+		return goja.Undefined()
+	}
+}
+
+// format formats a logged value: strings as they are, and objects as JSON.
+func (s *sandbox) format(v goja.Value) string {
+	if _, ok := v.(*goja.Object); ok {
+		if _, isFunc := goja.AssertFunction(v); !isFunc {
+			if data, err := json.Marshal(v.Export()); err == nil {
+				return string(data)
+			}
+		}
+	}
+	return v.String()
+}
+
+func (s *sandbox) log(level ScriptLogLevel, text string) {
+	// Always keep the error of the run, even over the limit.
+	if len(s.logs) >= maxScriptLogs && level != ScriptLogError {
+		s.dropped++
+		return
+	}
+	if len(text) > maxScriptLogLength {
+		text = text[:maxScriptLogLength] + "..."
+	}
+	s.logs = append(s.logs, ScriptLog{
+		BotID:     s.bot.ID,
+		RoomID:    s.room.ID,
+		MessageID: s.msg.ID,
+		Level:     level,
+		Text:      text,
+		CreatedAt: time.Now(),
+	})
+}
+
+// httpRequest({method, url, headers, body}) makes an HTTP request and
+// returns {status, headers, body}, if the server allows it.
+func (s *sandbox) httpRequest(call goja.FunctionCall) goja.Value {
+	cfg := s.server.cfg.Script
+	if !cfg.AllowHTTP {
+		s.throw("http is disabled on this server")
+	}
+	var opts struct {
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+	}
+	data, err := json.Marshal(call.Argument(0).Export())
+	if err != nil || json.Unmarshal(data, &opts) != nil {
+		s.throw("invalid request: must be an object with method, url, headers and body")
+	}
+	if opts.Method == "" {
+		opts.Method = "GET"
+	}
+	if !strings.HasPrefix(opts.URL, "http://") && !strings.HasPrefix(opts.URL, "https://") {
+		s.throw("invalid url %q: must be an absolute http(s) url", opts.URL)
+	}
+	ctx := s.ctx
+	if cfg.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.HTTPTimeout)
+		defer cancel()
+	}
+	var body io.Reader
+	if opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, opts.Method, opts.URL, body)
+	if err != nil {
+		s.throw("invalid request: %v", err)
+	}
+	for k, v := range opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := scriptHTTPClient.Do(req)
+	if err != nil {
+		s.throw("http %s: %v", strings.ToLower(opts.Method), err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxScriptHTTPBody+1))
+	if err != nil {
+		s.throw("read body: %v", err)
+	}
+	if len(respBody) > maxScriptHTTPBody {
+		s.throw("response body is too large: at most %d bytes are allowed", maxScriptHTTPBody)
+	}
+	headers := make(map[string]interface{}, len(resp.Header))
+	for k := range resp.Header {
+		headers[strings.ToLower(k)] = resp.Header.Get(k)
+	}
+	return s.vm.ToValue(map[string]interface{}{
+		"status":  resp.StatusCode,
+		"headers": headers,
+		"body":    string(respBody),
+	})
+}
+
+// scriptHTTPClient is the client of scripts' HTTP requests. It only connects
+// to public addresses, so that scripts can't reach the server itself, its
+// private network or cloud metadata endpoints, even through redirects or DNS
+// names resolving to such addresses.
+var scriptHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxScriptRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxScriptRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to %s is not allowed", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which is private too.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a public unicast address.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!sharedAddressSpace.Contains(ip) &&
+		!(ip.To4() != nil && ip.To4()[0] == 0)
+}
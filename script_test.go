@@ -0,0 +1,85 @@
+package easybot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.0.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+	} {
+		if got := publicIP(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestScriptHTTPClientRejectsLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	resp, err := scriptHTTPClient.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("GET %s succeeded, want it rejected", srv.URL)
+	}
+}
+
+func TestResumeScripts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	room := createRoom(t, store)
+	if err := store.SetBotScript(ctx, room.BotID, `reply("got " + message.text)`); err != nil {
+		t.Fatalf("SetBotScript: %v", err)
+	}
+	// A message left unread by a server which stopped before running the
+	// script on it.
+	if _, err := store.CreateMessages(ctx, []Message{{RoomID: room.ID, Type: UserMessage, Text: "hi", Seq: 1, CreatedAt: time.Now()}}); err != nil {
+		t.Fatalf("CreateMessages: %v", err)
+	}
+
+	server := NewServer(DefaultServerConfig, store)
+	if err := server.ResumeScripts(ctx); err != nil {
+		t.Fatalf("ResumeScripts: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		replies, err := store.GetMessages(ctx, room.ID, BotMessage)
+		if err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		if len(replies) > 0 {
+			if replies[0].Text != "got hi" {
+				t.Errorf("reply = %q, want %q", replies[0].Text, "got hi")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no reply to the unread message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type LocalsKey string
@@ -19,23 +20,46 @@ const (
 	ClientTypeLocalsKey = "clientType"
 	AccessKeyLocalsKey  = "accessKey"
 
-	HeaderAccessKey = "X-Access-Key"
+	HeaderAccessKey      = "X-Access-Key"
+	HeaderIdempotencyKey = "Idempotency-Key"
 )
 
 // Server is an EasyBot server.
 type Server struct {
 	*fiber.App
-	cfg ServerConfig
-	db  *DB
+	cfg     ServerConfig
+	db      Store
+	hub     *hub
+	seq     *sequencer
+	scripts *scriptQueue
+
+	// ctx is the context of background work, such as broadcasts, which is
+	// cancelled on shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewServer returns a new Server instance.
-func NewServer(cfg ServerConfig, db *DB) *Server {
-	server := &Server{
-		App: fiber.New(cfg.Fiber),
-		cfg: cfg,
-		db:  db,
+func NewServer(cfg ServerConfig, db Store) *Server {
+	// Request bodies are streamed so that imports may be larger than the
+	// body limit, which BodyLimitMiddleware enforces for other requests.
+	if cfg.Fiber.BodyLimit <= 0 {
+		cfg.Fiber.BodyLimit = fiber.DefaultBodyLimit
+	}
+	if cfg.ImportBodyLimit <= 0 {
+		cfg.ImportBodyLimit = int64(cfg.Fiber.BodyLimit)
 	}
+	cfg.Fiber.StreamRequestBody = true
+	server := &Server{
+		App:     fiber.New(cfg.Fiber),
+		cfg:     cfg,
+		db:      db,
+		hub:     newHub(),
+		seq:     newSequencer(db),
+		scripts: newScriptQueue(cfg.Script),
+	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	server.Use(server.BodyLimitMiddleware)
 	server.RouteV1()
 	return server
 }
@@ -44,12 +68,36 @@ func NewServer(cfg ServerConfig, db *DB) *Server {
 func (server *Server) RouteV1() {
 	v1 := server.Group("/v1", server.AccessKeyMiddleware)
 
+	admin := v1.Group("/admin", server.AdminMiddleware)
+	admin.Get("/export", server.ExportData)
+	admin.Post("/import", server.ImportData)
+
 	bots := v1.Group("/bots")
 	bots.Get("", server.ListBots)
 	bots.Post("", server.CreateBot)
 
 	bot := bots.Group("/:bot", server.BotMiddleware)
+	bot.Get("", server.GetBot)
 	bot.Get("/messages", server.ReadBotMessages)
+	bot.Post("/messages/read", server.MarkBotMessagesRead)
+	bot.Get("/history", server.BotAccessMiddleware, server.ListHistory)
+	bot.Get("/events", server.BotAccessMiddleware, server.StreamEvents)
+
+	broadcasts := bot.Group("/broadcasts", server.BotAccessMiddleware)
+	broadcasts.Get("", server.ListBroadcasts)
+	broadcasts.Post("", server.CreateBroadcast)
+	broadcasts.Get("/:broadcast", server.GetBroadcast)
+
+	bot.Get("/scheduled", server.BotAccessMiddleware, server.ListScheduledMessages)
+	bot.Get("/transcripts", server.BotAccessMiddleware, server.ExportTranscripts)
+
+	script := bot.Group("/script", server.BotAccessMiddleware)
+	script.Get("", server.GetScript)
+	script.Put("", server.PutScript)
+	script.Delete("", server.DeleteScript)
+	script.Get("/logs", server.ListScriptLogs)
+
+	server.routeStates(bot.Group("/state", server.BotAccessMiddleware))
 
 	rooms := bot.Group("/rooms")
 	rooms.Get("", server.ListRooms)
@@ -58,6 +106,24 @@ func (server *Server) RouteV1() {
 	room := rooms.Group("/:room", server.RoomMiddleware, server.ClientTypeMiddleware)
 	room.Get("/messages", server.ReadMessages)
 	room.Post("/messages", server.WriteMessages)
+	room.Get("/messages/sent", server.ListSentMessages)
+	room.Get("/history", server.ListHistory)
+	room.Get("/events", server.StreamEvents)
+	room.Get("/transcript", server.GetTranscript)
+
+	scheduled := room.Group("/scheduled", server.BotAccessMiddleware)
+	scheduled.Get("", server.ListScheduledMessages)
+	scheduled.Delete("/:scheduled", server.CancelScheduledMessage)
+
+	server.routeStates(room.Group("/state", server.BotAccessMiddleware))
+}
+
+// routeStates registers routes for the state store of a bot or a room.
+func (server *Server) routeStates(states fiber.Router) {
+	states.Get("", server.ListStates)
+	states.Get("/:key", server.GetState)
+	states.Put("/:key", server.PutState)
+	states.Delete("/:key", server.DeleteState)
 }
 
 type BotResponse struct {
@@ -65,9 +131,45 @@ type BotResponse struct {
 	Name        string             `json:"name"`
 	Description string             `json:"description"`
 	AccessKey   string             `json:"accessKey,omitempty"`
+	Online      bool               `json:"online"`
+	Scripted    bool               `json:"scripted,omitempty"` // whether the server runs a script for the bot.
+	LastSeenAt  *time.Time         `json:"lastSeenAt,omitempty"`
 	CreatedAt   time.Time          `json:"createdAt"`
 }
 
+// newBotResponse returns a BotResponse for bot, without its access key.
+func (server *Server) newBotResponse(bot Bot) BotResponse {
+	resp := BotResponse{
+		ID:          bot.ID,
+		Name:        bot.Name,
+		Description: bot.Description,
+		Online:      botOnline(bot, server.cfg.Presence.OfflineAfter),
+		Scripted:    bot.Script != "",
+		CreatedAt:   bot.CreatedAt,
+	}
+	if !bot.LastSeenAt.IsZero() {
+		resp.LastSeenAt = &bot.LastSeenAt
+	}
+	return resp
+}
+
+// botOnline reports whether bot has been seen within d. Scripted bots are
+// always online, since the server runs them.
+func botOnline(bot Bot, d time.Duration) bool {
+	if bot.Script != "" {
+		return true
+	}
+	return !bot.LastSeenAt.IsZero() && time.Since(bot.LastSeenAt) <= d
+}
+
+// touchBot records that bot has been seen now.
+func (server *Server) touchBot(bot Bot) error {
+	if err := server.db.TouchBot(context.TODO(), bot.ID, time.Now()); err != nil {
+		return fmt.Errorf("touch bot: %w", err)
+	}
+	return nil
+}
+
 // CreateBot is a handler for creating a bot.
 func (server *Server) CreateBot(c *fiber.Ctx) error {
 	var body struct {
@@ -84,13 +186,15 @@ func (server *Server) CreateBot(c *fiber.Ctx) error {
 	if err != nil {
 		return fmt.Errorf("create bot: %w", err)
 	}
-	return c.JSON(BotResponse{
-		ID:          bot.ID,
-		Name:        bot.Name,
-		Description: bot.Description,
-		AccessKey:   bot.AccessKey,
-		CreatedAt:   bot.CreatedAt,
-	})
+	resp := server.newBotResponse(bot)
+	resp.AccessKey = bot.AccessKey
+	return c.JSON(resp)
+}
+
+// GetBot is a handler for getting a bot.
+func (server *Server) GetBot(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	return c.JSON(server.newBotResponse(bot))
 }
 
 // ListBots is a handler for listing all bots.
@@ -102,12 +206,7 @@ func (server *Server) ListBots(c *fiber.Ctx) error {
 	}
 	resp := make([]BotResponse, len(bots))
 	for i, bot := range bots {
-		resp[i] = BotResponse{
-			ID:          bot.ID,
-			Name:        bot.Name,
-			Description: bot.Description,
-			CreatedAt:   bot.CreatedAt,
-		}
+		resp[i] = server.newBotResponse(bot)
 	}
 	return c.JSON(fiber.Map{
 		"bots": resp,
@@ -127,49 +226,108 @@ func (server *Server) ReadBotMessages(c *fiber.Ctx) error {
 	if accessKey != bot.AccessKey {
 		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
 	}
+	if err := server.touchBot(bot); err != nil {
+		return err
+	}
 	rooms, err := server.db.GetRooms(context.TODO(), bot.ID)
 	if err != nil {
 		return fmt.Errorf("get rooms: %w", err)
 	}
 	var msgs []Message
-	var resp []MessageResponse
 	for _, room := range rooms {
 		ms, err := server.db.GetUnreadMessages(context.TODO(), room.ID, UserMessage)
 		if err != nil {
 			return fmt.Errorf("get unread messages: %w", err)
 		}
-		for _, msg := range ms {
-			resp = append(resp, MessageResponse{
-				ID:        msg.ID,
-				RoomID:    msg.RoomID,
-				Type:      msg.Type,
-				Text:      msg.Text,
-				CreatedAt: msg.CreatedAt,
-			})
-		}
 		msgs = append(msgs, ms...)
 	}
 	if !query.Peek && len(msgs) > 0 {
-		if err := server.db.ReadMessages(context.TODO(), msgs); err != nil {
-			return fmt.Errorf("read messages: %w", err)
+		if err := server.markRead(msgs); err != nil {
+			return err
 		}
 	}
+	var resp []MessageResponse
+	for _, msg := range msgs {
+		resp = append(resp, NewMessageResponse(msg))
+	}
 	return c.JSON(fiber.Map{
 		"messages": resp,
 	})
 }
 
+// MarkBotMessagesRead is a handler for marking messages sent to the bot as
+// read, so that the bot can peek messages and mark them as read after it has
+// handled them.
+func (server *Server) MarkBotMessagesRead(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	accessKey := c.Locals(AccessKeyLocalsKey).(string)
+	if accessKey != bot.AccessKey {
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
+	}
+	var body struct {
+		IDs []primitive.ObjectID `json:"ids"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if len(body.IDs) > MaxHistoryLimit {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("too many ids: at most %d", MaxHistoryLimit))
+	}
+	if err := server.touchBot(bot); err != nil {
+		return err
+	}
+	if len(body.IDs) == 0 {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	msgs, err := server.db.GetMessagesByIDs(context.TODO(), body.IDs)
+	if err != nil {
+		return fmt.Errorf("get messages: %w", err)
+	}
+	rooms, err := server.db.GetRooms(context.TODO(), bot.ID)
+	if err != nil {
+		return fmt.Errorf("get rooms: %w", err)
+	}
+	botRooms := make(map[primitive.ObjectID]bool, len(rooms))
+	for _, room := range rooms {
+		botRooms[room.ID] = true
+	}
+	var unread []Message
+	for _, msg := range msgs {
+		if botRooms[msg.RoomID] && msg.Type == UserMessage && !msg.Read {
+			unread = append(unread, msg)
+		}
+	}
+	if len(unread) > 0 {
+		if err := server.markRead(unread); err != nil {
+			return err
+		}
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
 type RoomResponse struct {
 	ID        primitive.ObjectID `json:"id"`
 	BotID     primitive.ObjectID `json:"botID"`
 	AccessKey string             `json:"accessKey,omitempty"`
+	Metadata  map[string]string  `json:"metadata,omitempty"`
 	CreatedAt time.Time          `json:"createdAt"`
 }
 
 // CreateRoom is a handler for creating a room.
 func (server *Server) CreateRoom(c *fiber.Ctx) error {
 	bot := c.Locals(BotLocalsKey).(Bot)
-	room, err := server.db.CreateRoom(context.TODO(), bot.ID)
+	var body struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+	if err := validateMetadata(body.Metadata); err != nil {
+		return err
+	}
+	room, err := server.db.CreateRoom(context.TODO(), bot.ID, body.Metadata)
 	if err != nil {
 		return fmt.Errorf("create room: %w", err)
 	}
@@ -177,10 +335,22 @@ func (server *Server) CreateRoom(c *fiber.Ctx) error {
 		ID:        room.ID,
 		BotID:     room.BotID,
 		AccessKey: room.AccessKey,
+		Metadata:  room.Metadata,
 		CreatedAt: room.CreatedAt,
 	})
 }
 
+// validateMetadata rejects metadata keys which MongoDB would take as
+// operators or paths when rooms are found by metadata.
+func validateMetadata(m map[string]string) error {
+	for k := range m {
+		if k == "" || strings.HasPrefix(k, "$") || strings.ContainsAny(k, ".\x00") {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("invalid metadata key %q: must not be empty, start with $ or contain dots", k))
+		}
+	}
+	return nil
+}
+
 // ListRooms is a handler for listing all rooms.
 func (server *Server) ListRooms(c *fiber.Ctx) error {
 	bot := c.Locals(BotLocalsKey).(Bot)
@@ -193,6 +363,7 @@ func (server *Server) ListRooms(c *fiber.Ctx) error {
 		resp[i] = RoomResponse{
 			ID:        room.ID,
 			BotID:     room.BotID,
+			Metadata:  room.Metadata,
 			CreatedAt: room.CreatedAt,
 		}
 	}
@@ -208,17 +379,73 @@ const (
 	UserClient = ClientType("user")
 )
 
+// MaxQuickReplies is the maximum number of quick replies of a message.
+const MaxQuickReplies = 10
+
+// ServerClientIDPrefix prefixes client IDs of messages written by the server
+// itself, like broadcasts and offline replies. Clients can't use it.
+const ServerClientIDPrefix = "easybot-"
+
 type MessageRequest struct {
-	RoomID primitive.ObjectID `json:"roomID"`
-	Text   string             `json:"text"`
+	RoomID       primitive.ObjectID `json:"roomID"`
+	Text         string             `json:"text"`
+	QuickReplies []string           `json:"quickReplies,omitempty"` // only bots may offer quick replies.
+	ClientID     string             `json:"clientID,omitempty"`     // if set, writing the message again returns the original one.
+	SendAt       *time.Time         `json:"sendAt,omitempty"`       // if set in the future, the message is scheduled.
 }
 
+type MessageStatus string
+
+// MessageStatus enumerations.
+const (
+	MessageDelivered = MessageStatus("delivered")
+	MessageRead      = MessageStatus("read")
+)
+
 type MessageResponse struct {
-	ID        primitive.ObjectID `json:"id"`
-	RoomID    primitive.ObjectID `json:"roomID"`
-	Type      MessageType        `json:"type"`
-	Text      string             `json:"text"`
-	CreatedAt time.Time          `json:"createdAt"`
+	ID           primitive.ObjectID `json:"id"`
+	RoomID       primitive.ObjectID `json:"roomID"`
+	Type         MessageType        `json:"type"`
+	Text         string             `json:"text"`
+	QuickReplies []string           `json:"quickReplies,omitempty"`
+	ClientID     string             `json:"clientID,omitempty"`
+	Seq          int64              `json:"seq"`
+	Status       MessageStatus      `json:"status"`
+	ReadAt       *time.Time         `json:"readAt,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt"`
+}
+
+// NewMessageResponse returns a MessageResponse for msg.
+func NewMessageResponse(msg Message) MessageResponse {
+	status := MessageDelivered
+	if msg.Read {
+		status = MessageRead
+	}
+	return MessageResponse{
+		ID:           msg.ID,
+		RoomID:       msg.RoomID,
+		Type:         msg.Type,
+		Text:         msg.Text,
+		QuickReplies: msg.QuickReplies,
+		ClientID:     msg.ClientID,
+		Seq:          msg.Seq,
+		Status:       status,
+		ReadAt:       msg.ReadAt,
+		CreatedAt:    msg.CreatedAt,
+	}
+}
+
+// markRead marks msgs as read now, updating msgs in place.
+func (server *Server) markRead(msgs []Message) error {
+	now := time.Now()
+	if err := server.db.ReadMessages(context.TODO(), msgs, now); err != nil {
+		return fmt.Errorf("read messages: %w", err)
+	}
+	for i := range msgs {
+		msgs[i].Read = true
+		msgs[i].ReadAt = &now
+	}
+	return nil
 }
 
 // ReadMessages is a handler for reading messages in a room.
@@ -246,19 +473,42 @@ func (server *Server) ReadMessages(c *fiber.Ctx) error {
 		return fmt.Errorf("get unread messages: %w", err)
 	}
 	if !query.Peek && len(msgs) > 0 {
-		if err := server.db.ReadMessages(context.TODO(), msgs); err != nil {
-			return fmt.Errorf("read messages: %w", err)
+		if err := server.markRead(msgs); err != nil {
+			return err
 		}
 	}
 	resp := make([]MessageResponse, len(msgs))
 	for i, msg := range msgs {
-		resp[i] = MessageResponse{
-			ID:        msg.ID,
-			RoomID:    msg.RoomID,
-			Type:      msg.Type,
-			Text:      msg.Text,
-			CreatedAt: msg.CreatedAt,
-		}
+		resp[i] = NewMessageResponse(msg)
+	}
+	return c.JSON(fiber.Map{
+		"messages": resp,
+	})
+}
+
+// ListSentMessages is a handler for listing messages sent by the client in a
+// room, along with their delivery status.
+// TODO: use pagination
+func (server *Server) ListSentMessages(c *fiber.Ctx) error {
+	room := c.Locals(RoomLocalsKey).(Room)
+	clientType := c.Locals(ClientTypeLocalsKey).(ClientType)
+	if clientType == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
+	}
+	var msgType MessageType
+	switch clientType {
+	case BotClient:
+		msgType = BotMessage
+	case UserClient:
+		msgType = UserMessage
+	}
+	msgs, err := server.db.GetMessages(context.TODO(), room.ID, msgType)
+	if err != nil {
+		return fmt.Errorf("get messages: %w", err)
+	}
+	resp := make([]MessageResponse, len(msgs))
+	for i, msg := range msgs {
+		resp[i] = NewMessageResponse(msg)
 	}
 	return c.JSON(fiber.Map{
 		"messages": resp,
@@ -266,6 +516,9 @@ func (server *Server) ReadMessages(c *fiber.Ctx) error {
 }
 
 // WriteMessages is a handler for writing messages in a room.
+// Messages with a client ID, either given explicitly or derived from the
+// Idempotency-Key header, are written only once; writing them again returns
+// the original messages.
 func (server *Server) WriteMessages(c *fiber.Ctx) error {
 	var body struct {
 		Messages []MessageRequest `json:"messages"`
@@ -278,42 +531,252 @@ func (server *Server) WriteMessages(c *fiber.Ctx) error {
 	if clientType == "" {
 		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
 	}
-	now := time.Now()
-	msgs := make([]Message, len(body.Messages))
+	var msgType MessageType
+	switch clientType {
+	case BotClient:
+		msgType = BotMessage
+	case UserClient:
+		msgType = UserMessage
+	}
+	idempotencyKey := c.Get(HeaderIdempotencyKey)
+	var clientIDs []string
 	for i := range body.Messages {
-		var msgType MessageType
-		switch clientType {
-		case BotClient:
-			msgType = BotMessage
-		case UserClient:
-			msgType = UserMessage
+		req := &body.Messages[i]
+		if len(req.QuickReplies) > 0 {
+			if clientType != BotClient {
+				return fiber.NewError(fiber.StatusBadRequest, "only bots can offer quick replies")
+			}
+			if len(req.QuickReplies) > MaxQuickReplies {
+				return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("too many quick replies: at most %d are allowed", MaxQuickReplies))
+			}
+		}
+		if req.ClientID == "" && idempotencyKey != "" {
+			req.ClientID = fmt.Sprintf("%s:%d", idempotencyKey, i)
+		}
+		if strings.HasPrefix(req.ClientID, ServerClientIDPrefix) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("client id %q is reserved: client ids must not start with %q", req.ClientID, ServerClientIDPrefix))
 		}
-		msgs[i] = Message{
-			RoomID:    room.ID,
-			Type:      msgType,
-			Text:      body.Messages[i].Text,
-			CreatedAt: now,
+		if req.ClientID != "" {
+			clientIDs = append(clientIDs, req.ClientID)
 		}
 	}
-	msgs, err := server.db.CreateMessages(context.TODO(), msgs)
+	written, err := server.writtenMessages(room, msgType, clientIDs)
 	if err != nil {
-		return fmt.Errorf("create messages: %w", err)
+		return err
+	}
+	now := time.Now()
+	var msgs []Message
+	var scheduled []ScheduledMessage
+	var created, createdScheduled int
+	for _, req := range body.Messages {
+		if msg, ok := written[req.ClientID]; ok {
+			switch msg := msg.(type) {
+			case Message:
+				msgs = append(msgs, msg)
+			case ScheduledMessage:
+				scheduled = append(scheduled, msg)
+			}
+			continue
+		}
+		if req.SendAt != nil && req.SendAt.After(now) {
+			if clientType != BotClient {
+				return fiber.NewError(fiber.StatusBadRequest, "only bots can schedule messages")
+			}
+			scheduled = append(scheduled, ScheduledMessage{
+				BotID:        room.BotID,
+				RoomID:       room.ID,
+				Type:         msgType,
+				Text:         req.Text,
+				QuickReplies: req.QuickReplies,
+				ClientID:     req.ClientID,
+				SendAt:       *req.SendAt,
+				CreatedAt:    now,
+			})
+			createdScheduled++
+			continue
+		}
+		msgs = append(msgs, Message{
+			RoomID:       room.ID,
+			Type:         msgType,
+			Text:         req.Text,
+			QuickReplies: req.QuickReplies,
+			ClientID:     req.ClientID,
+			CreatedAt:    now,
+		})
+		created++
+	}
+	if created > 0 {
+		var news []int
+		for i, msg := range msgs {
+			if msg.ID.IsZero() {
+				news = append(news, i)
+			}
+		}
+		if err := server.createNewMessages(room.BotID, msgs); err != nil {
+			return err
+		}
+		if clientType == UserClient {
+			bot := c.Locals(BotLocalsKey).(Bot)
+			if err := server.replyOffline(bot, room); err != nil {
+				return err
+			}
+			if bot.Script != "" {
+				scripted := make([]Message, len(news))
+				for i, idx := range news {
+					scripted[i] = msgs[idx]
+				}
+				server.runScripts(bot, room, scripted)
+			}
+		}
+	}
+	if createdScheduled > 0 {
+		if err := server.createNewScheduledMessages(scheduled); err != nil {
+			return err
+		}
 	}
 	resp := make([]MessageResponse, len(msgs))
 	for i, msg := range msgs {
-		resp[i] = MessageResponse{
-			ID:        msg.ID,
-			RoomID:    msg.RoomID,
-			Type:      msg.Type,
-			Text:      msg.Text,
-			CreatedAt: msg.CreatedAt,
-		}
+		resp[i] = NewMessageResponse(msg)
+	}
+	scheduledResp := make([]ScheduledMessageResponse, len(scheduled))
+	for i, msg := range scheduled {
+		scheduledResp[i] = NewScheduledMessageResponse(msg)
 	}
 	return c.JSON(fiber.Map{
-		"messages": resp,
+		"messages":  resp,
+		"scheduled": scheduledResp,
 	})
 }
 
+// writtenMessages returns already written messages and scheduled messages of
+// msgType in room with given client IDs, keyed by their client IDs.
+func (server *Server) writtenMessages(room Room, msgType MessageType, clientIDs []string) (map[string]interface{}, error) {
+	written := make(map[string]interface{})
+	if len(clientIDs) == 0 {
+		return written, nil
+	}
+	msgs, err := server.db.GetMessagesByClientIDs(context.TODO(), room.ID, msgType, clientIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get messages by client ids: %w", err)
+	}
+	for _, msg := range msgs {
+		written[msg.ClientID] = msg
+	}
+	scheduled, err := server.db.GetScheduledMessagesByClientIDs(context.TODO(), room.ID, msgType, clientIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get scheduled messages by client ids: %w", err)
+	}
+	for _, msg := range scheduled {
+		written[msg.ClientID] = msg
+	}
+	return written, nil
+}
+
+// createNewMessages creates messages in msgs which don't have IDs yet,
+// updating msgs in place.
+func (server *Server) createNewMessages(botID primitive.ObjectID, msgs []Message) error {
+	var idx []int
+	var news []Message
+	for i, msg := range msgs {
+		if msg.ID.IsZero() {
+			idx = append(idx, i)
+			news = append(news, msg)
+		}
+	}
+	news, err := server.createMessages(context.TODO(), botID, news)
+	if err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			return fiber.NewError(fiber.StatusConflict, "message with the same client id is being written")
+		}
+		return fmt.Errorf("create messages: %w", err)
+	}
+	for i, msg := range news {
+		msgs[idx[i]] = msg
+	}
+	return nil
+}
+
+// createNewScheduledMessages creates scheduled messages in msgs which don't
+// have IDs yet, updating msgs in place.
+func (server *Server) createNewScheduledMessages(msgs []ScheduledMessage) error {
+	var idx []int
+	var news []ScheduledMessage
+	for i, msg := range msgs {
+		if msg.ID.IsZero() {
+			idx = append(idx, i)
+			news = append(news, msg)
+		}
+	}
+	news, err := server.db.CreateScheduledMessages(context.TODO(), news)
+	if err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			return fiber.NewError(fiber.StatusConflict, "message with the same client id is being written")
+		}
+		return fmt.Errorf("create scheduled messages: %w", err)
+	}
+	for i, msg := range news {
+		msgs[idx[i]] = msg
+	}
+	return nil
+}
+
+// replyOffline posts the configured offline reply into room if bot has been
+// offline for too long, once until the bot is seen again.
+func (server *Server) replyOffline(bot Bot, room Room) error {
+	cfg := server.cfg.Presence
+	if cfg.OfflineReply == "" {
+		return nil
+	}
+	after := cfg.OfflineReplyAfter
+	if after == 0 {
+		after = cfg.OfflineAfter
+	}
+	if botOnline(bot, after) {
+		return nil
+	}
+	// The client ID names the offline period, so that the reply is posted
+	// once per period rather than on every message.
+	if _, err := server.createMessages(context.TODO(), bot.ID, []Message{{
+		RoomID:    room.ID,
+		Type:      BotMessage,
+		Text:      cfg.OfflineReply,
+		ClientID:  ServerClientIDPrefix + "offline-" + bot.LastSeenAt.UTC().Format(time.RFC3339Nano),
+		CreatedAt: time.Now(),
+	}}); err != nil && !errors.Is(err, ErrDuplicate) {
+		return fmt.Errorf("create offline reply: %w", err)
+	}
+	return nil
+}
+
+// importPath is the path of the import endpoint, whose body is streamed by
+// ImportData under its own limit.
+const importPath = "/v1/admin/import"
+
+// BodyLimitMiddleware rejects request bodies larger than the body limit of
+// the fiber config, except for imports.
+func (server *Server) BodyLimitMiddleware(c *fiber.Ctx) error {
+	if c.Path() == importPath {
+		return c.Next()
+	}
+	limit := server.cfg.Fiber.BodyLimit
+	n := c.Request().Header.ContentLength()
+	if n > limit {
+		return fiber.ErrRequestEntityTooLarge
+	}
+	// A chunked body has no length, so read it up to the limit.
+	if stream := c.Context().RequestBodyStream(); n < 0 && stream != nil {
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("read body: %v", err))
+		}
+		if len(body) > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		c.Request().SetBody(body)
+	}
+	return c.Next()
+}
+
 func (server *Server) AccessKeyMiddleware(c *fiber.Ctx) error {
 	var hdr struct {
 		AccessKey string `reqHeader:"X-Access-Key"`
@@ -332,7 +795,7 @@ func (server *Server) BotMiddleware(c *fiber.Ctx) error {
 	}
 	bot, err := server.db.GetBot(context.TODO(), botID)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
+		if errors.Is(err, ErrNotFound) {
 			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("bot %s not found", botID))
 		}
 		return fmt.Errorf("get bot: %w", err)
@@ -341,6 +804,15 @@ func (server *Server) BotMiddleware(c *fiber.Ctx) error {
 	return c.Next()
 }
 
+// BotAccessMiddleware is a middleware which allows only the bot itself.
+func (server *Server) BotAccessMiddleware(c *fiber.Ctx) error {
+	bot := c.Locals(BotLocalsKey).(Bot)
+	if c.Locals(AccessKeyLocalsKey).(string) != bot.AccessKey {
+		return fiber.NewError(fiber.StatusUnauthorized, "unauthorized")
+	}
+	return c.Next()
+}
+
 // RoomMiddleware is a middleware for a room.
 func (server *Server) RoomMiddleware(c *fiber.Ctx) error {
 	bot := c.Locals(BotLocalsKey).(Bot)
@@ -350,7 +822,7 @@ func (server *Server) RoomMiddleware(c *fiber.Ctx) error {
 	}
 	room, err := server.db.GetRoom(context.TODO(), roomID)
 	if err != nil || room.BotID != bot.ID {
-		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		if err != nil && !errors.Is(err, ErrNotFound) {
 			return fmt.Errorf("get room: %w", err)
 		}
 		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("room %s not found", roomID))
@@ -366,19 +838,12 @@ func (server *Server) ClientTypeMiddleware(c *fiber.Ctx) error {
 	var clientType ClientType
 	if accessKey == bot.AccessKey {
 		clientType = BotClient
+		if err := server.touchBot(bot); err != nil {
+			return err
+		}
 	} else if accessKey == room.AccessKey {
 		clientType = UserClient
 	}
 	c.Locals(ClientTypeLocalsKey, clientType)
 	return c.Next()
 }
-
-// ErrorHandler is an error handler which returns JSON formatted error message.
-func ErrorHandler(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
-	if e, ok := err.(*fiber.Error); ok {
-		code = e.Code
-	}
-	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
-	return c.Status(code).JSON(fiber.Map{"message": err.Error()})
-}
@@ -0,0 +1,160 @@
+package easybot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StateResponse struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   int64           `json:"version"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// NewStateResponse returns a StateResponse for state.
+func NewStateResponse(state State) StateResponse {
+	return StateResponse{
+		Key:       state.Key,
+		Value:     json.RawMessage(state.Value),
+		Version:   state.Version,
+		UpdatedAt: state.UpdatedAt,
+	}
+}
+
+// ETag returns the entity tag of a state version.
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// stateOwner returns the scope and the owner ID of states for the request,
+// which is the room if the route has one, or the bot.
+func stateOwner(c *fiber.Ctx) (StateScope, primitive.ObjectID) {
+	if room, ok := c.Locals(RoomLocalsKey).(Room); ok {
+		return RoomState, room.ID
+	}
+	return BotState, c.Locals(BotLocalsKey).(Bot).ID
+}
+
+func stateKey(c *fiber.Ctx) (string, error) {
+	// Copy the key, since fiber's values are only valid within the handler.
+	key, err := url.PathUnescape(utils.CopyString(c.Params("key")))
+	if err != nil || key == "" {
+		return "", fiber.NewError(fiber.StatusBadRequest, "invalid key")
+	}
+	return key, nil
+}
+
+// ifMatchVersion returns the version required by the If-Match or
+// If-None-Match header, or nil if the request is unconditional.
+func ifMatchVersion(c *fiber.Ctx) (*int64, error) {
+	if c.Get(fiber.HeaderIfNoneMatch) == "*" {
+		var v int64
+		return &v, nil
+	}
+	tag := c.Get(fiber.HeaderIfMatch)
+	if tag == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(tag, "W/"), `"`), 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "invalid If-Match header")
+	}
+	return &v, nil
+}
+
+// ListStates is a handler for listing states of a bot or a room.
+// TODO: use pagination
+func (server *Server) ListStates(c *fiber.Ctx) error {
+	scope, ownerID := stateOwner(c)
+	states, err := server.db.GetStates(context.TODO(), scope, ownerID)
+	if err != nil {
+		return fmt.Errorf("get states: %w", err)
+	}
+	resp := make([]StateResponse, len(states))
+	for i, state := range states {
+		resp[i] = NewStateResponse(state)
+	}
+	return c.JSON(fiber.Map{
+		"states": resp,
+	})
+}
+
+// GetState is a handler for getting a state.
+func (server *Server) GetState(c *fiber.Ctx) error {
+	scope, ownerID := stateOwner(c)
+	key, err := stateKey(c)
+	if err != nil {
+		return err
+	}
+	state, err := server.db.GetState(context.TODO(), scope, ownerID, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return NewError(fiber.StatusNotFound, ErrCodeStateNotFound, fmt.Sprintf("state %s not found", key))
+		}
+		return fmt.Errorf("get state: %w", err)
+	}
+	c.Set(fiber.HeaderETag, ETag(state.Version))
+	return c.JSON(NewStateResponse(state))
+}
+
+// PutState is a handler for setting a state. The request body is the JSON
+// encoded value. The update can be made conditional with If-Match, or with
+// If-None-Match: * to create the state only if it doesn't exist.
+func (server *Server) PutState(c *fiber.Ctx) error {
+	scope, ownerID := stateOwner(c)
+	key, err := stateKey(c)
+	if err != nil {
+		return err
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(c.Body()) {
+		return fiber.NewError(fiber.StatusBadRequest, "value must be valid JSON")
+	}
+	state, err := server.db.PutState(context.TODO(), scope, ownerID, key, string(c.Body()), version)
+	if err != nil {
+		if errors.Is(err, ErrVersionMismatch) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, fmt.Sprintf("state %s has been modified", key))
+		}
+		return fmt.Errorf("put state: %w", err)
+	}
+	c.Set(fiber.HeaderETag, ETag(state.Version))
+	return c.JSON(NewStateResponse(state))
+}
+
+// DeleteState is a handler for deleting a state. The deletion can be made
+// conditional with If-Match.
+func (server *Server) DeleteState(c *fiber.Ctx) error {
+	scope, ownerID := stateOwner(c)
+	key, err := stateKey(c)
+	if err != nil {
+		return err
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	if err := server.db.DeleteState(context.TODO(), scope, ownerID, key, version); err != nil {
+		switch {
+		case errors.Is(err, ErrVersionMismatch):
+			return fiber.NewError(fiber.StatusPreconditionFailed, fmt.Sprintf("state %s has been modified", key))
+		case errors.Is(err, ErrNotFound):
+			return NewError(fiber.StatusNotFound, ErrCodeStateNotFound, fmt.Sprintf("state %s not found", key))
+		}
+		return fmt.Errorf("delete state: %w", err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package easybot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store errors.
+var (
+	// ErrNotFound is returned when there's no such document.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when a document violates a unique constraint,
+	// such as a client ID of a message.
+	ErrDuplicate = errors.New("duplicate")
+	// ErrVersionMismatch is returned when a state's version doesn't match the
+	// expected one.
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// Store is the storage of a Server. DB stores in MongoDB and MemoryStore
+// stores in memory.
+type Store interface {
+	CreateBot(ctx context.Context, name, desc string) (Bot, error)
+	GetBot(ctx context.Context, id primitive.ObjectID) (Bot, error)
+	GetBots(ctx context.Context) ([]Bot, error)
+	TouchBot(ctx context.Context, id primitive.ObjectID, t time.Time) error
+	SetBotScript(ctx context.Context, id primitive.ObjectID, script string) error
+	PutBots(ctx context.Context, bots []Bot) error
+
+	CreateRoom(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) (Room, error)
+	GetRoom(ctx context.Context, id primitive.ObjectID) (Room, error)
+	GetRooms(ctx context.Context, botID primitive.ObjectID) ([]Room, error)
+	FindRooms(ctx context.Context, botID primitive.ObjectID, metadata map[string]string) ([]Room, error)
+	PutRooms(ctx context.Context, rooms []Room) error
+
+	CreateMessages(ctx context.Context, msgs []Message) ([]Message, error)
+	GetMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]Message, error)
+	GetUnreadMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error)
+	GetMessages(ctx context.Context, roomID primitive.ObjectID, msgType MessageType) ([]Message, error)
+	GetMessagesAfter(ctx context.Context, roomIDs []primitive.ObjectID, after, until, limit int64) ([]Message, error)
+	GetLastMessages(ctx context.Context, roomIDs []primitive.ObjectID, until, limit int64) ([]Message, error)
+	GetLastMessageSeq(ctx context.Context) (int64, error)
+	GetFirstMessageSince(ctx context.Context, roomIDs []primitive.ObjectID, t time.Time) (Message, error)
+	ReadMessages(ctx context.Context, msgs []Message, readAt time.Time) error
+	GetMessagesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]Message, error)
+	PutMessages(ctx context.Context, msgs []Message) error
+
+	CreateBroadcast(ctx context.Context, b Broadcast) (Broadcast, error)
+	UpdateBroadcast(ctx context.Context, b Broadcast) error
+	GetBroadcast(ctx context.Context, id primitive.ObjectID) (Broadcast, error)
+	GetBroadcasts(ctx context.Context, botID primitive.ObjectID) ([]Broadcast, error)
+	GetUnfinishedBroadcasts(ctx context.Context) ([]Broadcast, error)
+
+	CreateScheduledMessages(ctx context.Context, msgs []ScheduledMessage) ([]ScheduledMessage, error)
+	GetScheduledMessages(ctx context.Context, botID, roomID primitive.ObjectID) ([]ScheduledMessage, error)
+	GetScheduledMessagesByClientIDs(ctx context.Context, roomID primitive.ObjectID, msgType MessageType, clientIDs []string) ([]ScheduledMessage, error)
+	DeleteScheduledMessage(ctx context.Context, roomID, id primitive.ObjectID) error
+	GetDueScheduledMessages(ctx context.Context, t time.Time, limit int64) ([]ScheduledMessage, error)
+
+	GetState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string) (State, error)
+	GetStates(ctx context.Context, scope StateScope, ownerID primitive.ObjectID) ([]State, error)
+	PutState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key, value string, version *int64) (State, error)
+	DeleteState(ctx context.Context, scope StateScope, ownerID primitive.ObjectID, key string, version *int64) error
+
+	CreateScriptLogs(ctx context.Context, logs []ScriptLog) error
+	GetScriptLogs(ctx context.Context, botID, roomID primitive.ObjectID, limit int64) ([]ScriptLog, error)
+}
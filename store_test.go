@@ -0,0 +1,244 @@
+package easybot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// envTestMongoURI is the environment variable of a MongoDB URI to run the
+// store tests against DB, too. A database is created and dropped for each
+// test.
+const envTestMongoURI = "EASYBOT_TEST_MONGODB_URI"
+
+// testStores runs f against each Store implementation.
+func testStores(t *testing.T, f func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		f(t, NewMemoryStore())
+	})
+	t.Run("mongodb", func(t *testing.T) {
+		uri := os.Getenv(envTestMongoURI)
+		if uri == "" {
+			t.Skipf("%s is not set", envTestMongoURI)
+		}
+		ctx := context.Background()
+		db, err := NewDB(ctx, DBConfig{
+			URI:      uri,
+			Database: fmt.Sprintf("easybot_test_%s", primitive.NewObjectID().Hex()),
+		})
+		if err != nil {
+			t.Fatalf("NewDB: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := db.Database().Drop(ctx); err != nil {
+				t.Errorf("drop database: %v", err)
+			}
+			db.Close()
+		})
+		f(t, db)
+	})
+}
+
+// createRoom creates a bot and a room of it.
+func createRoom(t *testing.T, store Store) Room {
+	t.Helper()
+	ctx := context.Background()
+	bot, err := store.CreateBot(ctx, "bot", "")
+	if err != nil {
+		t.Fatalf("CreateBot: %v", err)
+	}
+	room, err := store.CreateRoom(ctx, bot.ID, nil)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	return room
+}
+
+func TestStoreDuplicateClientID(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		room := createRoom(t, store)
+		other := createRoom(t, store)
+		msg := func(roomID primitive.ObjectID, clientID string, seq int64) []Message {
+			return []Message{{RoomID: roomID, Type: UserMessage, Text: "hi", ClientID: clientID, Seq: seq, CreatedAt: time.Now()}}
+		}
+
+		if _, err := store.CreateMessages(ctx, msg(room.ID, "a", 1)); err != nil {
+			t.Fatalf("CreateMessages: %v", err)
+		}
+		if _, err := store.CreateMessages(ctx, msg(room.ID, "a", 2)); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("CreateMessages with a duplicate client ID: err = %v, want ErrDuplicate", err)
+		}
+		if _, err := store.CreateMessages(ctx, msg(other.ID, "a", 3)); err != nil {
+			t.Errorf("CreateMessages with the client ID in another room: %v", err)
+		}
+		// Messages without client IDs never collide.
+		for seq := int64(4); seq <= 5; seq++ {
+			if _, err := store.CreateMessages(ctx, msg(room.ID, "", seq)); err != nil {
+				t.Errorf("CreateMessages without a client ID: %v", err)
+			}
+		}
+
+		// Client IDs of bots and users don't collide either.
+		reply := msg(room.ID, "a", 6)
+		reply[0].Type = BotMessage
+		if _, err := store.CreateMessages(ctx, reply); err != nil {
+			t.Errorf("CreateMessages with the client ID of another type: %v", err)
+		}
+
+		msgs, err := store.GetMessagesByClientIDs(ctx, room.ID, UserMessage, []string{"a", "b"})
+		if err != nil {
+			t.Fatalf("GetMessagesByClientIDs: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].ClientID != "a" || msgs[0].Seq != 1 {
+			t.Errorf("GetMessagesByClientIDs = %+v, want the first message", msgs)
+		}
+		if msgs, err = store.GetMessagesByClientIDs(ctx, room.ID, BotMessage, []string{"a"}); err != nil {
+			t.Fatalf("GetMessagesByClientIDs of bot messages: %v", err)
+		}
+		if len(msgs) != 1 || msgs[0].Seq != 6 {
+			t.Errorf("GetMessagesByClientIDs of bot messages = %+v, want the reply", msgs)
+		}
+	})
+}
+
+func TestStoreStateVersions(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		room := createRoom(t, store)
+		version := func(v int64) *int64 { return &v }
+
+		if _, err := store.GetState(ctx, RoomState, room.ID, "k"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetState of a missing state: err = %v, want ErrNotFound", err)
+		}
+		if _, err := store.PutState(ctx, RoomState, room.ID, "k", `"a"`, version(1)); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("PutState of a missing state at version 1: err = %v, want ErrVersionMismatch", err)
+		}
+		st, err := store.PutState(ctx, RoomState, room.ID, "k", `"a"`, version(0))
+		if err != nil {
+			t.Fatalf("PutState at version 0: %v", err)
+		}
+		if st.Version != 1 || st.Value != `"a"` {
+			t.Errorf("PutState at version 0 = %+v, want version 1", st)
+		}
+		if _, err := store.PutState(ctx, RoomState, room.ID, "k", `"b"`, version(0)); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("PutState of an existing state at version 0: err = %v, want ErrVersionMismatch", err)
+		}
+		if st, err = store.PutState(ctx, RoomState, room.ID, "k", `"b"`, version(1)); err != nil {
+			t.Fatalf("PutState at version 1: %v", err)
+		}
+		if st.Version != 2 || st.Value != `"b"` {
+			t.Errorf("PutState at version 1 = %+v, want version 2", st)
+		}
+		if st, err = store.PutState(ctx, RoomState, room.ID, "k", `"c"`, nil); err != nil {
+			t.Fatalf("PutState without a version: %v", err)
+		}
+		if st.Version != 3 {
+			t.Errorf("PutState without a version = %+v, want version 3", st)
+		}
+		if st, err = store.PutState(ctx, RoomState, room.ID, "new", `1`, nil); err != nil {
+			t.Fatalf("PutState of a new state without a version: %v", err)
+		}
+		if st.Version != 1 {
+			t.Errorf("PutState of a new state without a version = %+v, want version 1", st)
+		}
+		// States are scoped by owner.
+		if _, err := store.GetState(ctx, BotState, room.ID, "k"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetState of another scope: err = %v, want ErrNotFound", err)
+		}
+
+		if err := store.DeleteState(ctx, RoomState, room.ID, "k", version(2)); !errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("DeleteState at a stale version: err = %v, want ErrVersionMismatch", err)
+		}
+		if err := store.DeleteState(ctx, RoomState, room.ID, "k", version(3)); err != nil {
+			t.Errorf("DeleteState at version 3: %v", err)
+		}
+		if err := store.DeleteState(ctx, RoomState, room.ID, "k", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteState of a missing state: err = %v, want ErrNotFound", err)
+		}
+		states, err := store.GetStates(ctx, RoomState, room.ID)
+		if err != nil {
+			t.Fatalf("GetStates: %v", err)
+		}
+		if len(states) != 1 || states[0].Key != "new" {
+			t.Errorf("GetStates = %+v, want only the new state", states)
+		}
+	})
+}
+
+func TestStoreGetMessagesAfter(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		room := createRoom(t, store)
+		other := createRoom(t, store)
+		// Create messages out of the order of their sequence numbers, the way
+		// concurrent requests may.
+		for _, m := range []struct {
+			room primitive.ObjectID
+			seq  int64
+		}{
+			{room.ID, 2}, {room.ID, 1}, {other.ID, 3}, {room.ID, 5}, {room.ID, 4}, {other.ID, 6},
+		} {
+			if _, err := store.CreateMessages(ctx, []Message{{
+				RoomID:    m.room,
+				Type:      UserMessage,
+				Text:      fmt.Sprint(m.seq),
+				Seq:       m.seq,
+				CreatedAt: time.Now(),
+			}}); err != nil {
+				t.Fatalf("CreateMessages: %v", err)
+			}
+		}
+		seqs := func(msgs []Message) []int64 {
+			res := []int64{}
+			for _, msg := range msgs {
+				res = append(res, msg.Seq)
+			}
+			return res
+		}
+		both := []primitive.ObjectID{room.ID, other.ID}
+		for _, tc := range []struct {
+			name         string
+			rooms        []primitive.ObjectID
+			after, until int64
+			limit        int64
+			want         []int64
+		}{
+			{"all", both, 0, 100, 100, []int64{1, 2, 3, 4, 5, 6}},
+			{"room", []primitive.ObjectID{room.ID}, 0, 100, 100, []int64{1, 2, 4, 5}},
+			{"after", both, 2, 100, 100, []int64{3, 4, 5, 6}},
+			{"until", both, 0, 4, 100, []int64{1, 2, 3, 4}},
+			{"limit", both, 1, 100, 2, []int64{2, 3}},
+			{"none", both, 6, 100, 100, []int64{}},
+			{"no rooms", []primitive.ObjectID{}, 0, 100, 100, []int64{}},
+		} {
+			msgs, err := store.GetMessagesAfter(ctx, tc.rooms, tc.after, tc.until, tc.limit)
+			if err != nil {
+				t.Fatalf("%s: GetMessagesAfter: %v", tc.name, err)
+			}
+			if got := seqs(msgs); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("%s: GetMessagesAfter seqs = %v, want %v", tc.name, got, tc.want)
+			}
+		}
+
+		msgs, err := store.GetLastMessages(ctx, []primitive.ObjectID{room.ID}, 4, 2)
+		if err != nil {
+			t.Fatalf("GetLastMessages: %v", err)
+		}
+		if got, want := seqs(msgs), []int64{2, 4}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("GetLastMessages seqs = %v, want %v", got, want)
+		}
+		last, err := store.GetLastMessageSeq(ctx)
+		if err != nil {
+			t.Fatalf("GetLastMessageSeq: %v", err)
+		}
+		if last != 6 {
+			t.Errorf("GetLastMessageSeq = %d, want 6", last)
+		}
+	})
+}
@@ -0,0 +1,366 @@
+package easybot
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TranscriptFormat string
+
+// TranscriptFormat enumerations.
+const (
+	TranscriptMarkdown = TranscriptFormat("markdown")
+	TranscriptHTML     = TranscriptFormat("html")
+	TranscriptText     = TranscriptFormat("text")
+)
+
+// Ext returns the file extension of the format, with the dot.
+func (f TranscriptFormat) Ext() string {
+	switch f {
+	case TranscriptHTML:
+		return ".html"
+	case TranscriptText:
+		return ".txt"
+	}
+	return ".md"
+}
+
+// contentType returns the MIME type of the format.
+func (f TranscriptFormat) contentType() string {
+	switch f {
+	case TranscriptHTML:
+		return fiber.MIMETextHTMLCharsetUTF8
+	case TranscriptText:
+		return fiber.MIMETextPlainCharsetUTF8
+	}
+	return "text/markdown; charset=utf-8"
+}
+
+// Transcript is the history of a room, to be read by humans.
+type Transcript struct {
+	Bot      Bot
+	Room     Room
+	Messages []Message      // oldest first.
+	Location *time.Location // of timestamps; UTC if nil.
+}
+
+// UserLabel returns the speaker label of the room's user: the name in the
+// room's metadata if any, or "User".
+func (t Transcript) UserLabel() string {
+	if name := t.Room.Metadata["name"]; name != "" {
+		return name
+	}
+	return "User"
+}
+
+// Speaker returns the speaker label of msg.
+func (t Transcript) Speaker(msg Message) string {
+	if msg.Type == BotMessage {
+		return t.Bot.Name
+	}
+	return t.UserLabel()
+}
+
+// Time returns tm in the transcript's location.
+func (t Transcript) Time(tm time.Time) time.Time {
+	if t.Location == nil {
+		return tm.UTC()
+	}
+	return tm.In(t.Location)
+}
+
+// WriteTranscript renders t in the format.
+func WriteTranscript(w io.Writer, format TranscriptFormat, t Transcript) error {
+	switch format {
+	case TranscriptMarkdown:
+		return writeTranscriptMarkdown(w, t)
+	case TranscriptHTML:
+		return transcriptHTML.Execute(w, t)
+	case TranscriptText:
+		return writeTranscriptText(w, t)
+	}
+	return fmt.Errorf("unknown transcript format %q", format)
+}
+
+// NewDay reports whether the i-th message is the first of its day.
+func (t Transcript) NewDay(i int) bool {
+	if i == 0 {
+		return true
+	}
+	y1, m1, d1 := t.Time(t.Messages[i-1].CreatedAt).Date()
+	y2, m2, d2 := t.Time(t.Messages[i].CreatedAt).Date()
+	return y1 != y2 || m1 != m2 || d1 != d2
+}
+
+// Summary returns lines describing the conversation.
+func (t Transcript) Summary() []string {
+	lines := []string{
+		fmt.Sprintf("Bot: %s (%s)", t.Bot.Name, t.Bot.ID.Hex()),
+		fmt.Sprintf("Room: %s", t.Room.ID.Hex()),
+	}
+	if len(t.Room.Metadata) > 0 {
+		lines = append(lines, "Metadata: "+formatMetadata(t.Room.Metadata))
+	}
+	if n := len(t.Messages); n > 0 {
+		first, last := t.Time(t.Messages[0].CreatedAt), t.Time(t.Messages[n-1].CreatedAt)
+		lines = append(lines, fmt.Sprintf("Messages: %d, from %s to %s", n,
+			first.Format("2006-01-02 15:04:05"), last.Format("2006-01-02 15:04:05 MST")))
+	} else {
+		lines = append(lines, "Messages: none")
+	}
+	return lines
+}
+
+func formatMetadata(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + m[k]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeTranscriptText(w io.Writer, t Transcript) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Conversation with %s\n", t.Bot.Name)
+	for _, line := range t.Summary() {
+		fmt.Fprintf(&b, "%s\n", line)
+	}
+	for i, msg := range t.Messages {
+		tm := t.Time(msg.CreatedAt)
+		if t.NewDay(i) {
+			fmt.Fprintf(&b, "\n--- %s ---\n", tm.Format("Monday, January 2, 2006"))
+		}
+		prefix := fmt.Sprintf("[%s] %s: ", tm.Format("15:04:05"), t.Speaker(msg))
+		indent := strings.Repeat(" ", len([]rune(prefix)))
+		lines := strings.Split(strings.TrimRight(msg.Text, "\n"), "\n")
+		fmt.Fprintf(&b, "%s%s\n", prefix, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(&b, "%s%s\n", indent, line)
+		}
+		if len(msg.QuickReplies) > 0 {
+			fmt.Fprintf(&b, "%s[%s]\n", indent, strings.Join(msg.QuickReplies, "] ["))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownEscaper escapes characters with meanings in Markdown.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+func writeTranscriptMarkdown(w io.Writer, t Transcript) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Conversation with %s\n\n", markdownEscaper.Replace(t.Bot.Name))
+	for _, line := range t.Summary() {
+		fmt.Fprintf(&b, "- %s\n", markdownEscaper.Replace(line))
+	}
+	for i, msg := range t.Messages {
+		tm := t.Time(msg.CreatedAt)
+		if t.NewDay(i) {
+			fmt.Fprintf(&b, "\n## %s\n", tm.Format("Monday, January 2, 2006"))
+		}
+		fmt.Fprintf(&b, "\n**%s** · %s\n", markdownEscaper.Replace(t.Speaker(msg)), tm.Format("15:04:05"))
+		for _, line := range strings.Split(strings.TrimRight(msg.Text, "\n"), "\n") {
+			// Quote messages, keeping their line breaks.
+			fmt.Fprintf(&b, "> %s  \n", markdownEscaper.Replace(line))
+		}
+		if len(msg.QuickReplies) > 0 {
+			replies := make([]string, len(msg.QuickReplies))
+			for i, r := range msg.QuickReplies {
+				replies[i] = "`" + strings.ReplaceAll(r, "`", "'") + "`"
+			}
+			fmt.Fprintf(&b, ">\n> Quick replies: %s\n", strings.Join(replies, " "))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var transcriptHTML = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(strings.TrimRight(s, "\n"), "\n") },
+	"isBot": func(msg Message) bool { return msg.Type == BotMessage },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Conversation with {{.Bot.Name}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; color: #222; }
+.summary { color: #666; font-size: .9em; }
+.day { text-align: center; color: #888; font-size: .85em; margin: 1.5em 0 .5em; }
+.msg { display: flex; flex-direction: column; margin: .5em 0; }
+.msg.user { align-items: flex-end; }
+.msg.bot { align-items: flex-start; }
+.meta { font-size: .75em; color: #888; margin: 0 .5em .15em; }
+.bubble { max-width: 75%; padding: .5em .8em; border-radius: 1em; white-space: pre-wrap; word-wrap: break-word; }
+.user .bubble { background: #0b7cff; color: #fff; }
+.bot .bubble { background: #eee; }
+.replies { margin-top: .3em; }
+.replies span { display: inline-block; border: 1px solid #0b7cff; color: #0b7cff; border-radius: 1em; padding: .1em .6em; margin: .1em; font-size: .85em; }
+</style>
+</head>
+<body>
+<h1>Conversation with {{.Bot.Name}}</h1>
+<ul class="summary">{{range .Summary}}<li>{{.}}</li>{{end}}</ul>
+{{- $t := .}}
+{{range $i, $msg := .Messages}}
+{{- if $t.NewDay $i}}
+<div class="day">{{($t.Time $msg.CreatedAt).Format "Monday, January 2, 2006"}}</div>
+{{- end}}
+<div class="msg {{if isBot $msg}}bot{{else}}user{{end}}">
+<div class="meta">{{$t.Speaker $msg}} · <time datetime="{{($t.Time $msg.CreatedAt).Format "2006-01-02T15:04:05Z07:00"}}">{{($t.Time $msg.CreatedAt).Format "15:04:05"}}</time></div>
+<div class="bubble">{{$msg.Text}}</div>
+{{- if $msg.QuickReplies}}
+<div class="replies">{{range $msg.QuickReplies}}<span>{{.}}</span>{{end}}</div>
+{{- end}}
+</div>
+{{- end}}
+</body>
+</html>
+`))
+
+// GetTranscript is a handler for rendering the room's history for humans,
+// in the format query: markdown (the default), html or text. Timestamps are
+// in the time zone of the tz query, UTC by default.
+func (server *Server) GetTranscript(c *fiber.Ctx) error {
+	if err := requireClient(c); err != nil {
+		return err
+	}
+	format, loc, err := transcriptQuery(c)
+	if err != nil {
+		return err
+	}
+	bot := c.Locals(BotLocalsKey).(Bot)
+	room := c.Locals(RoomLocalsKey).(Room)
+	msgs, err := server.allMessages(context.TODO(), []primitive.ObjectID{room.ID})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := WriteTranscript(&buf, format, Transcript{Bot: bot, Room: room, Messages: msgs, Location: loc}); err != nil {
+		return fmt.Errorf("write transcript: %w", err)
+	}
+	c.Set(fiber.HeaderContentType, format.contentType())
+	return c.Send(buf.Bytes())
+}
+
+// ExportTranscripts is a handler for a zip archive of transcripts of every
+// room of the bot, named by room IDs. It takes the queries of GetTranscript.
+func (server *Server) ExportTranscripts(c *fiber.Ctx) error {
+	format, loc, err := transcriptQuery(c)
+	if err != nil {
+		return err
+	}
+	bot := c.Locals(BotLocalsKey).(Bot)
+	rooms, err := server.db.GetRooms(context.TODO(), bot.ID)
+	if err != nil {
+		return fmt.Errorf("get rooms: %w", err)
+	}
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="transcripts-%s.zip"`, bot.ID.Hex()))
+	// Stream the archive a room at a time, so that memory doesn't grow with
+	// the bot's history. The status is sent by then, so an error can only
+	// cut the archive short, which leaves it without its central directory.
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := server.writeTranscripts(w, bot, rooms, format, loc); err != nil {
+			log.Printf("write transcripts of bot %s: %v", bot.ID.Hex(), err)
+		}
+	})
+	return nil
+}
+
+// writeTranscripts writes a zip archive of the transcripts of rooms to w.
+func (server *Server) writeTranscripts(w *bufio.Writer, bot Bot, rooms []Room, format TranscriptFormat, loc *time.Location) error {
+	zw := zip.NewWriter(w)
+	for _, room := range rooms {
+		msgs, err := server.allMessages(context.TODO(), []primitive.ObjectID{room.ID})
+		if err != nil {
+			return err
+		}
+		modified := room.CreatedAt
+		if len(msgs) > 0 {
+			modified = msgs[len(msgs)-1].CreatedAt
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     room.ID.Hex() + format.Ext(),
+			Method:   zip.Deflate,
+			Modified: modified,
+		})
+		if err != nil {
+			return fmt.Errorf("create zip entry: %w", err)
+		}
+		if err := WriteTranscript(fw, format, Transcript{Bot: bot, Room: room, Messages: msgs, Location: loc}); err != nil {
+			return fmt.Errorf("write transcript: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close zip: %w", err)
+	}
+	return w.Flush()
+}
+
+// transcriptQuery parses the format and tz queries of the transcript
+// endpoints.
+func transcriptQuery(c *fiber.Ctx) (TranscriptFormat, *time.Location, error) {
+	var query struct {
+		Format string `query:"format"`
+		TZ     string `query:"tz"`
+	}
+	if err := c.QueryParser(&query); err != nil {
+		return "", nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	format := TranscriptFormat(query.Format)
+	switch format {
+	case "":
+		format = TranscriptMarkdown
+	case TranscriptMarkdown, TranscriptHTML, TranscriptText:
+	default:
+		return "", nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown format %q: must be one of markdown, html, text", query.Format))
+	}
+	loc := time.UTC
+	if query.TZ != "" {
+		var err error
+		if loc, err = time.LoadLocation(query.TZ); err != nil {
+			return "", nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown time zone %q", query.TZ))
+		}
+	}
+	return format, loc, nil
+}
+
+// allMessages returns all messages of both types in rooms, oldest first.
+func (server *Server) allMessages(ctx context.Context, roomIDs []primitive.ObjectID) ([]Message, error) {
+	var all []Message
+	var after int64
+	for {
+		msgs, err := server.db.GetMessagesAfter(ctx, roomIDs, after, math.MaxInt64, MaxHistoryLimit)
+		if err != nil {
+			return nil, fmt.Errorf("get messages: %w", err)
+		}
+		all = append(all, msgs...)
+		if len(msgs) < MaxHistoryLimit {
+			return all, nil
+		}
+		after = msgs[len(msgs)-1].Seq
+	}
+}
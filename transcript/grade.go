@@ -0,0 +1,275 @@
+package transcript
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/hallazzang/easybot/client"
+)
+
+// DefaultConcurrency is the number of bots graded at the same time when the
+// rubric doesn't say.
+const DefaultConcurrency = 4
+
+// Rubric is a suite of transcripts with weights, to grade bots with:
+//
+//	time_limit: 2m
+//	cases:
+//	  - transcript: greeting.yml
+//	    weight: 1
+//	  - transcript: quiz.yml
+//	    weight: 3
+//	    partial: true
+type Rubric struct {
+	// TimeLimit limits the time to run all cases against a bot. Zero means no
+	// limit.
+	TimeLimit time.Duration `yaml:"time_limit"`
+	// Concurrency is the number of bots graded at the same time. Defaults to
+	// DefaultConcurrency.
+	Concurrency int    `yaml:"concurrency"`
+	Cases       []Case `yaml:"cases"`
+}
+
+// Case is a transcript in a rubric.
+type Case struct {
+	// Transcript is the path of the transcript file, relative to the rubric.
+	Transcript string `yaml:"transcript"`
+	// Weight is the points of the case. Defaults to 1.
+	Weight float64 `yaml:"weight"`
+	// Partial gives points in proportion to passed steps, instead of all or
+	// nothing.
+	Partial bool `yaml:"partial"`
+
+	t *Transcript
+}
+
+// LoadRubric reads a rubric file and the transcripts in it.
+func LoadRubric(path string) (*Rubric, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Rubric
+	if err := yaml.UnmarshalStrict(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: parse: %w", path, err)
+	}
+	if len(r.Cases) == 0 {
+		return nil, fmt.Errorf("%s: no cases", path)
+	}
+	dir := filepath.Dir(path)
+	for i := range r.Cases {
+		c := &r.Cases[i]
+		if c.Weight < 0 {
+			return nil, fmt.Errorf("%s: case %d: negative weight", path, i+1)
+		}
+		if c.Weight == 0 {
+			c.Weight = 1
+		}
+		p := c.Transcript
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		t, err := Load(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: case %d: %w", path, i+1, err)
+		}
+		c.t = t
+	}
+	return &r, nil
+}
+
+// MaxScore returns the sum of weights.
+func (r *Rubric) MaxScore() float64 {
+	var sum float64
+	for _, c := range r.Cases {
+		sum += c.Weight
+	}
+	return sum
+}
+
+// Grade is the grade of a bot.
+type Grade struct {
+	BotID    string      `json:"botID"`
+	BotName  string      `json:"botName,omitempty"`
+	Score    float64     `json:"score"`
+	MaxScore float64     `json:"maxScore"`
+	Cases    []CaseGrade `json:"cases"`
+}
+
+// Percent returns the score in percent.
+func (g *Grade) Percent() float64 {
+	if g.MaxScore == 0 {
+		return 0
+	}
+	return g.Score / g.MaxScore * 100
+}
+
+// Failures describes failed cases, e.g. `quiz: step 2: expected ...`.
+func (g *Grade) Failures() []string {
+	var fs []string
+	for _, cg := range g.Cases {
+		if cg.Result.Error != "" {
+			fs = append(fs, cg.Name+": "+cg.Result.Error)
+		}
+		for _, st := range cg.Result.Steps {
+			if !st.Passed && !st.Skipped {
+				fs = append(fs, fmt.Sprintf("%s: %s: %s", cg.Name, st.Name(), st.Failure))
+			}
+		}
+	}
+	return fs
+}
+
+// CaseGrade is the grade of a case.
+type CaseGrade struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Score  float64 `json:"score"`
+	Result Result  `json:"result"`
+}
+
+// GradeBot runs the rubric's cases against the bot, one by one, within the
+// rubric's time limit.
+func GradeBot(ctx context.Context, c *client.Client, bot BotRef, r *Rubric) Grade {
+	g := Grade{BotID: bot.ID, BotName: bot.Name, MaxScore: r.MaxScore()}
+	if r.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.TimeLimit)
+		defer cancel()
+	}
+	for _, cs := range r.Cases {
+		cg := CaseGrade{Name: cs.t.Name, Weight: cs.Weight}
+		if ctx.Err() != nil {
+			cg.Result = Result{Name: cs.t.Name, File: cs.t.File, BotID: bot.ID, Error: timeLimitError(ctx)}
+		} else {
+			cg.Result = Run(ctx, c, bot.ID, cs.t)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !cg.Result.Passed && cg.Result.Error == "" {
+				cg.Result.Error = timeLimitError(ctx)
+			}
+		}
+		cg.Score = cs.score(cg.Result)
+		g.Score += cg.Score
+		g.Cases = append(g.Cases, cg)
+	}
+	return g
+}
+
+func timeLimitError(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "time limit exceeded"
+	}
+	return ctx.Err().Error()
+}
+
+func (cs Case) score(res Result) float64 {
+	if res.Passed {
+		return cs.Weight
+	}
+	if !cs.Partial || len(res.Steps) == 0 {
+		return 0
+	}
+	passed := 0
+	for _, st := range res.Steps {
+		if st.Passed {
+			passed++
+		}
+	}
+	return cs.Weight * float64(passed) / float64(len(res.Steps))
+}
+
+// BotRef identifies a bot to grade. Name is only for reports.
+type BotRef struct {
+	ID   string
+	Name string
+}
+
+// GradeBots grades bots concurrently, up to the rubric's concurrency. Grades
+// are returned in the order of bots. onGrade, if not nil, is called as each
+// bot is graded.
+func GradeBots(ctx context.Context, c *client.Client, bots []BotRef, r *Rubric, onGrade func(g Grade)) []Grade {
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	grades := make([]Grade, len(bots))
+	sem := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, bot := range bots {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, bot BotRef) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			g := GradeBot(ctx, c, bot, r)
+			grades[i] = g
+			if onGrade != nil {
+				mu.Lock()
+				onGrade(g)
+				mu.Unlock()
+			}
+		}(i, bot)
+	}
+	wg.Wait()
+	return grades
+}
+
+// WriteGradebookCSV writes grades as CSV, with a row per bot and a column per
+// case.
+func WriteGradebookCSV(w io.Writer, r *Rubric, grades []Grade) error {
+	cw := csv.NewWriter(w)
+	header := []string{"bot_id", "bot_name", "score", "max_score", "percent"}
+	for _, cs := range r.Cases {
+		header = append(header, cs.t.Name)
+	}
+	header = append(header, "failures")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, g := range grades {
+		row := []string{
+			g.BotID,
+			g.BotName,
+			formatScore(g.Score),
+			formatScore(g.MaxScore),
+			strconv.FormatFloat(g.Percent(), 'f', 1, 64),
+		}
+		for i := range r.Cases {
+			if i < len(g.Cases) {
+				row = append(row, formatScore(g.Cases[i].Score))
+			} else {
+				row = append(row, "")
+			}
+		}
+		row = append(row, strings.Join(g.Failures(), "; "))
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGradebookJSON writes grades as JSON.
+func WriteGradebookJSON(w io.Writer, grades []Grade) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"grades": grades})
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,128 @@
+package transcript
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteSummary writes a human readable summary of results.
+func WriteSummary(w io.Writer, results []Result) error {
+	var b strings.Builder
+	passed := 0
+	for _, res := range results {
+		status := "PASS"
+		if res.Passed {
+			passed++
+		} else {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&b, "%s  %s (%s)\n", status, res.Name, res.Duration.Round(time.Millisecond))
+		if res.Error != "" {
+			fmt.Fprintf(&b, "      error: %s\n", res.Error)
+		}
+		for _, st := range res.Steps {
+			switch {
+			case st.Skipped:
+				fmt.Fprintf(&b, "  -   %s (skipped)\n", st.Name())
+			case st.Passed:
+				fmt.Fprintf(&b, "  ok  %s\n", st.Name())
+			default:
+				fmt.Fprintf(&b, "  x   %s\n", st.Name())
+				fmt.Fprintf(&b, "      %s\n", st.Failure)
+			}
+		}
+	}
+	fmt.Fprintf(&b, "\n%d of %d transcripts passed\n", passed, len(results))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes results as JSON.
+func WriteJSON(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"results": results})
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes results as JUnit XML, with a test suite per transcript and
+// a test case per step.
+func WriteJUnit(w io.Writer, results []Result) error {
+	var suites junitTestSuites
+	for _, res := range results {
+		suite := junitTestSuite{
+			Name: res.Name,
+			Time: fmt.Sprintf("%.3f", res.Duration.Seconds()),
+		}
+		if res.Error != "" {
+			suite.Errors++
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "run",
+				ClassName: res.Name,
+				Time:      suite.Time,
+				Error:     &junitMessage{Message: res.Error},
+			})
+		}
+		for _, st := range res.Steps {
+			tc := junitTestCase{
+				Name:      st.Name(),
+				ClassName: res.Name,
+				Time:      fmt.Sprintf("%.3f", st.Duration.Seconds()),
+				SystemOut: strings.Join(st.Replies, "\n"),
+			}
+			switch {
+			case st.Skipped:
+				suite.Skipped++
+				tc.Skipped = &junitMessage{Message: "an earlier step failed"}
+			case !st.Passed:
+				suite.Failures++
+				tc.Failure = &junitMessage{Message: st.Failure}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suite.Tests = len(suite.Cases)
+		suites.Suites = append(suites.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
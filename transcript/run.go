@@ -0,0 +1,173 @@
+package transcript
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hallazzang/easybot"
+	"github.com/hallazzang/easybot/client"
+)
+
+// DefaultPollInterval is how often replies are polled while waiting, if
+// Replies.PollInterval is zero.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// Result is the result of running a transcript.
+type Result struct {
+	Name     string        `json:"name"`
+	File     string        `json:"file,omitempty"`
+	BotID    string        `json:"botID"`
+	RoomID   string        `json:"roomID,omitempty"`
+	Passed   bool          `json:"passed"`
+	Error    string        `json:"error,omitempty"` // set if the transcript couldn't run.
+	Steps    []StepResult  `json:"steps"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Failures returns the number of failed steps.
+func (r *Result) Failures() int {
+	n := 0
+	for _, st := range r.Steps {
+		if !st.Passed && !st.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// StepResult is the result of a step.
+type StepResult struct {
+	Step     int           `json:"step"` // 1-based.
+	Say      string        `json:"say,omitempty"`
+	Passed   bool          `json:"passed"`
+	Skipped  bool          `json:"skipped,omitempty"` // an earlier step failed.
+	Failure  string        `json:"failure,omitempty"`
+	Replies  []string      `json:"replies,omitempty"` // replies received in the step.
+	Duration time.Duration `json:"duration"`
+}
+
+// Name describes the step, e.g. `step 2: say "hello"`.
+func (r StepResult) Name() string {
+	if r.Say == "" {
+		return fmt.Sprintf("step %d", r.Step)
+	}
+	return fmt.Sprintf("step %d: say %q", r.Step, r.Say)
+}
+
+// Run runs t against the bot in a fresh room. Once a step fails, the rest of
+// the steps are skipped, since the conversation is out of sync.
+func Run(ctx context.Context, c *client.Client, botID string, t *Transcript) (res Result) {
+	start := time.Now()
+	res = Result{Name: t.Name, File: t.File, BotID: botID}
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	room, err := c.CreateRoom(ctx, botID)
+	if err != nil {
+		res.Error = fmt.Sprintf("create room: %v", err)
+		return res
+	}
+	res.RoomID = room.ID
+
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	r := &runner{room: room, replies: &Replies{Room: room}}
+	res.Passed = true
+	for i, st := range t.Steps {
+		sr := StepResult{Step: i + 1, Say: st.Say}
+		if !res.Passed {
+			sr.Skipped = true
+			res.Steps = append(res.Steps, sr)
+			continue
+		}
+		stepStart := time.Now()
+		sr.Replies, sr.Failure = r.runStep(ctx, st, timeout)
+		sr.Passed = sr.Failure == ""
+		sr.Duration = time.Since(stepStart)
+		res.Steps = append(res.Steps, sr)
+		if !sr.Passed {
+			res.Passed = false
+		}
+	}
+	return res
+}
+
+// runner talks to a bot in a room as the user.
+type runner struct {
+	room    *client.Room
+	replies *Replies
+}
+
+// runStep says the step's text and matches replies. It returns the replies
+// received and a failure message, which is empty if the step passed.
+func (r *runner) runStep(ctx context.Context, st Step, timeout time.Duration) ([]string, string) {
+	if st.Say != "" {
+		if err := r.room.WriteMessages(ctx, []easybot.MessageRequest{{Text: st.Say}}); err != nil {
+			return nil, fmt.Sprintf("say: %v", err)
+		}
+	}
+	var replies []string
+	for i := range st.Expect {
+		e := &st.Expect[i]
+		within := e.Within
+		if within == 0 {
+			within = timeout
+		}
+		msg, ok, err := r.replies.Next(ctx, within)
+		if err != nil {
+			return replies, fmt.Sprintf("read replies: %v", err)
+		}
+		if !ok {
+			return replies, fmt.Sprintf("expected reply %d to be %s, got no reply within %s", i+1, e, within)
+		}
+		replies = append(replies, msg.Text)
+		if !e.Match(msg.Text) {
+			return replies, fmt.Sprintf("expected reply %d to be %s, got %q", i+1, e, msg.Text)
+		}
+	}
+	return replies, ""
+}
+
+// Replies reads the bot's replies in a room one at a time, as the user.
+type Replies struct {
+	Room *client.Room
+	// PollInterval is how often replies are polled while waiting. Defaults
+	// to DefaultPollInterval.
+	PollInterval time.Duration
+
+	pending []easybot.MessageResponse // read but not yet returned.
+}
+
+// Next waits for the next reply, up to timeout. It returns false if no reply
+// arrived in time.
+func (r *Replies) Next(ctx context.Context, timeout time.Duration) (easybot.MessageResponse, bool, error) {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	deadline := time.Now().Add(timeout)
+	for len(r.pending) == 0 {
+		msgs, err := r.Room.ReadMessages(ctx, false)
+		if err != nil {
+			return easybot.MessageResponse{}, false, err
+		}
+		r.pending = append(r.pending, msgs...)
+		if len(r.pending) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return easybot.MessageResponse{}, false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return easybot.MessageResponse{}, false, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	msg := r.pending[0]
+	r.pending = r.pending[1:]
+	return msg, true, nil
+}
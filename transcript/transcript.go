@@ -0,0 +1,163 @@
+// Package transcript runs scripted conversations against bots, e.g. to grade
+// them.
+//
+// A transcript is a YAML file of steps. In each step the user says something
+// and the bot's next replies are expected to match, in order:
+//
+//	name: greeting
+//	timeout: 5s
+//	steps:
+//	  - say: hello
+//	    expect:
+//	      - contains: Hello
+//	  - say: /quiz start easy
+//	    expect:
+//	      - regex: "^Question 1"
+//	        within: 10s
+//	      - exact: "What is 1+1?"
+//
+// Each run of a transcript uses a fresh room. Replies a step doesn't expect
+// are left for the next step.
+package transcript
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultTimeout is how long to wait for each reply when neither the
+// transcript nor the expectation sets a timeout.
+const DefaultTimeout = 5 * time.Second
+
+// Transcript is a scripted conversation.
+type Transcript struct {
+	Name string `yaml:"name"`
+	// Timeout is how long to wait for each reply. Defaults to DefaultTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+	Steps   []Step        `yaml:"steps"`
+
+	// File is the path the transcript was loaded from.
+	File string `yaml:"-"`
+}
+
+// Step is a message from the user and the replies expected from the bot.
+// Say may be empty to only expect replies, e.g. a greeting.
+type Step struct {
+	Say    string   `yaml:"say"`
+	Expect []Expect `yaml:"expect"`
+}
+
+// Expect matches a reply. Exactly one of Exact, Contains and Regex must be
+// set.
+type Expect struct {
+	Exact    string `yaml:"exact"`
+	Contains string `yaml:"contains"`
+	Regex    string `yaml:"regex"`
+	// Within overrides the transcript's timeout.
+	Within time.Duration `yaml:"within"`
+
+	re *regexp.Regexp
+}
+
+// Load reads a transcript file.
+func Load(path string) (*Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.File = path
+	if t.Name == "" {
+		t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return t, nil
+}
+
+// Parse parses a transcript in YAML and validates it.
+func Parse(data []byte) (*Transcript, error) {
+	var t Transcript
+	if err := yaml.UnmarshalStrict(data, &t); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Validate checks the steps and compiles regular expressions.
+func (t *Transcript) Validate() error {
+	if len(t.Steps) == 0 {
+		return errors.New("no steps")
+	}
+	for i := range t.Steps {
+		st := &t.Steps[i]
+		if st.Say == "" && len(st.Expect) == 0 {
+			return fmt.Errorf("step %d: say or expect is required", i+1)
+		}
+		for j := range st.Expect {
+			if err := st.Expect[j].compile(); err != nil {
+				return fmt.Errorf("step %d: expect %d: %w", i+1, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *Expect) compile() error {
+	n := 0
+	for _, s := range []string{e.Exact, e.Contains, e.Regex} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of exact, contains and regex is required")
+	}
+	if e.Regex != "" {
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("compile regex: %w", err)
+		}
+		e.re = re
+	}
+	return nil
+}
+
+// Match reports whether text matches the expectation.
+func (e *Expect) Match(text string) bool {
+	switch {
+	case e.Exact != "":
+		return text == e.Exact
+	case e.Contains != "":
+		return strings.Contains(text, e.Contains)
+	case e.re != nil:
+		return e.re.MatchString(text)
+	case e.Regex != "":
+		re, err := regexp.Compile(e.Regex)
+		return err == nil && re.MatchString(text)
+	}
+	return false
+}
+
+// String describes the expectation, e.g. `contains "Hello"`.
+func (e *Expect) String() string {
+	switch {
+	case e.Exact != "":
+		return fmt.Sprintf("exact %q", e.Exact)
+	case e.Contains != "":
+		return fmt.Sprintf("contains %q", e.Contains)
+	default:
+		return fmt.Sprintf("regex %q", e.Regex)
+	}
+}